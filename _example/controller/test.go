@@ -31,6 +31,13 @@ func (this *TestControllerClass) PostTest(apiSession *api_session.ApiSessionClas
 	return params
 }
 
+// 带类型的处理器，参数自动绑定，Params/Return 由签名推断
+func (this *TestControllerClass) PostTyped(apiSession *api_session.ApiSessionClass, params TestParam) (*TestReturn, error) {
+	return &TestReturn{
+		Test: params.Token,
+	}, nil
+}
+
 type Test1Param struct {
 	Haha uint64 `json:"haha" validate:"omitempty" desc:"haha desc" default:"100"`
 	Xixi string `json:"xixi,omitempty" validate:"omitempty" desc:"xixi desc" default:"100"`
@@ -3,7 +3,6 @@ package external_service
 import "github.com/pefish/go-core/driver/external-service"
 
 type DepositAddressServiceClass struct {
-	baseUrl string
 	external_service.BaseExternalServiceClass
 }
 
@@ -11,12 +10,12 @@ var DepositAddressService = DepositAddressServiceClass{}
 
 
 func (this *DepositAddressServiceClass) Init(driver *external_service.ExternalServiceDriverClass) {
-	this.baseUrl = `http://baidu.com`
+
 }
 
 func (this *DepositAddressServiceClass) Test(series string, address string) interface{} {
-	path := ``
-	return this.PostJson(this.baseUrl + path, map[string]interface{}{
+	path := `/`
+	return this.PostJson(path, map[string]interface{}{ // 节点地址由注册时设置的服务发现提供
 		`series`:  series,
 		`address`: address,
 	})
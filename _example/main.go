@@ -49,6 +49,7 @@ func main() {
 	global_api_strategy.GlobalRateLimitStrategy.SetErrorCode(10000)
 	global_api_strategy2.GlobalApiStrategyDriver.Register(global_api_strategy2.GlobalStrategyData{
 		Strategy: &global_api_strategy.GlobalRateLimitStrategy,
+		Before:   global_api_strategy.ServiceBaseInfoApiStrategy.GetName(), // 在读取请求体之前限流
 		Param:    global_api_strategy.GlobalRateLimitStrategyParam{
 			FillInterval: 1000 * time.Millisecond,
 		},
@@ -58,7 +59,12 @@ func main() {
 	go_logger.Logger = go_logger.NewLogger(go_logger.WithIsDebug(go_application.Application.Debug))
 	logger.LoggerDriver.Register(go_logger.Logger)
 
-	external_service.ExternalServiceDriver.Register(`deposit_address`, &external_service2.DepositAddressService)
+	external_service.ExternalServiceDriver.Register(`deposit_address`, &external_service2.DepositAddressService, external_service.WithPolicy(external_service.Policy{
+		ReadTimeout: 5 * time.Second,
+		MaxRetries:  2,
+	}), external_service.WithDiscovery(external_service.NewStaticResolver(`http://baidu.com`), external_service.DiscoveryOption{
+		Balancer: external_service.NewLeastInflightBalancer(),
+	}))
 
 	//go_mysql.MysqlHelper.ConnectWithMap(go_config.Config.MustGetMap(`mysql`))
 
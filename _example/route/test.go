@@ -97,4 +97,11 @@ var TestRoute = []*api.Api{
 			},
 		},
 	},
+	{
+		Description:     "这是带类型处理器的测试路由",
+		Path:            "/v1/test_typed",
+		Method:          `POST`,
+		ParamType:       global_api_strategy.JSON_TYPE,
+		TypedController: controller.TestController.PostTyped,
+	},
 }
@@ -0,0 +1,9 @@
+package api_session
+
+// 接口返回的统一结构
+type ApiResult struct {
+	Msg         string      `json:"msg"`
+	InternalMsg string      `json:"internal_msg"`
+	Code        uint64      `json:"code"`
+	Data        interface{} `json:"data"`
+}
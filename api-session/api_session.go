@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"github.com/mitchellh/mapstructure"
 	_interface "github.com/pefish/go-core/api-session/interface"
+	"github.com/pefish/go-core/driver/logger"
+	"github.com/pefish/go-core/upload"
+	go_error "github.com/pefish/go-error"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -18,6 +20,7 @@ const (
 	ApiMethod_Post   ApiMethod = `POST`
 	ApiMethod_Get    ApiMethod = `GET`
 	ApiMethod_Option ApiMethod = `OPTIONS`
+	ApiMethod_Head   ApiMethod = `HEAD`
 	ApiMethod_All    ApiMethod = `ALL`
 )
 
@@ -119,6 +122,56 @@ const (
 	HeaderName_AcceptEncoding HeaderName = "Accept-Encoding"
 	// VaryHeaderKey is the header key of "Vary".
 	HeaderName_Vary HeaderName = "Vary"
+	// AcceptHeaderKey is the header key of "Accept".
+	HeaderName_Accept HeaderName = "Accept"
+	// OriginHeaderKey is the header key of "Origin".
+	HeaderName_Origin HeaderName = "Origin"
+	// ConnectionHeaderKey is the header key of "Connection".
+	HeaderName_Connection HeaderName = "Connection"
+	// XForwardedForHeaderKey is the header key of "X-Forwarded-For".
+	HeaderName_XForwardedFor HeaderName = "X-Forwarded-For"
+	// XAccelBufferingHeaderKey is the header key of "X-Accel-Buffering", nginx buffering switch.
+	HeaderName_XAccelBuffering HeaderName = "X-Accel-Buffering"
+	// LastEventIdHeaderKey is the header key of "Last-Event-ID", sent by reconnecting event stream clients.
+	HeaderName_LastEventId HeaderName = "Last-Event-ID"
+
+	// RangeHeaderKey is the header key of "Range".
+	HeaderName_Range HeaderName = "Range"
+	// AcceptRangesHeaderKey is the header key of "Accept-Ranges".
+	HeaderName_AcceptRanges HeaderName = "Accept-Ranges"
+	// ContentRangeHeaderKey is the header key of "Content-Range".
+	HeaderName_ContentRange HeaderName = "Content-Range"
+	// IfNoneMatchHeaderKey is the header key of "If-None-Match".
+	HeaderName_IfNoneMatch HeaderName = "If-None-Match"
+
+	// AccessControlAllowOriginHeaderKey is the header key of "Access-Control-Allow-Origin".
+	HeaderName_AccessControlAllowOrigin HeaderName = "Access-Control-Allow-Origin"
+	// AccessControlAllowMethodsHeaderKey is the header key of "Access-Control-Allow-Methods".
+	HeaderName_AccessControlAllowMethods HeaderName = "Access-Control-Allow-Methods"
+	// AccessControlAllowHeadersHeaderKey is the header key of "Access-Control-Allow-Headers".
+	HeaderName_AccessControlAllowHeaders HeaderName = "Access-Control-Allow-Headers"
+	// AccessControlAllowCredentialsHeaderKey is the header key of "Access-Control-Allow-Credentials".
+	HeaderName_AccessControlAllowCredentials HeaderName = "Access-Control-Allow-Credentials"
+	// AccessControlRequestMethodHeaderKey is the header key of "Access-Control-Request-Method".
+	HeaderName_AccessControlRequestMethod HeaderName = "Access-Control-Request-Method"
+	// AccessControlRequestHeadersHeaderKey is the header key of "Access-Control-Request-Headers".
+	HeaderName_AccessControlRequestHeaders HeaderName = "Access-Control-Request-Headers"
+	// IdempotencyKeyHeaderKey is the header key of "Idempotency-Key".
+	HeaderName_IdempotencyKey HeaderName = "Idempotency-Key"
+	// IdempotentReplayedHeaderKey is the header key of "Idempotent-Replayed".
+	HeaderName_IdempotentReplayed HeaderName = "Idempotent-Replayed"
+	// XAppIdHeaderKey is the header key of "X-App-Id".
+	HeaderName_XAppId HeaderName = "X-App-Id"
+	// XTimestampHeaderKey is the header key of "X-Timestamp".
+	HeaderName_XTimestamp HeaderName = "X-Timestamp"
+	// XNonceHeaderKey is the header key of "X-Nonce".
+	HeaderName_XNonce HeaderName = "X-Nonce"
+	// XSignatureHeaderKey is the header key of "X-Signature".
+	HeaderName_XSignature HeaderName = "X-Signature"
+	// XApiKeyHeaderKey is the header key of "X-Api-Key".
+	HeaderName_XApiKey HeaderName = "X-Api-Key"
+	// XCsrfTokenHeaderKey is the header key of "X-Csrf-Token".
+	HeaderName_XCsrfToken HeaderName = "X-Csrf-Token"
 )
 
 type ContentTypeValue string
@@ -143,53 +196,82 @@ const (
 )
 
 type ApiSessionClass struct {
-	statusCode StatusCode
+	statusCode     StatusCode
+	responseWriter *ResponseWriterClass // 最外层的响应，记录是否已经开始写入
 
 	Api            _interface.InterfaceApi
 	ResponseWriter http.ResponseWriter
 	Request        *http.Request
+	Logger         logger.InterfaceLogger // 所属服务的日志器
+	Serializer     InterfaceSerializer    // 根据 Accept 协商出的响应格式，WriteResult 使用
 
 	JwtHeaderName string
 	JwtBody       map[string]interface{}
 	UserId        uint64
+	AppId         string            // 签名策略验证通过的调用方
+	ApiKeyOwner   string            // api key 鉴权通过后 key 的所有者
+	Scopes        []string          // api key 拥有的权限范围
+	Roles         []string          // 授权策略解析出的角色
+	Permissions   []string          // 授权策略解析出的权限（包括角色拥有的权限）
+	UserSession   *UserSessionClass // cookie 会话策略加载的服务端会话
 
 	Lang       string
 	ClientType string // web、android、ios
 
 	Datas map[string]interface{}
 
-	OriginalParams map[string]interface{} // 客户端传过来的原始参数
-	Params         map[string]interface{} // 经过前置处理器修饰过的参数
+	OriginalParams map[string]interface{}         // 客户端传过来的原始参数
+	Params         map[string]interface{}         // 经过前置处理器修饰过的参数
+	ParamDecoder   *ParamDecoderClass             // ScanParams 使用的解码器，参数校验策略会设置成自己的解码器
+	Files          map[string][]*upload.FileClass // multipart 请求上传的文件，按字段名分组
 
 	Defers []func() // api结束后执行的函数
+
+	Error *go_error.ErrorInfo // 请求处理过程中捕获到的错误，没有出错则为nil。后置策略可以通过它拿到错误详情
 }
 
 func NewApiSession() *ApiSessionClass {
 	return &ApiSessionClass{
-		Datas: map[string]interface{}{},
+		Datas:        map[string]interface{}{},
+		Logger:       logger.LoggerDriver.Logger,
+		ParamDecoder: ParamDecoder,
+		Serializer:   &JsonSerializer,
 	}
 }
 
-func (apiSession *ApiSessionClass) ScanParams(dest interface{}) {
-	config := &mapstructure.DecoderConfig{
-		WeaklyTypedInput: true,
-		TagName:          "json",
-		Result:           &dest,
+// 是否拥有权限范围。* 表示所有权限，orders:* 表示 orders: 开头的所有权限
+func (apiSession *ApiSessionClass) HasScope(scope string) bool {
+	for _, owned := range apiSession.Scopes {
+		if owned == `*` || owned == scope {
+			return true
+		}
+		if strings.HasSuffix(owned, `*`) && strings.HasPrefix(scope, strings.TrimSuffix(owned, `*`)) {
+			return true
+		}
 	}
+	return false
+}
 
-	decoder, err := mapstructure.NewDecoder(config)
-	if err != nil {
-		panic(err)
-	}
+// 把参数解码到 dest（结构体指针）。解码失败的话以参数校验错误的格式抛出
+func (apiSession *ApiSessionClass) ScanParams(dest interface{}) {
+	apiSession.ParamDecoder.MustDecode(apiSession.Params, dest)
+}
 
-	err = decoder.Decode(apiSession.Params)
-	if err != nil {
-		panic(err)
+// 获取上传的文件，没有的话返回nil。同一字段有多个文件时返回第一个
+func (apiSession *ApiSessionClass) GetFile(fieldName string) *upload.FileClass {
+	if files := apiSession.Files[fieldName]; len(files) > 0 {
+		return files[0]
 	}
+	return nil
+}
+
+// 获取同一字段上传的所有文件
+func (apiSession *ApiSessionClass) GetFiles(fieldName string) []*upload.FileClass {
+	return apiSession.Files[fieldName]
 }
 
 // Add defer handler.
-// Defer handlers will be executed by order at the end of api session.
+// Defer handlers will be executed in reverse order at the end of api session, after the response is written.
 func (apiSession *ApiSessionClass) AddDefer(defer_ func()) {
 	apiSession.Defers = append(apiSession.Defers, defer_)
 }
@@ -209,6 +291,25 @@ func (apiSession *ApiSessionClass) WriteJson(data interface{}) error {
 	return nil
 }
 
+// 按协商出的格式写入响应体
+func (apiSession *ApiSessionClass) WriteResult(data interface{}) error {
+	serializer := apiSession.Serializer
+	if serializer == nil {
+		serializer = &JsonSerializer
+	}
+	result, err := serializer.Marshal(data)
+	if err != nil {
+		return err
+	}
+	apiSession.SetHeader(string(HeaderName_ContentType), serializer.GetContentType())
+	apiSession.ResponseWriter.WriteHeader(int(apiSession.statusCode))
+	_, err = apiSession.ResponseWriter.Write(result)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // Set header of response.
 func (apiSession *ApiSessionClass) SetHeader(key string, value string) {
 	apiSession.ResponseWriter.Header().Set(key, value)
@@ -230,6 +331,11 @@ func (apiSession *ApiSessionClass) SetStatusCode(code StatusCode) {
 	apiSession.statusCode = code
 }
 
+// Get status code of response.
+func (apiSession *ApiSessionClass) GetStatusCode() StatusCode {
+	return apiSession.statusCode
+}
+
 // Get request path.
 func (apiSession *ApiSessionClass) GetPath() string {
 	return apiSession.Request.URL.Path
@@ -248,14 +354,14 @@ func (apiSession *ApiSessionClass) GetHeader(name string) string {
 // Read remote address from request headers.
 func (apiSession *ApiSessionClass) GetRemoteAddress() string {
 	remoteHeaders := map[string]bool{
-		`X-Forwarded-For`: true,
+		string(HeaderName_XForwardedFor): true,
 	}
 
 	for headerName, enabled := range remoteHeaders {
 		if enabled {
 			headerValue := apiSession.GetHeader(headerName)
 			// exception needed for 'X-Forwarded-For' only , if enabled.
-			if headerName == "X-Forwarded-For" {
+			if headerName == string(HeaderName_XForwardedFor) {
 				idx := strings.IndexByte(headerValue, ',')
 				if idx >= 0 {
 					headerValue = headerValue[0:idx]
@@ -333,3 +439,19 @@ func (apiSession *ApiSessionClass) ReadJSON(jsonObject interface{}) error {
 
 	return json.Unmarshal(rawData, jsonObject)
 }
+
+// 用指定的序列化器解析请求体
+func (apiSession *ApiSessionClass) ReadBody(serializer InterfaceSerializer, dest interface{}) error {
+	if apiSession.Request.Body == nil {
+		return errors.New("unmarshal: empty body")
+	}
+
+	rawData, err := ioutil.ReadAll(apiSession.Request.Body)
+	if err != nil {
+		return err
+	}
+
+	apiSession.Request.Body = ioutil.NopCloser(bytes.NewBuffer(rawData))
+
+	return serializer.Unmarshal(rawData, dest)
+}
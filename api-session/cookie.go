@@ -0,0 +1,176 @@
+package api_session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrCookieNotFound   = errors.New(`cookie not found`)
+	ErrInvalidCookie    = errors.New(`invalid cookie`) // 签名不对或者解密失败，可能被篡改过
+	ErrInvalidCookieKey = errors.New(`cookie key must be 16, 24 or 32 bytes`)
+)
+
+// 设置 cookie 的选项。Path 为空的话使用 /，MaxAge 为 0 的话是会话 cookie（浏览器关闭后失效）
+type CookieOption struct {
+	Path     string
+	Domain   string
+	MaxAge   int // 秒
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// 读取请求中的 cookie，不存在的话返回空字符串
+func (apiSession *ApiSessionClass) GetCookie(name string) string {
+	cookie, err := apiSession.Request.Cookie(name)
+	if err != nil {
+		return ``
+	}
+	return cookie.Value
+}
+
+// 设置响应的 cookie，option 为 nil 的话使用默认选项
+func (apiSession *ApiSessionClass) SetCookie(name string, value string, option *CookieOption) {
+	if option == nil {
+		option = &CookieOption{}
+	}
+	path := option.Path
+	if path == `` {
+		path = `/`
+	}
+	http.SetCookie(apiSession.ResponseWriter, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   option.Domain,
+		MaxAge:   option.MaxAge,
+		Secure:   option.Secure,
+		HttpOnly: option.HttpOnly,
+		SameSite: option.SameSite,
+	})
+}
+
+// 删除 cookie，Path 和 Domain 需要和设置时一致
+func (apiSession *ApiSessionClass) DeleteCookie(name string, option *CookieOption) {
+	deleteOption := CookieOption{}
+	if option != nil {
+		deleteOption = *option
+	}
+	deleteOption.MaxAge = -1
+	apiSession.SetCookie(name, ``, &deleteOption)
+}
+
+// 设置带签名（HMAC-SHA256）的 cookie，客户端可以看到内容但不能修改
+func (apiSession *ApiSessionClass) SetSignedCookie(name string, value string, key []byte, option *CookieOption) {
+	apiSession.SetCookie(name, SignCookieValue(name, value, key), option)
+}
+
+// 读取带签名的 cookie，不存在的话返回 ErrCookieNotFound，签名不对的话返回 ErrInvalidCookie
+func (apiSession *ApiSessionClass) GetSignedCookie(name string, key []byte) (string, error) {
+	value := apiSession.GetCookie(name)
+	if value == `` {
+		return ``, ErrCookieNotFound
+	}
+	return VerifyCookieValue(name, value, key)
+}
+
+// 设置加密（AES-GCM）的 cookie，客户端看不到内容也不能修改。key 为 16、24 或 32 字节
+func (apiSession *ApiSessionClass) SetEncryptedCookie(name string, value string, key []byte, option *CookieOption) error {
+	encrypted, err := EncryptCookieValue(name, value, key)
+	if err != nil {
+		return err
+	}
+	apiSession.SetCookie(name, encrypted, option)
+	return nil
+}
+
+// 读取加密的 cookie，不存在的话返回 ErrCookieNotFound，解密失败的话返回 ErrInvalidCookie
+func (apiSession *ApiSessionClass) GetEncryptedCookie(name string, key []byte) (string, error) {
+	value := apiSession.GetCookie(name)
+	if value == `` {
+		return ``, ErrCookieNotFound
+	}
+	return DecryptCookieValue(name, value, key)
+}
+
+// 签名 cookie 的值，格式为 base64(value).base64(hmac)。cookie 名参与签名，不能把值挪到别的 cookie 使用
+func SignCookieValue(name string, value string, key []byte) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+	return encoded + `.` + base64.RawURLEncoding.EncodeToString(cookieMac(name, encoded, key))
+}
+
+func VerifyCookieValue(name string, signed string, key []byte) (string, error) {
+	index := strings.LastIndexByte(signed, '.')
+	if index < 0 {
+		return ``, ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signed[index+1:])
+	if err != nil || !hmac.Equal(mac, cookieMac(name, signed[:index], key)) {
+		return ``, ErrInvalidCookie
+	}
+	value, err := base64.RawURLEncoding.DecodeString(signed[:index])
+	if err != nil {
+		return ``, ErrInvalidCookie
+	}
+	return string(value), nil
+}
+
+// 加密 cookie 的值，格式为 base64(nonce + 密文)。cookie 名作为附加数据
+func EncryptCookieValue(name string, value string, key []byte) (string, error) {
+	aead, err := newCookieAead(key)
+	if err != nil {
+		return ``, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return ``, err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func DecryptCookieValue(name string, encrypted string, key []byte) (string, error) {
+	aead, err := newCookieAead(key)
+	if err != nil {
+		return ``, err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return ``, ErrInvalidCookie
+	}
+	value, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return ``, ErrInvalidCookie
+	}
+	return string(value), nil
+}
+
+func cookieMac(name string, encodedValue string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(encodedValue))
+	return mac.Sum(nil)
+}
+
+func newCookieAead(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, ErrInvalidCookieKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf(`create cookie cipher error: %v`, err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,236 @@
+package api_session
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	go_decimal "github.com/pefish/go-decimal"
+	decimal "github.com/pefish/go-decimal/lib"
+	go_error "github.com/pefish/go-error"
+	go_reflect "github.com/pefish/go-reflect"
+)
+
+// 把原始输入转换成目标类型的值
+type DecodeHookFunc func(data interface{}) (interface{}, error)
+
+// 参数解码器。把请求参数解码到结构体，支持按类型注册转换函数、嵌入结构体展开、严格模式
+type ParamDecoderClass struct {
+	lock        sync.RWMutex
+	hooks       map[reflect.Type]DecodeHookFunc
+	timeFormats []string
+	strict      bool
+	errorCode   uint64
+}
+
+// 默认参数解码器。没有经过参数校验策略的请求使用它
+var ParamDecoder = NewParamDecoder()
+
+var (
+	timeType         = reflect.TypeOf(time.Time{})
+	durationType     = reflect.TypeOf(time.Duration(0))
+	rawMessageType   = reflect.TypeOf(json.RawMessage{})
+	decimalType      = reflect.TypeOf(decimal.Decimal{})
+	decimalClassType = reflect.TypeOf(go_decimal.DecimalClass{})
+	textUnmarshaler  = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	errorFieldRegexp = regexp.MustCompile(`'([^']*)'`)
+)
+
+func NewParamDecoder() *ParamDecoderClass {
+	decoder := &ParamDecoderClass{
+		hooks:       map[reflect.Type]DecodeHookFunc{},
+		timeFormats: []string{time.RFC3339Nano, `2006-01-02 15:04:05`, `2006-01-02`},
+		errorCode:   go_error.INTERNAL_ERROR_CODE,
+	}
+	decoder.RegisterHook(timeType, decoder.decodeTime)
+	decoder.RegisterHook(durationType, decodeDuration)
+	decoder.RegisterHook(rawMessageType, decodeRawMessage)
+	decoder.RegisterHook(decimalType, decodeDecimal)
+	decoder.RegisterHook(decimalClassType, func(data interface{}) (interface{}, error) {
+		result, err := decodeDecimal(data)
+		if err != nil {
+			return nil, err
+		}
+		return *go_decimal.Decimal.Start(result), nil
+	})
+	return decoder
+}
+
+// 注册目标类型的转换函数，已存在的会被覆盖
+func (this *ParamDecoderClass) RegisterHook(type_ reflect.Type, hook DecodeHookFunc) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.hooks[type_] = hook
+}
+
+// 注册枚举类型，参数只能是给出的值之一
+func (this *ParamDecoderClass) RegisterEnum(type_ reflect.Type, values ...interface{}) {
+	allowed := make([]string, 0, len(values))
+	for _, value := range values {
+		allowed = append(allowed, go_reflect.Reflect.MustToString(value))
+	}
+	this.RegisterHook(type_, func(data interface{}) (interface{}, error) {
+		str := go_reflect.Reflect.MustToString(data)
+		for _, value := range allowed {
+			if str == value {
+				result := reflect.New(type_)
+				if err := mapstructure.WeakDecode(data, result.Interface()); err != nil {
+					return nil, err
+				}
+				return result.Elem().Interface(), nil
+			}
+		}
+		return nil, fmt.Errorf(`must be one of [%s]`, strings.Join(allowed, `, `))
+	})
+}
+
+// 设置 time.Time 字段可以接受的时间格式，按顺序尝试
+func (this *ParamDecoderClass) SetTimeFormats(formats ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.timeFormats = formats
+}
+
+// 严格模式下，结构体中不存在的参数会报错
+func (this *ParamDecoderClass) SetStrict(strict bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.strict = strict
+}
+
+func (this *ParamDecoderClass) SetErrorCode(code uint64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.errorCode = code
+}
+
+func (this *ParamDecoderClass) GetErrorCode() uint64 {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.errorCode
+}
+
+// 是否按单个值解码的类型（注册了转换函数或实现了 encoding.TextUnmarshaler，如 time.Time、decimal），这类结构体不展开
+func (this *ParamDecoderClass) IsValueType(type_ reflect.Type) bool {
+	this.lock.RLock()
+	_, ok := this.hooks[type_]
+	this.lock.RUnlock()
+	return ok || reflect.PtrTo(type_).Implements(textUnmarshaler)
+}
+
+// 把参数解码到dest（结构体指针）
+func (this *ParamDecoderClass) Decode(params map[string]interface{}, dest interface{}) error {
+	if reflect.TypeOf(dest) == nil || reflect.TypeOf(dest).Kind() != reflect.Ptr {
+		return errors.New(`dest must be a pointer`)
+	}
+	this.lock.RLock()
+	strict := this.strict
+	this.lock.RUnlock()
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		TagName:          `json`,
+		Squash:           true, // 嵌入的结构体字段展开到同一层
+		ErrorUnused:      strict,
+		DecodeHook:       this.decodeHook,
+		Result:           dest,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(params)
+}
+
+// 解码参数，失败的话以参数校验错误的格式抛出，data 中带出错的字段名
+func (this *ParamDecoderClass) MustDecode(params map[string]interface{}, dest interface{}) {
+	err := this.Decode(params, dest)
+	if err == nil {
+		return
+	}
+	field := ``
+	message := err.Error()
+	if decodeErr, ok := err.(*mapstructure.Error); ok && len(decodeErr.Errors) > 0 {
+		message = strings.Join(decodeErr.Errors, `; `)
+		if matches := errorFieldRegexp.FindStringSubmatch(decodeErr.Errors[0]); matches != nil {
+			field = matches[1]
+		}
+	}
+	go_error.ThrowErrorWithData(message, this.GetErrorCode(), map[string]interface{}{
+		`field`: field,
+	}, err)
+}
+
+func (this *ParamDecoderClass) decodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if data == nil {
+		return data, nil
+	}
+	this.lock.RLock()
+	hook := this.hooks[to]
+	this.lock.RUnlock()
+	if hook != nil {
+		if from == to {
+			return data, nil
+		}
+		return hook(data)
+	}
+	// 实现了 encoding.TextUnmarshaler 的类型直接用字符串解析
+	if from.Kind() == reflect.String && to.Kind() != reflect.String && reflect.PtrTo(to).Implements(textUnmarshaler) {
+		result := reflect.New(to)
+		if err := result.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(data.(string))); err != nil {
+			return nil, err
+		}
+		return result.Elem().Interface(), nil
+	}
+	return data, nil
+}
+
+func (this *ParamDecoderClass) decodeTime(data interface{}) (interface{}, error) {
+	switch value := data.(type) {
+	case string:
+		this.lock.RLock()
+		formats := this.timeFormats
+		this.lock.RUnlock()
+		for _, format := range formats {
+			if result, err := time.ParseInLocation(format, value, time.Local); err == nil {
+				return result, nil
+			}
+		}
+		return nil, fmt.Errorf(`time %s can not be parsed by formats [%s]`, value, strings.Join(formats, `, `))
+	case float64, int, int64, uint64, json.Number: // 数字按unix秒处理
+		seconds, err := go_reflect.Reflect.ToInt64(value)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	return nil, fmt.Errorf(`time can not be decoded from %T`, data)
+}
+
+func decodeDuration(data interface{}) (interface{}, error) {
+	if str, ok := data.(string); ok {
+		return time.ParseDuration(str)
+	}
+	return data, nil // 数字按纳秒处理
+}
+
+func decodeRawMessage(data interface{}) (interface{}, error) {
+	result, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(result), nil
+}
+
+func decodeDecimal(data interface{}) (interface{}, error) {
+	str, err := go_reflect.Reflect.ToString(data)
+	if err != nil {
+		return nil, err
+	}
+	return decimal.NewFromString(str)
+}
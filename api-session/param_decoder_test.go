@@ -0,0 +1,65 @@
+package api_session
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	decimal "github.com/pefish/go-decimal/lib"
+	go_error "github.com/pefish/go-error"
+)
+
+type testStatus string
+
+type testBase struct {
+	Page uint64 `json:"page"`
+}
+
+type testParams struct {
+	testBase
+	CreatedAt time.Time       `json:"created_at"`
+	Timeout   time.Duration   `json:"timeout"`
+	Amount    decimal.Decimal `json:"amount"`
+	Extra     json.RawMessage `json:"extra"`
+	Ip        net.IP          `json:"ip"`
+	Status    testStatus      `json:"status"`
+}
+
+func TestParamDecoderClass_Decode(t *testing.T) {
+	decoder := NewParamDecoder()
+	decoder.RegisterEnum(reflect.TypeOf(testStatus(``)), `on`, `off`)
+	params := testParams{}
+	err := decoder.Decode(map[string]interface{}{
+		`page`:       `2`,
+		`created_at`: `2019-10-01 12:00:00`,
+		`timeout`:    `1m30s`,
+		`amount`:     0.1,
+		`extra`:      map[string]interface{}{`a`: 1},
+		`ip`:         `127.0.0.1`,
+		`status`:     `on`,
+	}, &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Page != 2 || params.CreatedAt.Day() != 1 || params.Timeout != 90*time.Second ||
+		params.Amount.String() != `0.1` || string(params.Extra) != `{"a":1}` || params.Ip.String() != `127.0.0.1` || params.Status != `on` {
+		t.Errorf(`unexpected result %#v`, params)
+	}
+
+	if err := decoder.Decode(map[string]interface{}{`status`: `unknown`}, &testParams{}); err == nil {
+		t.Error(`enum should be checked`)
+	}
+	decoder.SetStrict(true)
+	decoder.SetErrorCode(2005)
+	func() {
+		defer go_error.Recover(func(msg string, internalMsg string, code uint64, data interface{}, err interface{}) {
+			if code != 2005 {
+				t.Errorf(`unexpected code %d`, code)
+			}
+		})
+		decoder.MustDecode(map[string]interface{}{`unknown`: 1}, &testParams{})
+		t.Error(`unknown field should be rejected in strict mode`)
+	}()
+}
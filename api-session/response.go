@@ -0,0 +1,177 @@
+package api_session
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 记录响应是否已经开始写入的 ResponseWriter。开始写入后出错的话不能再返回统一结构
+type ResponseWriterClass struct {
+	http.ResponseWriter
+	wroteHeader bool
+	size        int64
+}
+
+func NewResponseWriter(writer http.ResponseWriter) *ResponseWriterClass {
+	return &ResponseWriterClass{
+		ResponseWriter: writer,
+	}
+}
+
+func (this *ResponseWriterClass) WriteHeader(statusCode int) {
+	this.wroteHeader = true
+	this.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (this *ResponseWriterClass) Write(data []byte) (int, error) {
+	this.wroteHeader = true
+	n, err := this.ResponseWriter.Write(data)
+	this.size += int64(n)
+	return n, err
+}
+
+// 是否已经写入了响应头
+func (this *ResponseWriterClass) IsWritten() bool {
+	return this.wroteHeader
+}
+
+// 已经写入的响应体字节数
+func (this *ResponseWriterClass) GetSize() int64 {
+	return this.size
+}
+
+func (this *ResponseWriterClass) Flush() {
+	this.wroteHeader = true
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (this *ResponseWriterClass) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := this.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New(`response writer does not implement http.Hijacker`)
+	}
+	this.wroteHeader = true
+	return hijacker.Hijack()
+}
+
+// 设置响应并记录是否开始写入。策略之后可以再包装 ResponseWriter
+func (apiSession *ApiSessionClass) SetResponseWriter(writer http.ResponseWriter) {
+	apiSession.responseWriter = NewResponseWriter(writer)
+	apiSession.ResponseWriter = apiSession.responseWriter
+}
+
+// 响应是否已经开始写入。开始写入后不能再改变状态码和响应头
+func (apiSession *ApiSessionClass) IsResponseStarted() bool {
+	if writer, ok := apiSession.ResponseWriter.(interface{ IsWritten() bool }); ok && writer.IsWritten() {
+		return true
+	}
+	return apiSession.responseWriter != nil && apiSession.responseWriter.IsWritten()
+}
+
+// 设置 Content-Disposition，浏览器会以 fileName 下载。非 ASCII 文件名按 RFC 6266 编码
+func (apiSession *ApiSessionClass) SetAttachment(fileName string) {
+	disposition := mime.FormatMediaType(`attachment`, map[string]string{
+		`filename`: fileName,
+	})
+	if disposition == `` { // 文件名不能直接放进参数
+		disposition = fmt.Sprintf(`attachment; filename*=UTF-8''%s`, url.PathEscape(fileName))
+	}
+	apiSession.SetHeader(string(HeaderName_ContentDisposition), disposition)
+}
+
+/**
+发送可以随机读取的内容，支持 Range（206）、If-Modified-Since、If-None-Match。
+name 用于推断 Content-Type（没有设置的话）；modTime 不为零值时设置 Last-Modified；
+需要 ETag 的话在调用前设置 ETag 头。控制器调用后返回nil
+*/
+func (apiSession *ApiSessionClass) ServeContent(name string, modTime time.Time, content io.ReadSeeker) {
+	http.ServeContent(apiSession.ResponseWriter, apiSession.Request, name, modTime, content)
+}
+
+// 发送本地文件，downloadName 不为空的话作为附件下载。根据修改时间和大小设置 ETag。控制器调用后返回nil
+func (apiSession *ApiSessionClass) WriteFile(path string, downloadName string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf(`%s is a directory`, path)
+	}
+	if downloadName != `` {
+		apiSession.SetAttachment(downloadName)
+	}
+	apiSession.SetHeader(string(HeaderName_ETag), fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	apiSession.ServeContent(filepath.Base(path), info.ModTime(), file)
+	return nil
+}
+
+// 把 reader 中的内容以分块传输发送（不能随机读取的内容，如实时生成的导出文件）。控制器调用后返回nil
+func (apiSession *ApiSessionClass) WriteStream(contentType string, reader io.Reader) error {
+	writer := apiSession.StartChunked(contentType)
+	buffer := make([]byte, 32<<10)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if _, writeErr := writer.Write(buffer[:n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 开始分块传输，返回的 writer 每次写入都会立即发送给客户端。控制器写完后返回nil
+func (apiSession *ApiSessionClass) StartChunked(contentType string) *ChunkedWriterClass {
+	if contentType != `` {
+		apiSession.SetHeader(string(HeaderName_ContentType), contentType)
+	}
+	apiSession.ResponseWriter.Header().Del(string(HeaderName_ContentLength))
+	apiSession.ResponseWriter.WriteHeader(int(apiSession.statusCode))
+	flusher, _ := apiSession.ResponseWriter.(http.Flusher)
+	return &ChunkedWriterClass{
+		writer:  apiSession.ResponseWriter,
+		flusher: flusher,
+	}
+}
+
+type ChunkedWriterClass struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (this *ChunkedWriterClass) Write(data []byte) (int, error) {
+	n, err := this.writer.Write(data)
+	if err != nil {
+		return n, err
+	}
+	if this.flusher != nil {
+		this.flusher.Flush()
+	}
+	return n, nil
+}
+
+// 写入字符串
+func (this *ChunkedWriterClass) WriteString(str string) (int, error) {
+	return this.Write([]byte(str))
+}
@@ -0,0 +1,514 @@
+package api_session
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pefish/yaml"
+	"github.com/vmihailenco/msgpack"
+)
+
+// 序列化器。负责响应体的编码和请求体的解码
+type InterfaceSerializer interface {
+	GetName() string         // 格式名，路由的 Formats 使用这个名字
+	GetContentType() string  // 响应的 Content-Type
+	GetMediaTypes() []string // 匹配 Accept 和请求 Content-Type 的媒体类型
+	Marshal(data interface{}) ([]byte, error)
+	Unmarshal(data []byte, dest interface{}) error // dest 一般是 *map[string]interface{}
+}
+
+// 序列化器注册表，按 Accept 头协商响应格式
+type SerializerRegistryClass struct {
+	lock        sync.RWMutex
+	serializers []InterfaceSerializer
+}
+
+// 默认注册表，包含 json、xml、yaml、msgpack，json 是默认格式
+var Serializers = NewSerializerRegistry()
+
+func NewSerializerRegistry() *SerializerRegistryClass {
+	registry := &SerializerRegistryClass{}
+	registry.Register(&JsonSerializer)
+	registry.Register(&XmlSerializer)
+	registry.Register(&YamlSerializer)
+	registry.Register(&MsgpackSerializer)
+	return registry
+}
+
+// 注册序列化器，同名的会被替换。第一个注册的是默认格式
+func (this *SerializerRegistryClass) Register(serializer InterfaceSerializer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for i, existing := range this.serializers {
+		if existing.GetName() == serializer.GetName() {
+			this.serializers[i] = serializer
+			return
+		}
+	}
+	this.serializers = append(this.serializers, serializer)
+}
+
+func (this *SerializerRegistryClass) Get(name string) (InterfaceSerializer, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for _, serializer := range this.serializers {
+		if serializer.GetName() == name {
+			return serializer, true
+		}
+	}
+	return nil, false
+}
+
+// 按媒体类型（可以带参数，如 charset）查找，找不到返回nil
+func (this *SerializerRegistryClass) GetByMediaType(contentType string) InterfaceSerializer {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for _, serializer := range this.serializers {
+		for _, candidate := range serializer.GetMediaTypes() {
+			if candidate == mediaType {
+				return serializer
+			}
+		}
+	}
+	return nil
+}
+
+// 允许的序列化器，names 为空表示全部。第一个是默认格式
+func (this *SerializerRegistryClass) GetAllowed(names []string) []InterfaceSerializer {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if len(names) == 0 {
+		return append([]InterfaceSerializer{}, this.serializers...)
+	}
+	result := make([]InterfaceSerializer, 0, len(names))
+	for _, name := range names {
+		for _, serializer := range this.serializers {
+			if serializer.GetName() == name {
+				result = append(result, serializer)
+			}
+		}
+	}
+	return result
+}
+
+// 根据 Accept 头选择响应格式。按 q 值从高到低匹配，q 相同时更具体的优先。
+// */* 和没有注册序列化器的类型（如浏览器发送的 text/html）按默认格式处理，所以只有明确排在前面的格式才会替换默认格式。
+// Accept 为空时返回默认格式；都不接受的话返回nil（应当返回 406）
+func (this *SerializerRegistryClass) Negotiate(accept string, names []string) InterfaceSerializer {
+	allowed := this.GetAllowed(names)
+	if len(allowed) == 0 {
+		return nil
+	}
+	if strings.TrimSpace(accept) == `` {
+		return allowed[0]
+	}
+	ranges := parseAccept(accept)
+	registered := this.GetAllowed(nil)
+	for _, acceptRange := range ranges {
+		if acceptRange.q <= 0 {
+			continue
+		}
+		matchAny := acceptRange.mediaType == `*/*` || !acceptRange.matchAny(registered)
+		for _, serializer := range allowed {
+			if (matchAny || acceptRange.match(serializer)) && !isExcluded(ranges, serializer) {
+				return serializer
+			}
+		}
+	}
+	return nil
+}
+
+type acceptRange struct {
+	mediaType   string
+	q           float64
+	specificity int
+}
+
+func (this acceptRange) match(serializer InterfaceSerializer) bool {
+	for _, mediaType := range serializer.GetMediaTypes() {
+		if this.mediaType == `*/*` || this.mediaType == mediaType {
+			return true
+		}
+		if strings.HasSuffix(this.mediaType, `/*`) && strings.HasPrefix(mediaType, strings.TrimSuffix(this.mediaType, `*`)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this acceptRange) matchAny(serializers []InterfaceSerializer) bool {
+	for _, serializer := range serializers {
+		if this.match(serializer) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAccept(accept string) []acceptRange {
+	ranges := make([]acceptRange, 0)
+	for _, item := range strings.Split(accept, `,`) {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qStr, ok := params[`q`]; ok {
+			if parsed, err := strconv.ParseFloat(qStr, 64); err == nil {
+				q = parsed
+			}
+		}
+		specificity := 2
+		if mediaType == `*/*` {
+			specificity = 0
+		} else if strings.HasSuffix(mediaType, `/*`) {
+			specificity = 1
+		}
+		ranges = append(ranges, acceptRange{
+			mediaType:   mediaType,
+			q:           q,
+			specificity: specificity,
+		})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return ranges[i].specificity > ranges[j].specificity
+	})
+	return ranges
+}
+
+// 明确用 q=0 拒绝的格式不能被通配选中
+func isExcluded(ranges []acceptRange, serializer InterfaceSerializer) bool {
+	for _, acceptRange := range ranges {
+		if acceptRange.q > 0 || acceptRange.specificity != 2 {
+			continue
+		}
+		for _, mediaType := range serializer.GetMediaTypes() {
+			if acceptRange.mediaType == mediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type JsonSerializerClass struct {
+}
+
+var JsonSerializer = JsonSerializerClass{}
+
+func (this *JsonSerializerClass) GetName() string {
+	return `json`
+}
+
+func (this *JsonSerializerClass) GetContentType() string {
+	return string(ContentTypeValue_JSON)
+}
+
+func (this *JsonSerializerClass) GetMediaTypes() []string {
+	return []string{`application/json`}
+}
+
+func (this *JsonSerializerClass) Marshal(data interface{}) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+func (this *JsonSerializerClass) Unmarshal(data []byte, dest interface{}) error {
+	return json.Unmarshal(data, dest)
+}
+
+// xml 格式。响应的根节点是 response，请求的根节点名字不限；数组的元素节点名是 item
+type XmlSerializerClass struct {
+}
+
+var XmlSerializer = XmlSerializerClass{}
+
+func (this *XmlSerializerClass) GetName() string {
+	return `xml`
+}
+
+func (this *XmlSerializerClass) GetContentType() string {
+	return `application/xml; charset=UTF-8`
+}
+
+func (this *XmlSerializerClass) GetMediaTypes() []string {
+	return []string{`application/xml`, `text/xml`}
+}
+
+func (this *XmlSerializerClass) Marshal(data interface{}) ([]byte, error) {
+	generic, err := toGeneric(data)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bytes.NewBufferString(xml.Header)
+	writeXmlElement(buffer, `response`, generic)
+	return buffer.Bytes(), nil
+}
+
+// dest 是 *map[string]interface{} 时按通用结构解析，叶子节点都是字符串；其他类型使用 encoding/xml
+func (this *XmlSerializerClass) Unmarshal(data []byte, dest interface{}) error {
+	mapDest, ok := dest.(*map[string]interface{})
+	if !ok {
+		return xml.Unmarshal(data, dest)
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			value, err := readXmlElement(decoder, start)
+			if err != nil {
+				return err
+			}
+			result, ok := value.(map[string]interface{})
+			if !ok {
+				if value != `` {
+					return errors.New(`xml root element must contain child elements`)
+				}
+				result = map[string]interface{}{}
+			}
+			*mapDest = result
+			return nil
+		}
+	}
+}
+
+func writeXmlElement(buffer *bytes.Buffer, name string, value interface{}) {
+	startTag, endTag := `<`+name+`>`, `</`+name+`>`
+	if !isXmlName(name) {
+		var escapedName bytes.Buffer
+		xml.EscapeText(&escapedName, []byte(name))
+		startTag, endTag = `<item key="`+escapedName.String()+`">`, `</item>`
+	}
+	buffer.WriteString(startTag)
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeXmlElement(buffer, key, v[key])
+		}
+	case []interface{}:
+		for _, item := range v {
+			writeXmlElement(buffer, `item`, item)
+		}
+	default:
+		xml.EscapeText(buffer, []byte(fmt.Sprint(v)))
+	}
+	buffer.WriteString(endTag)
+}
+
+func isXmlName(name string) bool {
+	if name == `` || strings.HasPrefix(strings.ToLower(name), `xml`) {
+		return false
+	}
+	for i, char := range name {
+		isLetter := char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+		if !isLetter && (i == 0 || !(char == '-' || char == '.' || (char >= '0' && char <= '9'))) {
+			return false
+		}
+	}
+	return true
+}
+
+// 有子节点的解析成 map（同名子节点或 item 子节点解析成数组），否则解析成字符串
+func readXmlElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	children := map[string]interface{}{}
+	order := make([]string, 0)
+	repeated := map[string]bool{}
+	text := bytes.Buffer{}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			value, err := readXmlElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			for _, attr := range t.Attr {
+				if name == `item` && attr.Name.Local == `key` {
+					name = attr.Value
+				}
+			}
+			if existing, ok := children[name]; ok {
+				if !repeated[name] {
+					existing = []interface{}{existing}
+					repeated[name] = true
+				}
+				children[name] = append(existing.([]interface{}), value)
+			} else {
+				children[name] = value
+				order = append(order, name)
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(children) == 0 {
+				return strings.TrimSpace(text.String()), nil
+			}
+			if len(order) == 1 && order[0] == `item` {
+				if items, ok := children[`item`].([]interface{}); ok {
+					return items, nil
+				}
+				return []interface{}{children[`item`]}, nil
+			}
+			return children, nil
+		}
+	}
+}
+
+type YamlSerializerClass struct {
+}
+
+var YamlSerializer = YamlSerializerClass{}
+
+func (this *YamlSerializerClass) GetName() string {
+	return `yaml`
+}
+
+func (this *YamlSerializerClass) GetContentType() string {
+	return string(ContentTypeValue_YAML)
+}
+
+func (this *YamlSerializerClass) GetMediaTypes() []string {
+	return []string{`application/x-yaml`, `application/yaml`, `text/yaml`}
+}
+
+func (this *YamlSerializerClass) Marshal(data interface{}) ([]byte, error) {
+	generic, err := toGeneric(data)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(generic)
+}
+
+func (this *YamlSerializerClass) Unmarshal(data []byte, dest interface{}) error {
+	if err := yaml.Unmarshal(data, dest); err != nil {
+		return err
+	}
+	if mapDest, ok := dest.(*map[string]interface{}); ok {
+		*mapDest = normalizeMap(*mapDest).(map[string]interface{})
+	}
+	return nil
+}
+
+type MsgpackSerializerClass struct {
+}
+
+var MsgpackSerializer = MsgpackSerializerClass{}
+
+func (this *MsgpackSerializerClass) GetName() string {
+	return `msgpack`
+}
+
+func (this *MsgpackSerializerClass) GetContentType() string {
+	return `application/msgpack`
+}
+
+func (this *MsgpackSerializerClass) GetMediaTypes() []string {
+	return []string{`application/msgpack`, `application/x-msgpack`}
+}
+
+func (this *MsgpackSerializerClass) Marshal(data interface{}) ([]byte, error) {
+	generic, err := toGeneric(data)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(generic)
+}
+
+func (this *MsgpackSerializerClass) Unmarshal(data []byte, dest interface{}) error {
+	if err := msgpack.Unmarshal(data, dest); err != nil {
+		return err
+	}
+	if mapDest, ok := dest.(*map[string]interface{}); ok {
+		*mapDest = normalizeMap(*mapDest).(map[string]interface{})
+	}
+	return nil
+}
+
+// 先按 json 转换成通用结构（map、slice、基本类型），这样各格式的字段名、自定义 MarshalJSON 都和 json 一致
+func toGeneric(data interface{}) (interface{}, error) {
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	var result interface{}
+	if err := decoder.Decode(&result); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return convertNumbers(result), nil
+}
+
+// json.Number 转换成整数或浮点数，避免大整数丢失精度
+func convertNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = convertNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertNumbers(item)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+// yaml、msgpack 解析出的 map 的 key 可能不是字符串，统一转换成 map[string]interface{}
+func normalizeMap(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeMap(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = normalizeMap(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeMap(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,74 @@
+package api_session
+
+import (
+	"testing"
+)
+
+func TestSerializerRegistryClass_Negotiate(t *testing.T) {
+	registry := NewSerializerRegistry()
+	cases := []struct {
+		accept  string
+		formats []string
+		expect  string
+	}{
+		{``, nil, `json`},
+		{`*/*`, nil, `json`},
+		{`application/xml`, nil, `xml`},
+		{`application/json;q=0.5, application/x-yaml`, nil, `yaml`},
+		{`text/*;q=0.8, application/msgpack;q=0.9`, nil, `msgpack`},
+		{`application/json;q=0, */*`, nil, `xml`},
+		{`application/xml, application/json;q=0.1`, []string{`json`}, `json`},
+		{`application/xml`, []string{`json`, `yaml`}, ``},
+		{``, []string{`yaml`, `json`}, `yaml`},
+		{`text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8`, nil, `json`},
+		{`text/plain`, nil, `json`},
+		{`text/plain`, []string{`yaml`, `json`}, `yaml`},
+		{`application/xml;q=0.9, application/json;q=0.8`, nil, `xml`},
+		{`application/json;q=0, text/plain`, []string{`json`}, ``},
+	}
+	for _, c := range cases {
+		name := ``
+		if serializer := registry.Negotiate(c.accept, c.formats); serializer != nil {
+			name = serializer.GetName()
+		}
+		if name != c.expect {
+			t.Errorf(`accept %s, formats %v: expect %s, got %s`, c.accept, c.formats, c.expect, name)
+		}
+	}
+}
+
+func TestSerializers_RoundTrip(t *testing.T) {
+	result := ApiResult{
+		Msg:  `ok`,
+		Code: 0,
+		Data: map[string]interface{}{
+			`id`:   uint64(123456),
+			`tags`: []string{`a`, `b`},
+		},
+	}
+	for _, name := range []string{`json`, `xml`, `yaml`, `msgpack`} {
+		serializer, _ := Serializers.Get(name)
+		data, err := serializer.Marshal(result)
+		if err != nil {
+			t.Fatalf(`%s marshal error: %s`, name, err)
+		}
+		decoded := map[string]interface{}{}
+		if err := serializer.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf(`%s unmarshal error: %s; %s`, name, err, data)
+		}
+		resultData, ok := decoded[`data`].(map[string]interface{})
+		if !ok || decoded[`msg`] != `ok` {
+			t.Fatalf(`%s unexpected result %#v`, name, decoded)
+		}
+		params := struct {
+			Id   uint64   `json:"id"`
+			Tags []string `json:"tags"`
+		}{}
+		if err := ParamDecoder.Decode(resultData, &params); err != nil {
+			t.Fatalf(`%s decode error: %s; %#v`, name, err, resultData)
+		}
+		if params.Id != 123456 || len(params.Tags) != 2 || params.Tags[1] != `b` {
+			t.Errorf(`%s unexpected params %#v`, name, params)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package api_session
+
+import (
+	"sync"
+	"time"
+)
+
+// 服务端会话（浏览器登录态），cookie 中只保存会话id。会话策略加载后放在 ApiSessionClass.UserSession
+type UserSessionClass struct {
+	sync.RWMutex `json:"-"`
+
+	Id        string                 `json:"id"`
+	UserId    uint64                 `json:"user_id"`
+	Values    map[string]interface{} `json:"values"`
+	CreatedAt time.Time              `json:"created_at"`
+	ExpireAt  time.Time              `json:"expire_at"`
+}
+
+func (this *UserSessionClass) Get(key string) interface{} {
+	this.RLock()
+	defer this.RUnlock()
+	return this.Values[key]
+}
+
+func (this *UserSessionClass) Set(key string, value interface{}) {
+	this.Lock()
+	defer this.Unlock()
+	if this.Values == nil {
+		this.Values = map[string]interface{}{}
+	}
+	this.Values[key] = value
+}
+
+func (this *UserSessionClass) Delete(key string) {
+	this.Lock()
+	defer this.Unlock()
+	delete(this.Values, key)
+}
+
+// 复制一份，存储保存和返回的都是副本
+func (this *UserSessionClass) Clone() *UserSessionClass {
+	this.RLock()
+	defer this.RUnlock()
+	values := make(map[string]interface{}, len(this.Values))
+	for key, value := range this.Values {
+		values[key] = value
+	}
+	return &UserSessionClass{
+		Id:        this.Id,
+		UserId:    this.UserId,
+		Values:    values,
+		CreatedAt: this.CreatedAt,
+		ExpireAt:  this.ExpireAt,
+	}
+}
@@ -0,0 +1,155 @@
+package api_strategy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/util"
+	"github.com/pefish/go-error"
+)
+
+/**
+api key 鉴权策略，用于合作方调用。key 可以放在请求头 X-Api-Key 或者 query 参数 api_key 中，
+按 hash 在 key 来源中查找。通过后 ApiSessionClass.ApiKeyOwner、Scopes 为 key 的所有者和权限范围，
+路由需要的权限范围在策略参数中声明
+*/
+type ApiKeyStrategyClass struct {
+	errorCode  uint64
+	headerName string
+	queryName  string
+	provider   InterfaceApiKeyProvider
+}
+
+var ApiKeyApiStrategy = ApiKeyStrategyClass{
+	errorCode:  go_error.INTERNAL_ERROR_CODE,
+	headerName: string(api_session.HeaderName_XApiKey),
+	queryName:  `api_key`,
+}
+
+// 新建 api key 鉴权策略，需要设置 key 来源
+func NewApiKeyStrategy() *ApiKeyStrategyClass {
+	return &ApiKeyStrategyClass{
+		errorCode:  go_error.INTERNAL_ERROR_CODE,
+		headerName: string(api_session.HeaderName_XApiKey),
+		queryName:  `api_key`,
+	}
+}
+
+type ApiKeyParam struct {
+	Scopes []string // 路由需要的权限范围，key 要全部拥有
+}
+
+func (this *ApiKeyStrategyClass) GetName() string {
+	return `apiKey`
+}
+
+func (this *ApiKeyStrategyClass) GetDescription() string {
+	return `api key auth`
+}
+
+func (this *ApiKeyStrategyClass) SetErrorCode(code uint64) {
+	this.errorCode = code
+}
+
+func (this *ApiKeyStrategyClass) GetErrorCode() uint64 {
+	return this.errorCode
+}
+
+// 设置 key 所在的请求头，为空的话不从请求头读取
+func (this *ApiKeyStrategyClass) SetHeaderName(headerName string) {
+	this.headerName = headerName
+}
+
+// 设置 key 所在的 query 参数，为空的话不从 query 读取
+func (this *ApiKeyStrategyClass) SetQueryName(queryName string) {
+	this.queryName = queryName
+}
+
+func (this *ApiKeyStrategyClass) SetProvider(provider InterfaceApiKeyProvider) {
+	this.provider = provider
+}
+
+func (this *ApiKeyStrategyClass) Validate(param interface{}) error {
+	if this.provider == nil {
+		return fmt.Errorf(`provider of %s is not set`, this.GetName())
+	}
+	if this.headerName == `` && this.queryName == `` {
+		return fmt.Errorf(`header name and query name of %s are both empty`, this.GetName())
+	}
+	if param == nil {
+		return nil
+	}
+	if _, ok := param.(ApiKeyParam); !ok {
+		return fmt.Errorf(`param of %s must be ApiKeyParam, got %T`, this.GetName(), param)
+	}
+	return nil
+}
+
+func (this *ApiKeyStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	key := ``
+	if this.headerName != `` {
+		key = out.GetHeader(this.headerName)
+	}
+	if key == `` && this.queryName != `` {
+		key = out.Request.URL.Query().Get(this.queryName)
+	}
+	if key == `` {
+		go_error.Throw(`api key required`, this.errorCode)
+	}
+	info, err := this.provider.GetApiKey(HashApiKey(key))
+	if err != nil {
+		go_error.ThrowInternalError(`api key provider error`, err)
+	}
+	if info == nil || info.Disabled || (!info.ExpireAt.IsZero() && time.Now().After(info.ExpireAt)) {
+		go_error.Throw(`invalid api key`, this.errorCode)
+	}
+	out.ApiKeyOwner = info.Owner
+	out.Scopes = info.Scopes
+	util.UpdateSessionErrorMsg(out, `apiKeyOwner`, info.Owner)
+
+	for _, scope := range this.GetRequiredScopes(param) {
+		if !out.HasScope(scope) {
+			go_error.ThrowWithInternalMsg(`insufficient scope`, fmt.Sprintf(`scope %s is required`, scope), this.errorCode)
+		}
+	}
+}
+
+func (this *ApiKeyStrategyClass) GetSecuritySchemes() []SecurityScheme {
+	schemes := make([]SecurityScheme, 0, 2)
+	if this.headerName != `` {
+		schemes = append(schemes, SecurityScheme{
+			Name:        `apiKeyHeader`,
+			Type:        `apiKey`,
+			In:          `header`,
+			KeyName:     this.headerName,
+			Description: `api key in header`,
+		})
+	}
+	if this.queryName != `` {
+		schemes = append(schemes, SecurityScheme{
+			Name:        `apiKeyQuery`,
+			Type:        `apiKey`,
+			In:          `query`,
+			KeyName:     this.queryName,
+			Description: `api key in query`,
+		})
+	}
+	return schemes
+}
+
+func (this *ApiKeyStrategyClass) GetRequiredScopes(param interface{}) []string {
+	newParam, ok := param.(ApiKeyParam)
+	if !ok {
+		return nil
+	}
+	scopes := make([]string, 0, len(newParam.Scopes))
+	for _, scope := range newParam.Scopes {
+		if scope = strings.TrimSpace(scope); scope != `` {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,159 @@
+package api_strategy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pefish/yaml"
+)
+
+// api key 的信息
+type ApiKeyInfo struct {
+	Hash     string    `json:"hash" yaml:"hash"` // HashApiKey 的结果，不保存明文
+	Owner    string    `json:"owner" yaml:"owner"`
+	Scopes   []string  `json:"scopes" yaml:"scopes"`
+	Disabled bool      `json:"disabled" yaml:"disabled"`
+	ExpireAt time.Time `json:"expire_at" yaml:"expire_at"` // 零值表示不过期
+}
+
+// 根据 key 的 hash 查找 api key。不存在的话返回 nil, nil
+type InterfaceApiKeyProvider interface {
+	GetApiKey(hash string) (*ApiKeyInfo, error)
+}
+
+// api key 的 hash。api key 是随机生成的长字符串，不需要加盐
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// 内存中的 api key
+type MemoryApiKeyProviderClass struct {
+	sync.RWMutex
+	keys map[string]*ApiKeyInfo // hash -> info
+}
+
+func NewMemoryApiKeyProvider() *MemoryApiKeyProviderClass {
+	return &MemoryApiKeyProviderClass{
+		keys: map[string]*ApiKeyInfo{},
+	}
+}
+
+// 添加明文 key，保存的是 hash
+func (this *MemoryApiKeyProviderClass) Add(key string, info ApiKeyInfo) {
+	info.Hash = HashApiKey(key)
+	this.AddHashed(info)
+}
+
+// 添加已经 hash 过的 key
+func (this *MemoryApiKeyProviderClass) AddHashed(info ApiKeyInfo) {
+	this.Lock()
+	defer this.Unlock()
+	this.keys[info.Hash] = &info
+}
+
+func (this *MemoryApiKeyProviderClass) Remove(hash string) {
+	this.Lock()
+	defer this.Unlock()
+	delete(this.keys, hash)
+}
+
+func (this *MemoryApiKeyProviderClass) GetApiKey(hash string) (*ApiKeyInfo, error) {
+	this.RLock()
+	defer this.RUnlock()
+	return this.keys[hash], nil
+}
+
+/**
+从文件加载的 api key，文件是 ApiKeyInfo 的数组，.yaml/.yml 按 yaml 解析，其他按 json 解析。
+文件修改后自动重新加载（最多每 checkInterval 检查一次），加载失败的话继续使用之前的 key
+*/
+type FileApiKeyProviderClass struct {
+	sync.Mutex
+	path          string
+	checkInterval time.Duration
+	lastCheck     time.Time
+	modTime       time.Time
+	keys          map[string]*ApiKeyInfo
+}
+
+func NewFileApiKeyProvider(path string) (*FileApiKeyProviderClass, error) {
+	provider := &FileApiKeyProviderClass{
+		path:          path,
+		checkInterval: time.Second,
+		keys:          map[string]*ApiKeyInfo{},
+	}
+	if err := provider.Reload(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// 设置检查文件是否修改的间隔，为0的话每次查找都检查
+func (this *FileApiKeyProviderClass) SetCheckInterval(interval time.Duration) {
+	this.Lock()
+	defer this.Unlock()
+	this.checkInterval = interval
+}
+
+// 重新加载文件
+func (this *FileApiKeyProviderClass) Reload() error {
+	info, err := os.Stat(this.path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(this.path)
+	if err != nil {
+		return err
+	}
+	list := make([]ApiKeyInfo, 0)
+	switch filepath.Ext(this.path) {
+	case `.yaml`, `.yml`:
+		err = yaml.Unmarshal(content, &list)
+	default:
+		err = json.Unmarshal(content, &list)
+	}
+	if err != nil {
+		return fmt.Errorf(`parse api key file %s error: %v`, this.path, err)
+	}
+	keys := make(map[string]*ApiKeyInfo, len(list))
+	for i := range list {
+		if list[i].Hash == `` {
+			return fmt.Errorf(`api key file %s: hash of item %d is empty`, this.path, i)
+		}
+		keys[list[i].Hash] = &list[i]
+	}
+	this.Lock()
+	defer this.Unlock()
+	this.keys = keys
+	this.modTime = info.ModTime()
+	this.lastCheck = time.Now()
+	return nil
+}
+
+func (this *FileApiKeyProviderClass) GetApiKey(hash string) (*ApiKeyInfo, error) {
+	this.Lock()
+	needCheck := time.Since(this.lastCheck) >= this.checkInterval
+	if needCheck {
+		this.lastCheck = time.Now()
+	}
+	modTime := this.modTime
+	this.Unlock()
+
+	if needCheck {
+		if info, err := os.Stat(this.path); err == nil && !info.ModTime().Equal(modTime) {
+			this.Reload() // 失败的话继续使用之前的 key
+		}
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	return this.keys[hash], nil
+}
@@ -0,0 +1,92 @@
+package api_strategy_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+)
+
+// 校验 key 和权限范围，key 可以放在请求头或者 query 中；文件中的 key 修改后重新加载
+func TestApiKeyStrategyClass_Scopes(t *testing.T) {
+	memoryProvider := api_strategy.NewMemoryApiKeyProvider()
+	memoryProvider.Add(`key-read`, api_strategy.ApiKeyInfo{Owner: `partner-a`, Scopes: []string{`orders:read`}})
+	memoryProvider.Add(`key-all`, api_strategy.ApiKeyInfo{Owner: `partner-b`, Scopes: []string{`orders:*`}})
+	memoryProvider.Add(`key-disabled`, api_strategy.ApiKeyInfo{Owner: `partner-c`, Scopes: []string{`*`}, Disabled: true})
+	apiKeyStrategy := api_strategy.NewApiKeyStrategy()
+	apiKeyStrategy.SetErrorCode(2004)
+	apiKeyStrategy.SetProvider(memoryProvider)
+
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/orders`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: apiKeyStrategy,
+					Param:    api_strategy.ApiKeyParam{Scopes: []string{`orders:read`}},
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return apiSession.ApiKeyOwner
+			},
+		},
+		{
+			Path:   `/orders/cancel`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: apiKeyStrategy,
+					Param:    api_strategy.ApiKeyParam{Scopes: []string{`orders:write`}},
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return apiSession.ApiKeyOwner
+			},
+		},
+	})
+
+	owner := ``
+	client.Get(`/orders`).WithHeader(`X-Api-Key`, `key-read`).Do().AssertCode(0).ScanData(&owner)
+	if owner != `partner-a` {
+		t.Errorf(`unexpected owner %s`, owner)
+	}
+	client.Get(`/orders`).WithQuery(map[string]string{`api_key`: `key-all`}).Do().AssertCode(0)
+	client.Get(`/orders/cancel`).WithHeader(`X-Api-Key`, `key-read`).Do().AssertCode(2004).AssertMsg(`insufficient scope`)
+	client.Get(`/orders/cancel`).WithHeader(`X-Api-Key`, `key-all`).Do().AssertCode(0)
+	client.Get(`/orders`).WithHeader(`X-Api-Key`, `key-disabled`).Do().AssertCode(2004).AssertMsg(`invalid api key`)
+	client.Get(`/orders`).Do().AssertCode(2004).AssertMsg(`api key required`)
+
+	// 文件中的 key，修改文件后重新加载
+	path := filepath.Join(t.TempDir(), `api_keys.json`)
+	writeKeys := func(owner string) {
+		content := fmt.Sprintf(`[{"hash": %q, "owner": %q, "scopes": ["orders:read"]}]`, api_strategy.HashApiKey(`file-key`), owner)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeKeys(`partner-file`)
+	fileProvider, err := api_strategy.NewFileApiKeyProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileProvider.SetCheckInterval(0)
+	apiKeyStrategy.SetProvider(fileProvider)
+	client.Get(`/orders`).WithHeader(`X-Api-Key`, `file-key`).Do().AssertCode(0).ScanData(&owner)
+	if owner != `partner-file` {
+		t.Errorf(`unexpected owner %s`, owner)
+	}
+	writeKeys(`partner-reloaded`)
+	os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	client.Get(`/orders`).WithHeader(`X-Api-Key`, `file-key`).Do().AssertCode(0).ScanData(&owner)
+	if owner != `partner-reloaded` {
+		t.Errorf(`file provider should reload, got owner %s`, owner)
+	}
+}
@@ -0,0 +1,262 @@
+package api_strategy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-error"
+)
+
+// 授权失败默认的错误码，和鉴权失败（jwt 鉴权策略默认 go_error.INTERNAL_ERROR_CODE）区分开
+const AuthorizationErrorCode uint64 = 403
+
+/**
+授权策略，放在 jwt 鉴权策略之后。路由在参数中声明需要的角色（任意一个）、权限（全部）或者对 claims 的表达式。
+角色和权限来自 jwt 中的字段（默认 payload.roles、payload.permissions），设置了 InterfaceRoleResolver 的话还会按用户id 查找。
+不满足的话使用自己的错误码，和 jwt 鉴权失败区分开
+*/
+type AuthorizationStrategyClass struct {
+	errorCode            uint64
+	errorMsg             string
+	rolesClaimPath       string
+	permissionsClaimPath string
+	resolver             InterfaceRoleResolver
+	rolePermissions      map[string][]string
+	expressions          sync.Map // 表达式 -> *authorizationExpression
+}
+
+var AuthorizationApiStrategy = AuthorizationStrategyClass{
+	errorCode:            AuthorizationErrorCode,
+	errorMsg:             `Forbidden`,
+	rolesClaimPath:       `payload.roles`,
+	permissionsClaimPath: `payload.permissions`,
+}
+
+// 新建授权策略
+func NewAuthorizationStrategy() *AuthorizationStrategyClass {
+	return &AuthorizationStrategyClass{
+		errorCode:            AuthorizationErrorCode,
+		errorMsg:             `Forbidden`,
+		rolesClaimPath:       `payload.roles`,
+		permissionsClaimPath: `payload.permissions`,
+	}
+}
+
+type AuthorizationParam struct {
+	Roles       []string // 拥有其中任意一个角色
+	Permissions []string // 拥有全部权限
+	Expression  string   // 对 claims 的表达式，例如 hasRole('admin') || claims.payload.level >= 3
+}
+
+// 请求中解析出的角色和权限，存放在 ApiSessionClass.Datas
+const grantsDataKey = `grants`
+
+func (this *AuthorizationStrategyClass) GetName() string {
+	return `authorization`
+}
+
+func (this *AuthorizationStrategyClass) GetDescription() string {
+	return `role and permission authorization`
+}
+
+func (this *AuthorizationStrategyClass) SetErrorCode(code uint64) {
+	this.errorCode = code
+}
+
+func (this *AuthorizationStrategyClass) SetErrorMessage(msg string) {
+	this.errorMsg = msg
+}
+
+func (this *AuthorizationStrategyClass) GetErrorCode() uint64 {
+	return this.errorCode
+}
+
+// 设置 jwt 中角色字段的路径，按 . 分隔。为空的话不从 jwt 读取
+func (this *AuthorizationStrategyClass) SetRolesClaimPath(path string) {
+	this.rolesClaimPath = path
+}
+
+// 设置 jwt 中权限字段的路径，按 . 分隔。为空的话不从 jwt 读取
+func (this *AuthorizationStrategyClass) SetPermissionsClaimPath(path string) {
+	this.permissionsClaimPath = path
+}
+
+// 按用户id 查找角色和权限，需要缓存的话使用 NewCachedRoleResolver 包装
+func (this *AuthorizationStrategyClass) SetRoleResolver(resolver InterfaceRoleResolver) {
+	this.resolver = resolver
+}
+
+// 设置角色拥有的权限，拥有角色即拥有这些权限
+func (this *AuthorizationStrategyClass) SetRolePermissions(rolePermissions map[string][]string) {
+	this.rolePermissions = rolePermissions
+}
+
+func (this *AuthorizationStrategyClass) Validate(param interface{}) error {
+	newParam, ok := param.(AuthorizationParam)
+	if !ok {
+		return fmt.Errorf(`param of %s must be AuthorizationParam, got %T`, this.GetName(), param)
+	}
+	if len(newParam.Roles) == 0 && len(newParam.Permissions) == 0 && newParam.Expression == `` {
+		return fmt.Errorf(`param of %s: one of Roles, Permissions and Expression must be set`, this.GetName())
+	}
+	if newParam.Expression != `` {
+		if _, err := this.getExpression(newParam.Expression); err != nil {
+			return fmt.Errorf(`param of %s: expression %s error: %v`, this.GetName(), newParam.Expression, err)
+		}
+	}
+	return nil
+}
+
+func (this *AuthorizationStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	if param == nil {
+		go_error.Throw(`strategy need param`, this.errorCode)
+	}
+	newParam := param.(AuthorizationParam)
+	this.loadGrants(out)
+
+	if len(newParam.Roles) > 0 {
+		matched := false
+		for _, role := range newParam.Roles {
+			if this.hasRole(out, role) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			go_error.ThrowWithInternalMsg(this.errorMsg, fmt.Sprintf(`one of roles %s is required`, strings.Join(newParam.Roles, `, `)), this.errorCode)
+		}
+	}
+	for _, permission := range newParam.Permissions {
+		if !this.hasPermission(out, permission) {
+			go_error.ThrowWithInternalMsg(this.errorMsg, fmt.Sprintf(`permission %s is required`, permission), this.errorCode)
+		}
+	}
+	if newParam.Expression != `` {
+		expression, err := this.getExpression(newParam.Expression)
+		if err != nil {
+			go_error.ThrowInternalError(`authorization expression error`, err)
+		}
+		passed := expression.Eval(&expressionContext{
+			claims: out.JwtBody,
+			userId: out.UserId,
+			hasRole: func(role string) bool {
+				return this.hasRole(out, role)
+			},
+			hasPermission: func(permission string) bool {
+				return this.hasPermission(out, permission)
+			},
+		})
+		if !passed {
+			go_error.ThrowWithInternalMsg(this.errorMsg, fmt.Sprintf(`expression %s is not satisfied`, newParam.Expression), this.errorCode)
+		}
+	}
+}
+
+// 文档中显示路由需要的角色、权限和表达式
+func (this *AuthorizationStrategyClass) GetDocDescription(param interface{}) string {
+	newParam, ok := param.(AuthorizationParam)
+	if !ok {
+		return ``
+	}
+	parts := make([]string, 0, 3)
+	if len(newParam.Roles) > 0 {
+		parts = append(parts, `roles any of `+strings.Join(newParam.Roles, `, `))
+	}
+	if len(newParam.Permissions) > 0 {
+		parts = append(parts, `permissions all of `+strings.Join(newParam.Permissions, `, `))
+	}
+	if newParam.Expression != `` {
+		parts = append(parts, `expression `+newParam.Expression)
+	}
+	return strings.Join(parts, `; `)
+}
+
+// 解析 jwt 和角色来源中的角色、权限，同一个请求只解析一次
+func (this *AuthorizationStrategyClass) loadGrants(out *api_session.ApiSessionClass) {
+	if _, ok := out.Datas[grantsDataKey]; ok {
+		return
+	}
+	roles := make([]string, 0)
+	permissions := make([]string, 0)
+	if this.rolesClaimPath != `` {
+		roles = append(roles, claimToStrings(getClaim(out.JwtBody, strings.Split(this.rolesClaimPath, `.`)))...)
+	}
+	if this.permissionsClaimPath != `` {
+		permissions = append(permissions, claimToStrings(getClaim(out.JwtBody, strings.Split(this.permissionsClaimPath, `.`)))...)
+	}
+	if this.resolver != nil && out.UserId != 0 {
+		grants, err := this.resolver.Resolve(out.UserId)
+		if err != nil {
+			go_error.ThrowInternalError(`resolve roles error`, err)
+		}
+		if grants != nil {
+			roles = append(roles, grants.Roles...)
+			permissions = append(permissions, grants.Permissions...)
+		}
+	}
+	for _, role := range roles {
+		permissions = append(permissions, this.rolePermissions[role]...)
+	}
+	out.Roles = roles
+	out.Permissions = permissions
+	out.Datas[grantsDataKey] = true
+}
+
+func (this *AuthorizationStrategyClass) hasRole(out *api_session.ApiSessionClass, role string) bool {
+	for _, owned := range out.Roles {
+		if owned == role {
+			return true
+		}
+	}
+	return false
+}
+
+// * 表示所有权限，order.* 表示 order. 开头的所有权限
+func (this *AuthorizationStrategyClass) hasPermission(out *api_session.ApiSessionClass, permission string) bool {
+	for _, owned := range out.Permissions {
+		if owned == `*` || owned == permission {
+			return true
+		}
+		if strings.HasSuffix(owned, `*`) && strings.HasPrefix(permission, strings.TrimSuffix(owned, `*`)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *AuthorizationStrategyClass) getExpression(source string) (*authorizationExpression, error) {
+	if cached, ok := this.expressions.Load(source); ok {
+		return cached.(*authorizationExpression), nil
+	}
+	expression, err := parseAuthorizationExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	this.expressions.Store(source, expression)
+	return expression, nil
+}
+
+// jwt 中的角色可以是字符串数组，也可以是逗号分隔的字符串
+func claimToStrings(value interface{}) []string {
+	result := make([]string, 0)
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if str, ok := item.(string); ok && str != `` {
+				result = append(result, str)
+			}
+		}
+	case []string:
+		result = append(result, v...)
+	case string:
+		for _, item := range strings.Split(v, `,`) {
+			if item = strings.TrimSpace(item); item != `` {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
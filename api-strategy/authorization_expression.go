@@ -0,0 +1,402 @@
+package api_strategy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/**
+授权表达式，例如 hasRole('admin') || (hasPermission('order.write') && claims.payload.level >= 3)。
+支持 && || ! 和括号、比较运算 == != > >= < <=、字符串（单引号或双引号）、数字、true/false/null，
+函数 hasRole、hasPermission，变量 claims.xxx（jwt 中的字段，按 . 分隔的路径）和 userId
+*/
+type authorizationExpression struct {
+	source string
+	root   expressionNode
+}
+
+type expressionContext struct {
+	claims        map[string]interface{}
+	userId        uint64
+	hasRole       func(role string) bool
+	hasPermission func(permission string) bool
+}
+
+type expressionNode interface {
+	eval(ctx *expressionContext) interface{}
+}
+
+func parseAuthorizationExpression(source string) (*authorizationExpression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &expressionParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf(`unexpected %q at %d`, parser.tokens[parser.pos].text, parser.tokens[parser.pos].offset)
+	}
+	return &authorizationExpression{
+		source: source,
+		root:   root,
+	}, nil
+}
+
+func (this *authorizationExpression) Eval(ctx *expressionContext) bool {
+	return isTruthy(this.root.eval(ctx))
+}
+
+type tokenKind int
+
+const (
+	tokenKind_Ident tokenKind = iota
+	tokenKind_String
+	tokenKind_Number
+	tokenKind_Operator
+)
+
+type expressionToken struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+func tokenizeExpression(source string) ([]expressionToken, error) {
+	tokens := make([]expressionToken, 0)
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf(`unterminated string at %d`, i)
+			}
+			tokens = append(tokens, expressionToken{kind: tokenKind_String, text: string(runes[i+1 : end]), offset: i})
+			i = end + 1
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, expressionToken{kind: tokenKind_Number, text: string(runes[i:end]), offset: i})
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_' || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, expressionToken{kind: tokenKind_Ident, text: string(runes[i:end]), offset: i})
+			i = end
+		default:
+			operator := ``
+			for _, candidate := range []string{`&&`, `||`, `==`, `!=`, `>=`, `<=`, `>`, `<`, `!`, `(`, `)`} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == `` {
+				return nil, fmt.Errorf(`unexpected %q at %d`, r, i)
+			}
+			tokens = append(tokens, expressionToken{kind: tokenKind_Operator, text: operator, offset: i})
+			i += len(operator)
+		}
+	}
+	return tokens, nil
+}
+
+type expressionParser struct {
+	tokens []expressionToken
+	pos    int
+}
+
+func (this *expressionParser) peekOperator(operators ...string) string {
+	if this.pos >= len(this.tokens) || this.tokens[this.pos].kind != tokenKind_Operator {
+		return ``
+	}
+	for _, operator := range operators {
+		if this.tokens[this.pos].text == operator {
+			return operator
+		}
+	}
+	return ``
+}
+
+func (this *expressionParser) expectOperator(operator string) error {
+	if this.peekOperator(operator) == `` {
+		if this.pos >= len(this.tokens) {
+			return fmt.Errorf(`expect %q at end`, operator)
+		}
+		return fmt.Errorf(`expect %q at %d`, operator, this.tokens[this.pos].offset)
+	}
+	this.pos++
+	return nil
+}
+
+func (this *expressionParser) parseOr() (expressionNode, error) {
+	left, err := this.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for this.peekOperator(`||`) != `` {
+		this.pos++
+		right, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{operator: `||`, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (this *expressionParser) parseAnd() (expressionNode, error) {
+	left, err := this.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for this.peekOperator(`&&`) != `` {
+		this.pos++
+		right, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{operator: `&&`, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (this *expressionParser) parseUnary() (expressionNode, error) {
+	if this.peekOperator(`!`) != `` {
+		this.pos++
+		operand, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return this.parseComparison()
+}
+
+func (this *expressionParser) parseComparison() (expressionNode, error) {
+	left, err := this.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if operator := this.peekOperator(`==`, `!=`, `>=`, `<=`, `>`, `<`); operator != `` {
+		this.pos++
+		right, err := this.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &comparisonNode{operator: operator, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (this *expressionParser) parsePrimary() (expressionNode, error) {
+	if this.pos >= len(this.tokens) {
+		return nil, fmt.Errorf(`unexpected end of expression`)
+	}
+	token := this.tokens[this.pos]
+	this.pos++
+	switch token.kind {
+	case tokenKind_String:
+		return &literalNode{value: token.text}, nil
+	case tokenKind_Number:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid number %q at %d`, token.text, token.offset)
+		}
+		return &literalNode{value: number}, nil
+	case tokenKind_Operator:
+		if token.text != `(` {
+			return nil, fmt.Errorf(`unexpected %q at %d`, token.text, token.offset)
+		}
+		node, err := this.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := this.expectOperator(`)`); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	switch token.text {
+	case `true`:
+		return &literalNode{value: true}, nil
+	case `false`:
+		return &literalNode{value: false}, nil
+	case `null`:
+		return &literalNode{value: nil}, nil
+	case `userId`:
+		return &userIdNode{}, nil
+	case `hasRole`, `hasPermission`:
+		if err := this.expectOperator(`(`); err != nil {
+			return nil, err
+		}
+		if this.pos >= len(this.tokens) || this.tokens[this.pos].kind != tokenKind_String {
+			return nil, fmt.Errorf(`%s needs a string argument at %d`, token.text, token.offset)
+		}
+		argument := this.tokens[this.pos].text
+		this.pos++
+		if err := this.expectOperator(`)`); err != nil {
+			return nil, err
+		}
+		return &callNode{function: token.text, argument: argument}, nil
+	}
+	if strings.HasPrefix(token.text, `claims.`) {
+		return &claimNode{path: strings.Split(strings.TrimPrefix(token.text, `claims.`), `.`)}, nil
+	}
+	return nil, fmt.Errorf(`unknown identifier %q at %d`, token.text, token.offset)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (this *literalNode) eval(ctx *expressionContext) interface{} {
+	return this.value
+}
+
+type userIdNode struct {
+}
+
+func (this *userIdNode) eval(ctx *expressionContext) interface{} {
+	return float64(ctx.userId)
+}
+
+type claimNode struct {
+	path []string
+}
+
+func (this *claimNode) eval(ctx *expressionContext) interface{} {
+	return getClaim(ctx.claims, this.path)
+}
+
+type callNode struct {
+	function string
+	argument string
+}
+
+func (this *callNode) eval(ctx *expressionContext) interface{} {
+	if this.function == `hasRole` {
+		return ctx.hasRole(this.argument)
+	}
+	return ctx.hasPermission(this.argument)
+}
+
+type notNode struct {
+	operand expressionNode
+}
+
+func (this *notNode) eval(ctx *expressionContext) interface{} {
+	return !isTruthy(this.operand.eval(ctx))
+}
+
+type logicalNode struct {
+	operator string
+	left     expressionNode
+	right    expressionNode
+}
+
+func (this *logicalNode) eval(ctx *expressionContext) interface{} {
+	left := isTruthy(this.left.eval(ctx))
+	if this.operator == `&&` {
+		return left && isTruthy(this.right.eval(ctx))
+	}
+	return left || isTruthy(this.right.eval(ctx))
+}
+
+type comparisonNode struct {
+	operator string
+	left     expressionNode
+	right    expressionNode
+}
+
+func (this *comparisonNode) eval(ctx *expressionContext) interface{} {
+	left := normalizeValue(this.left.eval(ctx))
+	right := normalizeValue(this.right.eval(ctx))
+	switch this.operator {
+	case `==`:
+		return left == right
+	case `!=`:
+		return left != right
+	}
+	leftNumber, ok1 := left.(float64)
+	rightNumber, ok2 := right.(float64)
+	if !ok1 || !ok2 { // 只有数字可以比较大小
+		return false
+	}
+	switch this.operator {
+	case `>`:
+		return leftNumber > rightNumber
+	case `>=`:
+		return leftNumber >= rightNumber
+	case `<`:
+		return leftNumber < rightNumber
+	default:
+		return leftNumber <= rightNumber
+	}
+}
+
+// 按 . 分隔的路径读取 claims 中的字段，不存在的话返回nil
+func getClaim(claims map[string]interface{}, path []string) interface{} {
+	var current interface{} = claims
+	for _, key := range path {
+		currentMap, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = currentMap[key]
+	}
+	return current
+}
+
+// 数字统一成 float64，数字字符串也按数字比较
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case string:
+		if number, err := strconv.ParseFloat(v, 64); err == nil {
+			return number
+		}
+		return v
+	case []interface{}, map[string]interface{}: // 不能用 == 比较
+		return fmt.Sprint(v)
+	}
+	return value
+}
+
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ``
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package api_strategy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+// 默认错误码和 jwt 鉴权失败的错误码不同
+func TestAuthorizationStrategyClass_DefaultErrorCode(t *testing.T) {
+	_, pubKey := test_client.GetTestKeyPair()
+	jwtStrategy := api_strategy.NewJwtAuthStrategy()
+	jwtStrategy.SetPubKey(pubKey)
+	jwtStrategy.SetHeaderName(`Json-Web-Token`)
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/admin`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: jwtStrategy,
+				},
+				{
+					Strategy: api_strategy.NewAuthorizationStrategy(),
+					Param:    api_strategy.AuthorizationParam{Roles: []string{`admin`}},
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return `ok`
+			},
+		},
+	})
+
+	client.Get(`/admin`).Do().AssertCode(go_error.INTERNAL_ERROR_CODE)
+	client.Get(`/admin`).WithJwt(map[string]interface{}{`user_id`: 1, `roles`: `user`}).Do().AssertCode(api_strategy.AuthorizationErrorCode).AssertMsg(`Forbidden`)
+	client.Get(`/admin`).WithJwt(map[string]interface{}{`user_id`: 1, `roles`: `admin`}).Do().AssertCode(0)
+}
+
+// 按 jwt 中或者解析出的角色、权限以及表达式鉴权
+func TestAuthorizationStrategyClass_Grants(t *testing.T) {
+	_, pubKey := test_client.GetTestKeyPair()
+	jwtStrategy := api_strategy.NewJwtAuthStrategy()
+	jwtStrategy.SetPubKey(pubKey)
+	jwtStrategy.SetHeaderName(`Json-Web-Token`)
+	jwtStrategy.SetErrorCode(2001)
+
+	resolveCount := 0
+	resolver := api_strategy.NewCachedRoleResolver(api_strategy.RoleResolverFunc(func(userId uint64) (*api_strategy.Grants, error) {
+		resolveCount++
+		if userId == 3 {
+			return &api_strategy.Grants{Roles: []string{`auditor`}}, nil
+		}
+		return nil, nil
+	}), time.Minute)
+	authorizationStrategy := api_strategy.NewAuthorizationStrategy()
+	authorizationStrategy.SetErrorCode(2005)
+	authorizationStrategy.SetRoleResolver(resolver)
+	authorizationStrategy.SetRolePermissions(map[string][]string{
+		`auditor`: {`order.read`},
+	})
+
+	route := func(path string, param api_strategy.AuthorizationParam) *api.Api {
+		return &api.Api{
+			Path:   path,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: jwtStrategy,
+				},
+				{
+					Strategy: authorizationStrategy,
+					Param:    param,
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return apiSession.Roles
+			},
+		}
+	}
+	_, client := test_client.NewTestService(t, []*api.Api{
+		route(`/admin`, api_strategy.AuthorizationParam{Roles: []string{`admin`, `ops`}}),
+		route(`/orders`, api_strategy.AuthorizationParam{Permissions: []string{`order.read`}}),
+		route(`/vip`, api_strategy.AuthorizationParam{Expression: `hasRole('admin') || (claims.payload.level >= 3 && !hasRole('banned'))`}),
+	})
+
+	client.Get(`/admin`).WithJwt(map[string]interface{}{`user_id`: 1, `roles`: []string{`ops`}}).Do().AssertCode(0)
+	client.Get(`/admin`).WithJwt(map[string]interface{}{`user_id`: 1, `roles`: `user`}).Do().AssertCode(2005).AssertMsg(`Forbidden`)
+	client.Get(`/admin`).Do().AssertCode(2001)
+
+	client.Get(`/orders`).WithJwt(map[string]interface{}{`user_id`: 2, `permissions`: []string{`order.*`}}).Do().AssertCode(0)
+	client.Get(`/orders`).WithJwt(map[string]interface{}{`user_id`: 3}).Do().AssertCode(0)
+	client.Get(`/orders`).WithJwt(map[string]interface{}{`user_id`: 3}).Do().AssertCode(0)
+	client.Get(`/orders`).WithJwt(map[string]interface{}{`user_id`: 4}).Do().AssertCode(2005)
+	if resolveCount != 4 { // 用户 1、2、3、4 各一次，第二次命中缓存
+		t.Errorf(`expect 4 resolves, got %d`, resolveCount)
+	}
+
+	client.Get(`/vip`).WithJwt(map[string]interface{}{`user_id`: 5, `level`: 3}).Do().AssertCode(0)
+	client.Get(`/vip`).WithJwt(map[string]interface{}{`user_id`: 5, `level`: 3, `roles`: `banned`}).Do().AssertCode(2005)
+	client.Get(`/vip`).WithJwt(map[string]interface{}{`user_id`: 5, `level`: 1, `roles`: `admin`}).Do().AssertCode(0)
+	client.Get(`/vip`).WithJwt(map[string]interface{}{`user_id`: 5, `level`: 1}).Do().AssertCode(2005)
+
+	if err := authorizationStrategy.Validate(api_strategy.AuthorizationParam{Expression: `hasRole(admin)`}); err == nil {
+		t.Errorf(`invalid expression should fail validation`)
+	}
+}
@@ -0,0 +1,207 @@
+package api_strategy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-error"
+)
+
+/**
+GET/HEAD 响应缓存策略。根据序列化后的返回结果计算强 ETag，客户端带着 If-None-Match 请求并且内容没有变化的话返回304；
+按路由设置 Cache-Control。参数 Ttl 大于0的话还会在服务端缓存序列化后的响应，命中时不再执行控制器。
+策略在后置阶段自行写入响应并返回nil，之后的后置处理（排在它前面的策略的后置处理）和路由的 ReturnHookFunc 都不会执行，
+需要修改返回结果的策略要排在它后面。只处理成功的结果
+*/
+type CacheStrategyClass struct {
+	errorCode uint64
+	cache     *ResponseCacheClass
+}
+
+var CacheApiStrategy = CacheStrategyClass{
+	errorCode: go_error.INTERNAL_ERROR_CODE,
+	cache:     NewResponseCache(1000),
+}
+
+// 新建缓存策略，服务端缓存与其他实例相互独立，默认最多缓存1000个响应
+func NewCacheStrategy() *CacheStrategyClass {
+	return &CacheStrategyClass{
+		errorCode: go_error.INTERNAL_ERROR_CODE,
+		cache:     NewResponseCache(1000),
+	}
+}
+
+type CacheParam struct {
+	MaxAge       time.Duration // 客户端可以直接使用缓存的时间（Cache-Control max-age）。为0的话是 no-cache，每次都要用 ETag 验证
+	Private      bool          // 和用户相关的数据，不允许代理缓存。服务端缓存的 key 同样包含 UserId，策略要放在鉴权策略之后
+	CacheControl string        // 直接指定 Cache-Control，优先于 MaxAge、Private
+	Ttl          time.Duration // 服务端缓存时间，为0的话不在服务端缓存
+	VaryHeaders  []string      // 服务端缓存的 key 包含这些请求头的值
+	VaryUserId   bool          // 服务端缓存的 key 包含 UserId，策略要放在鉴权策略之后
+}
+
+func (this *CacheStrategyClass) GetName() string {
+	return `cache`
+}
+
+func (this *CacheStrategyClass) GetDescription() string {
+	return `etag and response cache`
+}
+
+func (this *CacheStrategyClass) SetErrorCode(code uint64) {
+	this.errorCode = code
+}
+
+func (this *CacheStrategyClass) GetErrorCode() uint64 {
+	return this.errorCode
+}
+
+// 设置服务端最多缓存多少个响应，超出的部分按 LRU 淘汰。加锁修改，服务运行时也可以调用
+func (this *CacheStrategyClass) SetCapacity(capacity int) {
+	this.cache.SetCapacity(capacity)
+}
+
+// 服务端缓存，控制器修改数据后可以调用 Invalidate 等方法删除相关的缓存
+func (this *CacheStrategyClass) GetCache() *ResponseCacheClass {
+	return this.cache
+}
+
+func (this *CacheStrategyClass) Validate(param interface{}) error {
+	newParam, ok := param.(CacheParam)
+	if !ok {
+		return fmt.Errorf(`param of %s must be CacheParam, got %T`, this.GetName(), param)
+	}
+	if newParam.MaxAge < 0 || newParam.Ttl < 0 {
+		return fmt.Errorf(`param of %s: MaxAge and Ttl must not be negative`, this.GetName())
+	}
+	return nil
+}
+
+func (this *CacheStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	if param == nil {
+		go_error.Throw(`strategy need param`, this.errorCode)
+	}
+	newParam := param.(CacheParam)
+	if newParam.Ttl <= 0 || !isCacheableMethod(out.GetMethod()) {
+		return
+	}
+	key, ok := this.getKey(out, newParam)
+	if !ok {
+		return
+	}
+	entry := this.cache.get(key)
+	if entry == nil {
+		return
+	}
+	// 写入响应后请求结束，不再执行控制器
+	this.writeEntry(out, newParam, entry)
+}
+
+// 写入响应后返回nil，剩下的后置处理和 ReturnHookFunc 不再执行
+func (this *CacheStrategyClass) ExecuteAfter(out *api_session.ApiSessionClass, param interface{}, apiResult *api_session.ApiResult) *api_session.ApiResult {
+	newParam := param.(CacheParam)
+	if apiResult.Code != 0 || out.GetStatusCode() != api_session.StatusCode_OK || !isCacheableMethod(out.GetMethod()) {
+		return apiResult
+	}
+	serializer := out.Serializer
+	if serializer == nil {
+		serializer = &api_session.JsonSerializer
+	}
+	body, err := serializer.Marshal(apiResult)
+	if err != nil {
+		return apiResult
+	}
+	sum := sha256.Sum256(body)
+	entry := &cacheEntry{
+		method:      out.GetMethod(),
+		path:        out.GetPath(),
+		contentType: serializer.GetContentType(),
+		body:        body,
+		etag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+	}
+	if newParam.Ttl > 0 {
+		if key, ok := this.getKey(out, newParam); ok {
+			entry.key = key
+			entry.expireAt = time.Now().Add(newParam.Ttl)
+			this.cache.set(entry)
+		}
+	}
+	this.writeEntry(out, newParam, entry)
+	return nil
+}
+
+// 方法、路径、响应格式、参数、指定的请求头和用户id 决定缓存的 key。参数不能序列化的话不缓存
+func (this *CacheStrategyClass) getKey(out *api_session.ApiSessionClass, param CacheParam) (string, bool) {
+	params, err := json.Marshal(out.Params) // map 序列化时按 key 排序
+	if err != nil {
+		return ``, false
+	}
+	serializerName := ``
+	if out.Serializer != nil {
+		serializerName = out.Serializer.GetName()
+	}
+	parts := []string{out.GetMethod(), out.GetPath(), serializerName, string(params)}
+	for _, headerName := range param.VaryHeaders {
+		parts = append(parts, out.GetHeader(headerName))
+	}
+	if param.VaryUserId || param.Private { // 私有的数据不能在用户之间共享
+		parts = append(parts, fmt.Sprint(out.UserId))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:]), true
+}
+
+func (this *CacheStrategyClass) writeEntry(out *api_session.ApiSessionClass, param CacheParam, entry *cacheEntry) {
+	out.SetHeader(string(api_session.HeaderName_ETag), entry.etag)
+	out.SetHeader(string(api_session.HeaderName_CacheControl), getCacheControl(param))
+	if matchEtag(out.GetHeader(string(api_session.HeaderName_IfNoneMatch)), entry.etag) {
+		out.ResponseWriter.WriteHeader(int(api_session.StatusCode_NotModified))
+		return
+	}
+	out.SetHeader(string(api_session.HeaderName_ContentType), entry.contentType)
+	out.ResponseWriter.WriteHeader(int(api_session.StatusCode_OK))
+	out.ResponseWriter.Write(entry.body)
+}
+
+func getCacheControl(param CacheParam) string {
+	if param.CacheControl != `` {
+		return param.CacheControl
+	}
+	directives := make([]string, 0, 2)
+	if param.Private {
+		directives = append(directives, `private`)
+	}
+	if param.MaxAge > 0 {
+		directives = append(directives, fmt.Sprintf(`max-age=%d`, int64(param.MaxAge/time.Second)))
+	} else {
+		directives = append(directives, `no-cache`)
+	}
+	return strings.Join(directives, `, `)
+}
+
+// If-None-Match 使用弱比较，可以是 * 或者逗号分隔的多个 ETag
+func matchEtag(ifNoneMatch string, etag string) bool {
+	ifNoneMatch = strings.TrimSpace(ifNoneMatch)
+	if ifNoneMatch == `` {
+		return false
+	}
+	if ifNoneMatch == `*` {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, `,`) {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), `W/`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func isCacheableMethod(method string) bool {
+	return method == string(api_session.ApiMethod_Get) || method == string(api_session.ApiMethod_Head)
+}
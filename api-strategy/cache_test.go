@@ -0,0 +1,152 @@
+package api_strategy_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+)
+
+// 按 X-User 请求头设置 UserId，代替鉴权策略
+type fakeAuthStrategyClass struct{}
+
+func (this *fakeAuthStrategyClass) GetName() string {
+	return `fakeAuth`
+}
+
+func (this *fakeAuthStrategyClass) GetDescription() string {
+	return `set user id from header`
+}
+
+func (this *fakeAuthStrategyClass) GetErrorCode() uint64 {
+	return 2001
+}
+
+func (this *fakeAuthStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	out.UserId, _ = strconv.ParseUint(out.GetHeader(`X-User`), 10, 64)
+}
+
+// Private 的响应在服务端按用户分别缓存
+func TestCacheStrategyClass_Private(t *testing.T) {
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/me`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: &fakeAuthStrategyClass{},
+				},
+				{
+					Strategy: api_strategy.NewCacheStrategy(),
+					Param: api_strategy.CacheParam{
+						Private: true,
+						Ttl:     time.Minute,
+					},
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return apiSession.UserId
+			},
+		},
+	})
+
+	var userId uint64
+	client.Get(`/me`).WithHeader(`X-User`, `1`).Do().AssertCode(0).ScanData(&userId)
+	client.Get(`/me`).WithHeader(`X-User`, `2`).Do().AssertCode(0).ScanData(&userId)
+	if userId != 2 {
+		t.Errorf(`private response should not be shared between users, got user %d`, userId)
+	}
+}
+
+type nameParam struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// 命中缓存时不执行控制器，If-None-Match 匹配时返回 304
+func TestCacheStrategyClass_Revalidate(t *testing.T) {
+	cacheStrategy := api_strategy.NewCacheStrategy()
+	calls := 0
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/user`,
+			Method: api_session.ApiMethod_Get,
+			Params: nameParam{},
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: cacheStrategy,
+					Param: api_strategy.CacheParam{
+						MaxAge:  time.Minute,
+						Private: true,
+						Ttl:     time.Minute,
+					},
+				},
+			},
+			TypedController: func(apiSession *api_session.ApiSessionClass, params nameParam) (*nameParam, error) {
+				calls++
+				return &params, nil
+			},
+		},
+	})
+
+	response := client.Get(`/user`).WithQuery(map[string]string{`name`: `a`}).Do().AssertCode(0)
+	etag := response.GetHeader(`ETag`)
+	if etag == `` || response.GetHeader(`Cache-Control`) != `private, max-age=60` {
+		t.Fatalf(`unexpected headers %v`, response.Recorder.Header())
+	}
+	client.Get(`/user`).WithQuery(map[string]string{`name`: `a`}).WithHeader(`If-None-Match`, `W/`+etag).Do().AssertStatus(304)
+	client.Get(`/user`).WithQuery(map[string]string{`name`: `a`}).Do().AssertCode(0)
+	if calls != 1 {
+		t.Errorf(`cached response should not call controller, got %d calls`, calls)
+	}
+	client.Get(`/user`).WithQuery(map[string]string{`name`: `b`}).Do().AssertCode(0)
+	if calls != 2 {
+		t.Errorf(`different params should miss cache, got %d calls`, calls)
+	}
+
+	if count := cacheStrategy.GetCache().Invalidate(string(api_session.ApiMethod_Get), `/user`); count != 2 {
+		t.Errorf(`expect 2 entries invalidated, got %d`, count)
+	}
+	response = client.Get(`/user`).WithQuery(map[string]string{`name`: `a`}).Do().AssertCode(0)
+	if calls != 3 || response.GetHeader(`ETag`) != etag {
+		t.Errorf(`unexpected calls %d or etag %s`, calls, response.GetHeader(`ETag`))
+	}
+}
+
+// 运行时修改容量，超出的缓存按 LRU 淘汰
+func TestCacheStrategyClass_SetCapacity(t *testing.T) {
+	cacheStrategy := api_strategy.NewCacheStrategy()
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/user`,
+			Method: api_session.ApiMethod_Get,
+			Params: nameParam{},
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: cacheStrategy,
+					Param:    api_strategy.CacheParam{Ttl: time.Minute},
+				},
+			},
+			TypedController: func(apiSession *api_session.ApiSessionClass, params nameParam) (*nameParam, error) {
+				return &params, nil
+			},
+		},
+	})
+
+	for _, name := range []string{`a`, `b`, `c`} {
+		client.Get(`/user`).WithQuery(map[string]string{`name`: name}).Do().AssertCode(0)
+	}
+	done := make(chan bool)
+	go func() {
+		cacheStrategy.SetCapacity(2)
+		done <- true
+	}()
+	client.Get(`/user`).WithQuery(map[string]string{`name`: `a`}).Do().AssertCode(0)
+	<-done
+	if count := cacheStrategy.GetCache().Len(); count != 2 {
+		t.Errorf(`expect 2 cached responses, got %d`, count)
+	}
+}
@@ -0,0 +1,313 @@
+package api_strategy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/util"
+	"github.com/pefish/go-error"
+)
+
+/**
+cookie 会话鉴权策略，用于浏览器（如管理后台）。cookie 中只保存签过名的会话id，会话内容保存在服务端存储中。
+通过后 ApiSessionClass.UserSession、UserId 为当前会话和用户。会话有效期是滑动的，每个请求都会延长，
+但不超过最长有效期。登录、权限变更后调用 Login、Rotate 更换会话id，防止会话固定攻击
+*/
+type CookieSessionStrategyClass struct {
+	errorCode    uint64
+	errorMsg     string
+	cookieName   string
+	signKey      []byte
+	store        InterfaceSessionStore
+	idleTtl      time.Duration
+	maxLifetime  time.Duration
+	cookieOption api_session.CookieOption
+}
+
+var CookieSessionApiStrategy = CookieSessionStrategyClass{
+	errorCode:    go_error.INTERNAL_ERROR_CODE,
+	errorMsg:     `Unauthorized`,
+	cookieName:   `session_id`,
+	store:        NewMemorySessionStore(),
+	idleTtl:      30 * time.Minute,
+	maxLifetime:  24 * time.Hour,
+	cookieOption: defaultSessionCookieOption,
+}
+
+var defaultSessionCookieOption = api_session.CookieOption{
+	HttpOnly: true,
+	SameSite: http.SameSiteLaxMode,
+}
+
+// 新建 cookie 会话策略，需要设置签名密钥，默认使用内存存储
+func NewCookieSessionStrategy() *CookieSessionStrategyClass {
+	return &CookieSessionStrategyClass{
+		errorCode:    go_error.INTERNAL_ERROR_CODE,
+		errorMsg:     `Unauthorized`,
+		cookieName:   `session_id`,
+		store:        NewMemorySessionStore(),
+		idleTtl:      30 * time.Minute,
+		maxLifetime:  24 * time.Hour,
+		cookieOption: defaultSessionCookieOption,
+	}
+}
+
+type CookieSessionParam struct {
+	Optional bool // 没有登录也可以访问，有会话的话照常加载
+}
+
+// 请求中已经注册了保存会话的 ResponseWriter，存放在 ApiSessionClass.Datas
+const sessionSaveDataKey = `cookieSessionSave`
+
+func (this *CookieSessionStrategyClass) GetName() string {
+	return `cookieSession`
+}
+
+func (this *CookieSessionStrategyClass) GetDescription() string {
+	return `cookie session auth`
+}
+
+func (this *CookieSessionStrategyClass) SetErrorCode(code uint64) {
+	this.errorCode = code
+}
+
+func (this *CookieSessionStrategyClass) SetErrorMessage(msg string) {
+	this.errorMsg = msg
+}
+
+func (this *CookieSessionStrategyClass) GetErrorCode() uint64 {
+	return this.errorCode
+}
+
+// 保存会话id 的 cookie 名，默认 session_id
+func (this *CookieSessionStrategyClass) SetCookieName(cookieName string) {
+	this.cookieName = cookieName
+}
+
+// 会话id cookie 的签名密钥
+func (this *CookieSessionStrategyClass) SetSignKey(signKey []byte) {
+	this.signKey = signKey
+}
+
+func (this *CookieSessionStrategyClass) SetStore(store InterfaceSessionStore) {
+	this.store = store
+}
+
+func (this *CookieSessionStrategyClass) GetStore() InterfaceSessionStore {
+	return this.store
+}
+
+// 多久没有请求会话就过期，默认30分钟
+func (this *CookieSessionStrategyClass) SetIdleTtl(ttl time.Duration) {
+	this.idleTtl = ttl
+}
+
+// 会话从登录开始最长的有效期，默认24小时，0 表示不限制
+func (this *CookieSessionStrategyClass) SetMaxLifetime(maxLifetime time.Duration) {
+	this.maxLifetime = maxLifetime
+}
+
+// 会话 cookie 的选项，默认 HttpOnly、SameSite=Lax。MaxAge 大于0 的话每个请求都会重新设置 cookie
+func (this *CookieSessionStrategyClass) SetCookieOption(option api_session.CookieOption) {
+	this.cookieOption = option
+}
+
+func (this *CookieSessionStrategyClass) Validate(param interface{}) error {
+	if len(this.signKey) == 0 {
+		return fmt.Errorf(`sign key of %s is not set`, this.GetName())
+	}
+	if param == nil {
+		return nil
+	}
+	if _, ok := param.(CookieSessionParam); !ok {
+		return fmt.Errorf(`param of %s must be CookieSessionParam, got %T`, this.GetName(), param)
+	}
+	return nil
+}
+
+func (this *CookieSessionStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	newParam, _ := param.(CookieSessionParam)
+	session := this.load(out)
+	if session == nil {
+		if newParam.Optional {
+			return
+		}
+		go_error.ThrowWithInternalMsg(this.errorMsg, `session not found or expired`, this.errorCode)
+	}
+
+	session.ExpireAt = this.getExpireAt(session.CreatedAt)
+	out.UserSession = session
+	out.UserId = session.UserId
+	util.UpdateSessionErrorMsg(out, `cookieSession`, session.UserId)
+	if this.cookieOption.MaxAge > 0 {
+		out.SetSignedCookie(this.cookieName, session.Id, this.signKey, &this.cookieOption)
+	}
+	this.registerSave(out)
+}
+
+// 登录，新建会话并设置 cookie。已经有会话的话删除旧会话（更换会话id）。控制器中对会话的修改在写入响应前保存
+func (this *CookieSessionStrategyClass) Login(out *api_session.ApiSessionClass, userId uint64) (*api_session.UserSessionClass, error) {
+	if err := this.deleteCurrent(out); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &api_session.UserSessionClass{
+		Id:        newSessionId(),
+		UserId:    userId,
+		Values:    map[string]interface{}{},
+		CreatedAt: now,
+		ExpireAt:  this.getExpireAt(now),
+	}
+	if err := this.store.Save(session); err != nil {
+		return nil, err
+	}
+	out.SetSignedCookie(this.cookieName, session.Id, this.signKey, &this.cookieOption)
+	out.UserSession = session
+	out.UserId = userId
+	this.registerSave(out)
+	return session, nil
+}
+
+// 更换会话id，会话内容不变。权限变更（如提升权限、修改密码）后调用
+func (this *CookieSessionStrategyClass) Rotate(out *api_session.ApiSessionClass) error {
+	if out.UserSession == nil {
+		return errors.New(`no session to rotate`)
+	}
+	oldId := out.UserSession.Id
+	session := out.UserSession.Clone()
+	session.Id = newSessionId()
+	if err := this.store.Save(session); err != nil {
+		return err
+	}
+	if err := this.store.Delete(oldId); err != nil {
+		return err
+	}
+	out.SetSignedCookie(this.cookieName, session.Id, this.signKey, &this.cookieOption)
+	out.UserSession = session
+	return nil
+}
+
+// 退出登录，删除会话和 cookie
+func (this *CookieSessionStrategyClass) Logout(out *api_session.ApiSessionClass) error {
+	if err := this.deleteCurrent(out); err != nil {
+		return err
+	}
+	out.DeleteCookie(this.cookieName, &this.cookieOption)
+	out.UserId = 0
+	return nil
+}
+
+// 按 cookie 读取会话，没有或者已经过期的话返回nil
+func (this *CookieSessionStrategyClass) load(out *api_session.ApiSessionClass) *api_session.UserSessionClass {
+	sessionId, err := out.GetSignedCookie(this.cookieName, this.signKey)
+	if err != nil {
+		return nil
+	}
+	session, err := this.store.Get(sessionId)
+	if err != nil {
+		go_error.ThrowInternalError(`load session error`, err)
+	}
+	return session
+}
+
+func (this *CookieSessionStrategyClass) deleteCurrent(out *api_session.ApiSessionClass) error {
+	session := out.UserSession
+	if session == nil {
+		session = this.load(out)
+	}
+	if session == nil {
+		return nil
+	}
+	out.UserSession = nil
+	return this.store.Delete(session.Id)
+}
+
+// 滑动过期时间，不超过最长有效期
+func (this *CookieSessionStrategyClass) getExpireAt(createdAt time.Time) time.Time {
+	expireAt := time.Now().Add(this.idleTtl)
+	if this.maxLifetime > 0 && expireAt.After(createdAt.Add(this.maxLifetime)) {
+		expireAt = createdAt.Add(this.maxLifetime)
+	}
+	return expireAt
+}
+
+// 在开始写入响应前保存会话（延长的有效期和控制器的修改），一个请求只注册一次。
+// 会话在请求期间被删除（如并发的退出登录、更换会话id）的话不再保存，避免被删除的会话复活
+func (this *CookieSessionStrategyClass) registerSave(out *api_session.ApiSessionClass) {
+	if _, ok := out.Datas[sessionSaveDataKey]; ok {
+		return
+	}
+	out.Datas[sessionSaveDataKey] = true
+	writer := &sessionSaveWriter{
+		ResponseWriter: out.ResponseWriter,
+		save: func() {
+			if out.UserSession == nil { // 已经退出登录
+				return
+			}
+			if _, err := this.store.SaveIfExists(out.UserSession); err != nil {
+				out.Logger.ErrorF(`save session error: %v`, err)
+			}
+		},
+	}
+	out.ResponseWriter = writer
+	out.AddDefer(writer.saveOnce) // 没有写入响应的话在请求结束时保存
+}
+
+// 第一次写入响应前保存会话的 ResponseWriter
+type sessionSaveWriter struct {
+	http.ResponseWriter
+	save func()
+	once sync.Once
+}
+
+func (this *sessionSaveWriter) saveOnce() {
+	this.once.Do(this.save)
+}
+
+func (this *sessionSaveWriter) WriteHeader(statusCode int) {
+	this.saveOnce()
+	this.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (this *sessionSaveWriter) Write(data []byte) (int, error) {
+	this.saveOnce()
+	return this.ResponseWriter.Write(data)
+}
+
+func (this *sessionSaveWriter) Flush() {
+	this.saveOnce()
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (this *sessionSaveWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := this.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New(`response writer does not implement http.Hijacker`)
+	}
+	this.saveOnce()
+	return hijacker.Hijack()
+}
+
+func (this *sessionSaveWriter) IsWritten() bool {
+	writer, ok := this.ResponseWriter.(interface{ IsWritten() bool })
+	return ok && writer.IsWritten()
+}
+
+func newSessionId() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
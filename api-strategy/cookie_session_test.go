@@ -0,0 +1,216 @@
+package api_strategy_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+// 登录、更换会话id、退出登录，以及 CSRF 校验
+func TestCookieSessionStrategyClass_Login(t *testing.T) {
+	store, err := api_strategy.NewFileSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionStrategy := api_strategy.NewCookieSessionStrategy()
+	sessionStrategy.SetSignKey([]byte(`session sign key`))
+	sessionStrategy.SetStore(store)
+	sessionStrategy.SetErrorCode(2007)
+	csrfStrategy := api_strategy.NewCsrfStrategy()
+	csrfStrategy.SetErrorCode(2008)
+
+	type loginParam struct {
+		UserId uint64 `json:"user_id" validate:"required"`
+	}
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/csrf`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: sessionStrategy,
+					Param:    api_strategy.CookieSessionParam{Optional: true},
+				},
+				{
+					Strategy: csrfStrategy,
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return csrfStrategy.GetToken(apiSession)
+			},
+		},
+		{
+			Path:   `/login`,
+			Method: api_session.ApiMethod_Post,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: sessionStrategy,
+					Param:    api_strategy.CookieSessionParam{Optional: true},
+				},
+				{
+					Strategy: csrfStrategy,
+				},
+			},
+			TypedController: func(apiSession *api_session.ApiSessionClass, params loginParam) (bool, error) {
+				session, err := sessionStrategy.Login(apiSession, params.UserId)
+				if err != nil {
+					return false, err
+				}
+				session.Set(`theme`, `dark`)
+				csrfStrategy.RenewToken(apiSession)
+				return true, nil
+			},
+		},
+		{
+			Path:   `/me`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: sessionStrategy,
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return fmt.Sprintf(`%d %v`, apiSession.UserId, apiSession.UserSession.Get(`theme`))
+			},
+		},
+		{
+			Path:   `/sudo`,
+			Method: api_session.ApiMethod_Post,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: sessionStrategy,
+				},
+				{
+					Strategy: csrfStrategy,
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				if err := sessionStrategy.Rotate(apiSession); err != nil {
+					go_error.ThrowInternalError(`rotate session error`, err)
+				}
+				csrfStrategy.RenewToken(apiSession)
+				return true
+			},
+		},
+		{
+			Path:   `/logout`,
+			Method: api_session.ApiMethod_Post,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: sessionStrategy,
+				},
+				{
+					Strategy: csrfStrategy,
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				if err := sessionStrategy.Logout(apiSession); err != nil {
+					go_error.ThrowInternalError(`logout error`, err)
+				}
+				return true
+			},
+		},
+	})
+
+	csrfCookie := client.Get(`/csrf`).Do().AssertCode(0).GetCookie(`csrf_token`)
+	if csrfCookie == nil || csrfCookie.HttpOnly {
+		t.Fatalf(`csrf cookie should be issued and readable, got %v`, csrfCookie)
+	}
+	csrfToken := csrfCookie.Value
+	client.Post(`/login`).WithJson(loginParam{UserId: 9}).WithCookie(`csrf_token`, csrfToken).Do().AssertCode(2008)
+	client.Post(`/login`).WithJson(loginParam{UserId: 9}).WithCookie(`csrf_token`, csrfToken).WithHeader(`X-Csrf-Token`, `other`).Do().AssertCode(2008)
+	// 其他地方写入的 cookie 没有正确的签名
+	client.Post(`/login`).WithJson(loginParam{UserId: 9}).WithCookie(`csrf_token`, `forged.token`).WithHeader(`X-Csrf-Token`, `forged.token`).Do().AssertCode(2008)
+	loginResponse := client.Post(`/login`).WithJson(loginParam{UserId: 9}).WithCookie(`csrf_token`, csrfToken).WithHeader(`X-Csrf-Token`, csrfToken).Do().AssertCode(0)
+	sessionCookie := loginResponse.GetCookie(`session_id`)
+	if sessionCookie == nil || !sessionCookie.HttpOnly {
+		t.Fatalf(`session cookie should be HttpOnly, got %v`, sessionCookie)
+	}
+	sessionCsrfToken := loginResponse.GetCookie(`csrf_token`).Value
+
+	var me string
+	client.Get(`/me`).WithCookie(`session_id`, sessionCookie.Value).Do().AssertCode(0).ScanData(&me)
+	if me != `9 dark` {
+		t.Errorf(`unexpected session %s`, me)
+	}
+	client.Get(`/me`).Do().AssertCode(2007)
+	client.Get(`/me`).WithCookie(`session_id`, sessionCookie.Value+`x`).Do().AssertCode(2007)
+
+	// 登录前的 token 不能用于登录后的会话
+	client.Post(`/sudo`).WithJson(map[string]interface{}{}).WithCookie(`session_id`, sessionCookie.Value).WithCookie(`csrf_token`, csrfToken).WithHeader(`X-Csrf-Token`, csrfToken).Do().AssertCode(2008)
+	sudoResponse := client.Post(`/sudo`).WithJson(map[string]interface{}{}).WithCookie(`session_id`, sessionCookie.Value).WithCookie(`csrf_token`, sessionCsrfToken).WithHeader(`X-Csrf-Token`, sessionCsrfToken).Do().AssertCode(0)
+	rotated := sudoResponse.GetCookie(`session_id`)
+	if rotated == nil || rotated.Value == sessionCookie.Value {
+		t.Fatalf(`session id should rotate`)
+	}
+	rotatedCsrfToken := sudoResponse.GetCookie(`csrf_token`).Value
+	client.Get(`/me`).WithCookie(`session_id`, sessionCookie.Value).Do().AssertCode(2007)
+	client.Get(`/me`).WithCookie(`session_id`, rotated.Value).Do().AssertCode(0).ScanData(&me)
+	if me != `9 dark` {
+		t.Errorf(`session values should be kept after rotation, got %s`, me)
+	}
+
+	client.Post(`/logout`).WithJson(map[string]interface{}{}).WithCookie(`session_id`, rotated.Value).WithCookie(`csrf_token`, sessionCsrfToken).WithHeader(`X-Csrf-Token`, sessionCsrfToken).Do().AssertCode(2008)
+	client.Post(`/logout`).WithJson(map[string]interface{}{}).WithCookie(`session_id`, rotated.Value).WithCookie(`csrf_token`, rotatedCsrfToken).WithHeader(`X-Csrf-Token`, rotatedCsrfToken).Do().AssertCode(0)
+	client.Get(`/me`).WithCookie(`session_id`, rotated.Value).Do().AssertCode(2007)
+
+	key := []byte(`0123456789abcdef`)
+	encrypted, err := api_session.EncryptCookieValue(`prefs`, `secret`, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := api_session.DecryptCookieValue(`prefs`, encrypted, key); err != nil || value != `secret` {
+		t.Errorf(`decrypt cookie error: %v %s`, err, value)
+	}
+	if _, err := api_session.DecryptCookieValue(`other`, encrypted, key); err != api_session.ErrInvalidCookie {
+		t.Errorf(`cookie moved to another name should be rejected`)
+	}
+}
+
+// 请求期间会话被删除（如另一个请求退出登录）的话，请求结束时不会把会话重新保存
+func TestCookieSessionStrategyClass_DeletedDuringRequest(t *testing.T) {
+	sessionStrategy := api_strategy.NewCookieSessionStrategy()
+	sessionStrategy.SetSignKey([]byte(`session sign key`))
+	sessionStrategy.SetErrorCode(2007)
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/login`,
+			Method: api_session.ApiMethod_Post,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				if _, err := sessionStrategy.Login(apiSession, 9); err != nil {
+					go_error.ThrowInternalError(`login error`, err)
+				}
+				return true
+			},
+		},
+		{
+			Path:   `/slow`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: sessionStrategy,
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				apiSession.UserSession.Set(`theme`, `dark`)
+				// 模拟控制器执行期间另一个请求退出登录
+				if err := sessionStrategy.GetStore().Delete(apiSession.UserSession.Id); err != nil {
+					go_error.ThrowInternalError(`delete session error`, err)
+				}
+				return true
+			},
+		},
+	})
+
+	sessionCookie := client.Post(`/login`).WithJson(map[string]interface{}{}).Do().AssertCode(0).GetCookie(`session_id`)
+	if sessionCookie == nil {
+		t.Fatal(`session cookie should be issued`)
+	}
+	client.Get(`/slow`).WithCookie(`session_id`, sessionCookie.Value).Do().AssertCode(0)
+	client.Get(`/slow`).WithCookie(`session_id`, sessionCookie.Value).Do().AssertCode(2007)
+}
@@ -0,0 +1,181 @@
+package api_strategy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-error"
+)
+
+/**
+CSRF 防护策略（签名的 double-submit），放在 cookie 会话策略之后。GET、HEAD、OPTIONS 请求没有有效的 token 的话下发 token cookie（前端可读），
+其他方法的请求需要把 cookie 中的 token 放到请求头 X-Csrf-Token 中，两者一致才放行。
+其他站点的页面读不到本站的 cookie，所以伪造的请求带不上正确的请求头。
+token 带有对会话id 的签名，只对当前会话有效，子域名等写入的 cookie 也没法伪造；登录、更换会话id 后需要调用 RenewToken
+*/
+type CsrfStrategyClass struct {
+	errorCode    uint64
+	errorMsg     string
+	cookieName   string
+	headerName   string
+	cookieOption api_session.CookieOption
+	signKey      []byte
+}
+
+var CsrfApiStrategy = CsrfStrategyClass{
+	errorCode:    go_error.INTERNAL_ERROR_CODE,
+	errorMsg:     `Forbidden`,
+	cookieName:   `csrf_token`,
+	headerName:   string(api_session.HeaderName_XCsrfToken),
+	cookieOption: defaultCsrfCookieOption,
+	signKey:      newCsrfRandomBytes(),
+}
+
+// token cookie 需要让前端读取，所以不能是 HttpOnly
+var defaultCsrfCookieOption = api_session.CookieOption{
+	SameSite: http.SameSiteLaxMode,
+}
+
+func NewCsrfStrategy() *CsrfStrategyClass {
+	return &CsrfStrategyClass{
+		errorCode:    go_error.INTERNAL_ERROR_CODE,
+		errorMsg:     `Forbidden`,
+		cookieName:   `csrf_token`,
+		headerName:   string(api_session.HeaderName_XCsrfToken),
+		cookieOption: defaultCsrfCookieOption,
+		signKey:      newCsrfRandomBytes(),
+	}
+}
+
+// 请求中的 csrf token，存放在 ApiSessionClass.Datas
+const csrfTokenDataKey = `csrfToken`
+
+func (this *CsrfStrategyClass) GetName() string {
+	return `csrf`
+}
+
+func (this *CsrfStrategyClass) GetDescription() string {
+	return `csrf protection`
+}
+
+func (this *CsrfStrategyClass) SetErrorCode(code uint64) {
+	this.errorCode = code
+}
+
+func (this *CsrfStrategyClass) SetErrorMessage(msg string) {
+	this.errorMsg = msg
+}
+
+func (this *CsrfStrategyClass) GetErrorCode() uint64 {
+	return this.errorCode
+}
+
+// token 所在的 cookie 名，默认 csrf_token。可以使用 __Host- 开头的名字防止子域名覆盖
+func (this *CsrfStrategyClass) SetCookieName(cookieName string) {
+	this.cookieName = cookieName
+}
+
+// 请求中带 token 的请求头，默认 X-Csrf-Token
+func (this *CsrfStrategyClass) SetHeaderName(headerName string) {
+	this.headerName = headerName
+}
+
+// token cookie 的选项，默认 SameSite=Lax
+func (this *CsrfStrategyClass) SetCookieOption(option api_session.CookieOption) {
+	this.cookieOption = option
+}
+
+// token 的签名密钥，默认是启动时随机生成的，多实例部署时需要设置同样的密钥
+func (this *CsrfStrategyClass) SetSignKey(signKey []byte) {
+	this.signKey = signKey
+}
+
+// csrf 防护不需要参数
+func (this *CsrfStrategyClass) Validate(param interface{}) error {
+	if this.cookieOption.HttpOnly {
+		return fmt.Errorf(`cookie of %s can not be HttpOnly`, this.GetName())
+	}
+	if len(this.signKey) == 0 {
+		return fmt.Errorf(`sign key of %s is not set`, this.GetName())
+	}
+	return nil
+}
+
+func (this *CsrfStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	token := out.GetCookie(this.cookieName)
+	switch out.GetMethod() {
+	case string(api_session.ApiMethod_Get), string(api_session.ApiMethod_Head), string(api_session.ApiMethod_Option):
+		if token == `` || !this.verifyToken(out, token) {
+			token = this.newToken(out)
+			out.SetCookie(this.cookieName, token, &this.cookieOption)
+		}
+	default:
+		headerToken := out.GetHeader(this.headerName)
+		if token == `` || headerToken == `` {
+			go_error.ThrowWithInternalMsg(this.errorMsg, `csrf token missing`, this.errorCode)
+		}
+		if !hmac.Equal([]byte(token), []byte(headerToken)) {
+			go_error.ThrowWithInternalMsg(this.errorMsg, `csrf token mismatch`, this.errorCode)
+		}
+		if !this.verifyToken(out, token) {
+			go_error.ThrowWithInternalMsg(this.errorMsg, `csrf token is not issued for this session`, this.errorCode)
+		}
+	}
+	out.Datas[csrfTokenDataKey] = token
+}
+
+// 当前请求的 csrf token，服务端渲染页面时可以放到页面中
+func (this *CsrfStrategyClass) GetToken(out *api_session.ApiSessionClass) string {
+	token, _ := out.Datas[csrfTokenDataKey].(string)
+	return token
+}
+
+// 按当前会话更换 token，登录、更换会话id 后调用
+func (this *CsrfStrategyClass) RenewToken(out *api_session.ApiSessionClass) string {
+	token := this.newToken(out)
+	out.SetCookie(this.cookieName, token, &this.cookieOption)
+	out.Datas[csrfTokenDataKey] = token
+	return token
+}
+
+// token 格式为 随机数.签名，签名绑定了随机数和会话id（没有登录的话为空）
+func (this *CsrfStrategyClass) newToken(out *api_session.ApiSessionClass) string {
+	nonce := base64.RawURLEncoding.EncodeToString(newCsrfRandomBytes())
+	return nonce + `.` + this.sign(nonce, getCsrfSessionId(out))
+}
+
+func (this *CsrfStrategyClass) verifyToken(out *api_session.ApiSessionClass, token string) bool {
+	parts := strings.SplitN(token, `.`, 2)
+	if len(parts) != 2 {
+		return false
+	}
+	return hmac.Equal([]byte(parts[1]), []byte(this.sign(parts[0], getCsrfSessionId(out))))
+}
+
+func (this *CsrfStrategyClass) sign(nonce string, sessionId string) string {
+	mac := hmac.New(sha256.New, this.signKey)
+	mac.Write([]byte(nonce + "\n" + sessionId))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func getCsrfSessionId(out *api_session.ApiSessionClass) string {
+	if out.UserSession == nil {
+		return ``
+	}
+	return out.UserSession.Id
+}
+
+func newCsrfRandomBytes() []byte {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return buf
+}
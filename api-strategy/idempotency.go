@@ -0,0 +1,251 @@
+package api_strategy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-error"
+)
+
+/**
+幂等策略。客户端在请求头 Idempotency-Key 中带上唯一的 key，同一个用户、同一个路由、同一个 key 的请求只处理一次，
+重复的请求直接返回第一次实际写入的响应（响应头 Idempotent-Replayed: true）。第一次请求还在处理中的话拒绝重复的请求；
+同一个 key 的参数不同的话也拒绝。参数摘要依赖 paramValidate 解析出的参数，策略必须放在 paramValidate 之后；
+需要区分用户的话还要放在鉴权策略之后。控制器自行写入响应、事件流等流式响应不保存
+*/
+type IdempotencyStrategyClass struct {
+	errorCode  uint64
+	headerName string
+	store      InterfaceIdempotencyStore
+}
+
+var IdempotencyApiStrategy = IdempotencyStrategyClass{
+	errorCode:  go_error.INTERNAL_ERROR_CODE,
+	headerName: string(api_session.HeaderName_IdempotencyKey),
+	store:      NewMemoryIdempotencyStore(),
+}
+
+// 新建幂等策略，默认使用内存存储
+func NewIdempotencyStrategy() *IdempotencyStrategyClass {
+	return &IdempotencyStrategyClass{
+		errorCode:  go_error.INTERNAL_ERROR_CODE,
+		headerName: string(api_session.HeaderName_IdempotencyKey),
+		store:      NewMemoryIdempotencyStore(),
+	}
+}
+
+type IdempotencyParam struct {
+	Required    bool          // 没有带 key 的话报错。否则不带 key 的请求不做幂等处理
+	Ttl         time.Duration // 结果保存多久，默认24小时
+	LockTimeout time.Duration // 处理中的记录多久后失效（进程异常退出时不会一直占用 key），默认1分钟
+	StoreErrors bool          // 出错的结果也保存，重复的请求返回同样的错误。否则出错后可以用同一个 key 重试
+}
+
+// 请求处理中的幂等信息，存放在 ApiSessionClass.Datas
+type idempotencyState struct {
+	key         string
+	fingerprint string
+	save        bool // 后置阶段决定保存结果
+}
+
+const idempotencyDataKey = `idempotency`
+
+func (this *IdempotencyStrategyClass) GetName() string {
+	return `idempotency`
+}
+
+func (this *IdempotencyStrategyClass) GetDescription() string {
+	return `replay the result of requests with the same idempotency key`
+}
+
+func (this *IdempotencyStrategyClass) SetErrorCode(code uint64) {
+	this.errorCode = code
+}
+
+func (this *IdempotencyStrategyClass) GetErrorCode() uint64 {
+	return this.errorCode
+}
+
+func (this *IdempotencyStrategyClass) SetHeaderName(headerName string) {
+	this.headerName = headerName
+}
+
+// 设置幂等记录的存储，多实例部署时需要使用共享存储
+func (this *IdempotencyStrategyClass) SetStore(store InterfaceIdempotencyStore) {
+	this.store = store
+}
+
+func (this *IdempotencyStrategyClass) GetStore() InterfaceIdempotencyStore {
+	return this.store
+}
+
+func (this *IdempotencyStrategyClass) GetDependencies() []string {
+	return []string{`paramValidate`}
+}
+
+func (this *IdempotencyStrategyClass) Validate(param interface{}) error {
+	newParam, ok := param.(IdempotencyParam)
+	if !ok {
+		return fmt.Errorf(`param of %s must be IdempotencyParam, got %T`, this.GetName(), param)
+	}
+	if newParam.Ttl < 0 || newParam.LockTimeout < 0 {
+		return fmt.Errorf(`param of %s: Ttl and LockTimeout must not be negative`, this.GetName())
+	}
+	if this.store == nil {
+		return fmt.Errorf(`store of %s is not set`, this.GetName())
+	}
+	return nil
+}
+
+func (this *IdempotencyStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	if param == nil {
+		go_error.Throw(`strategy need param`, this.errorCode)
+	}
+	newParam := param.(IdempotencyParam)
+	idempotencyKey := out.GetHeader(this.headerName)
+	if idempotencyKey == `` {
+		if newParam.Required {
+			go_error.ThrowWithInternalMsg(`idempotency key is required`, fmt.Sprintf(`header %s is empty`, this.headerName), this.errorCode)
+		}
+		return
+	}
+	key := fmt.Sprintf(`%s_%s_%d_%s`, out.GetMethod(), out.GetPath(), out.UserId, idempotencyKey)
+	fingerprint := this.getFingerprint(out)
+	lockTimeout := newParam.LockTimeout
+	if lockTimeout == 0 {
+		lockTimeout = time.Minute
+	}
+
+	record, acquired, err := this.store.Acquire(key, fingerprint, lockTimeout)
+	if err != nil {
+		go_error.ThrowInternalError(`idempotency store error`, err)
+	}
+	if !acquired {
+		if record.Fingerprint != fingerprint {
+			go_error.ThrowWithInternalMsg(`idempotency key is used by another request`, `fingerprint mismatch`, this.errorCode)
+		}
+		if !record.Completed {
+			go_error.Throw(`request is being processed`, this.errorCode)
+		}
+		// 原样重放第一次的响应，写入后请求结束
+		out.SetHeader(string(api_session.HeaderName_ContentType), record.ContentType)
+		out.SetHeader(string(api_session.HeaderName_IdempotentReplayed), `true`)
+		out.SetStatusCode(record.StatusCode)
+		out.ResponseWriter.WriteHeader(int(record.StatusCode))
+		if _, err := out.ResponseWriter.Write(record.Body); err != nil {
+			out.Logger.ErrorF(`replay idempotency result of %s error: %v`, key, err)
+		}
+		return
+	}
+
+	state := &idempotencyState{
+		key:         key,
+		fingerprint: fingerprint,
+	}
+	out.Datas[idempotencyDataKey] = state
+	writer := &idempotencyRecordWriter{
+		ResponseWriter: out.ResponseWriter,
+	}
+	out.ResponseWriter = writer
+	out.AddDefer(func() { // 响应写入后保存实际写入的内容（经过了所有后置策略和 ReturnHookFunc）；不保存的话释放 key
+		if state.save && out.Error != nil && !newParam.StoreErrors { // 排在前面的策略的后置处理出错了
+			state.save = false
+		}
+		if state.save && writer.statusCode != 0 && !writer.streamed {
+			ttl := newParam.Ttl
+			if ttl == 0 {
+				ttl = 24 * time.Hour
+			}
+			err := this.store.Complete(key, &IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Completed:   true,
+				StatusCode:  api_session.StatusCode(writer.statusCode),
+				ContentType: writer.contentType,
+				Body:        writer.body.Bytes(),
+			}, ttl)
+			if err == nil {
+				return
+			}
+			out.Logger.ErrorF(`save idempotency result of %s error: %v`, key, err)
+		}
+		if err := this.store.Release(key); err != nil {
+			out.Logger.ErrorF(`release idempotency key %s error: %v`, key, err)
+		}
+	})
+}
+
+func (this *IdempotencyStrategyClass) ExecuteAfter(out *api_session.ApiSessionClass, param interface{}, apiResult *api_session.ApiResult) *api_session.ApiResult {
+	state, ok := out.Datas[idempotencyDataKey].(*idempotencyState)
+	if !ok {
+		return apiResult
+	}
+	newParam := param.(IdempotencyParam)
+	state.save = apiResult.Code == 0 || newParam.StoreErrors // 响应写入后才保存
+	return apiResult
+}
+
+// 方法、路径和原始参数的摘要
+func (this *IdempotencyStrategyClass) getFingerprint(out *api_session.ApiSessionClass) string {
+	params, err := json.Marshal(out.OriginalParams)
+	if err != nil {
+		params = []byte(fmt.Sprint(out.OriginalParams))
+	}
+	sum := sha256.Sum256([]byte(out.GetMethod() + "\n" + out.GetPath() + "\n" + string(params)))
+	return hex.EncodeToString(sum[:])
+}
+
+// 记录实际写入的响应的 ResponseWriter。Flush 或者 Hijack 过的是流式响应，不保存
+type idempotencyRecordWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	contentType string
+	body        bytes.Buffer
+	streamed    bool
+}
+
+func (this *idempotencyRecordWriter) WriteHeader(statusCode int) {
+	if this.statusCode == 0 {
+		this.statusCode = statusCode
+		this.contentType = this.Header().Get(string(api_session.HeaderName_ContentType))
+	}
+	this.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (this *idempotencyRecordWriter) Write(data []byte) (int, error) {
+	if this.statusCode == 0 {
+		this.WriteHeader(http.StatusOK)
+	}
+	this.body.Write(data)
+	return this.ResponseWriter.Write(data)
+}
+
+func (this *idempotencyRecordWriter) Flush() {
+	this.streamed = true
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (this *idempotencyRecordWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := this.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New(`response writer does not implement http.Hijacker`)
+	}
+	this.streamed = true
+	return hijacker.Hijack()
+}
+
+func (this *idempotencyRecordWriter) IsWritten() bool {
+	writer, ok := this.ResponseWriter.(interface{ IsWritten() bool })
+	return ok && writer.IsWritten()
+}
@@ -0,0 +1,92 @@
+package api_strategy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pefish/go-core/api-session"
+)
+
+// 幂等记录。Completed 为 false 表示请求还在处理中
+type IdempotencyRecord struct {
+	Fingerprint string                 `json:"fingerprint"` // 请求参数的摘要，同一个 key 的参数不同的话拒绝
+	Completed   bool                   `json:"completed"`
+	StatusCode  api_session.StatusCode `json:"status_code"` // 以下是第一次请求实际写入的响应
+	ContentType string                 `json:"content_type"`
+	Body        []byte                 `json:"body"`
+}
+
+// 幂等记录的存储。多实例部署时需要实现成共享存储（如 redis），Acquire 必须是原子操作
+type InterfaceIdempotencyStore interface {
+	// 没有记录的话新建处理中的记录（lockTtl 后过期）并返回 true；已有记录（处理中或已完成）的话返回该记录和 false
+	Acquire(key string, fingerprint string, lockTtl time.Duration) (*IdempotencyRecord, bool, error)
+	// 保存最终结果，ttl 后过期
+	Complete(key string, record *IdempotencyRecord, ttl time.Duration) error
+	// 删除处理中的记录，客户端可以用同一个 key 重试
+	Release(key string) error
+}
+
+// 内存存储，只适用于单实例部署
+type MemoryIdempotencyStoreClass struct {
+	sync.Mutex
+	records      map[string]*memoryIdempotencyRecord
+	acquireCount int
+}
+
+type memoryIdempotencyRecord struct {
+	record   *IdempotencyRecord
+	expireAt time.Time
+}
+
+// 每 Acquire 多少次清理一次过期记录
+const memoryIdempotencyCleanInterval = 100
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStoreClass {
+	return &MemoryIdempotencyStoreClass{
+		records: map[string]*memoryIdempotencyRecord{},
+	}
+}
+
+func (this *MemoryIdempotencyStoreClass) Acquire(key string, fingerprint string, lockTtl time.Duration) (*IdempotencyRecord, bool, error) {
+	this.Lock()
+	defer this.Unlock()
+	now := time.Now()
+	this.acquireCount++
+	if this.acquireCount%memoryIdempotencyCleanInterval == 0 {
+		for recordKey, record := range this.records {
+			if now.After(record.expireAt) {
+				delete(this.records, recordKey)
+			}
+		}
+	}
+	if existing, ok := this.records[key]; ok && !now.After(existing.expireAt) {
+		record := *existing.record
+		return &record, false, nil
+	}
+	this.records[key] = &memoryIdempotencyRecord{
+		record: &IdempotencyRecord{
+			Fingerprint: fingerprint,
+		},
+		expireAt: now.Add(lockTtl),
+	}
+	return nil, true, nil
+}
+
+func (this *MemoryIdempotencyStoreClass) Complete(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	this.Lock()
+	defer this.Unlock()
+	this.records[key] = &memoryIdempotencyRecord{
+		record:   record,
+		expireAt: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+func (this *MemoryIdempotencyStoreClass) Release(key string) error {
+	this.Lock()
+	defer this.Unlock()
+	if existing, ok := this.records[key]; ok && !existing.record.Completed {
+		delete(this.records, key)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package api_strategy_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+// 同一个 key 的请求返回第一次的结果；参数不同或者第一次请求还在处理中时拒绝
+func TestIdempotencyStrategyClass_Replay(t *testing.T) {
+	idempotencyStrategy := api_strategy.NewIdempotencyStrategy()
+	idempotencyStrategy.SetErrorCode(2002)
+	calls := 0
+	entered := make(chan bool)
+	release := make(chan bool)
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/pay`,
+			Method: api_session.ApiMethod_Post,
+			Params: nameParam{},
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: idempotencyStrategy,
+					Param: api_strategy.IdempotencyParam{
+						Required: true,
+					},
+				},
+			},
+			TypedController: func(apiSession *api_session.ApiSessionClass, params nameParam) (*nameParam, error) {
+				calls++
+				if params.Name == `slow` {
+					entered <- true
+					<-release
+				}
+				return &nameParam{Name: fmt.Sprintf(`%s-%d`, params.Name, calls)}, nil
+			},
+		},
+	})
+
+	client.Post(`/pay`).WithJson(map[string]interface{}{`name`: `a`}).Do().AssertCode(2002)
+
+	first := nameParam{}
+	client.Post(`/pay`).WithHeader(`Idempotency-Key`, `k1`).WithJson(map[string]interface{}{`name`: `a`}).Do().AssertCode(0).ScanData(&first)
+	replayed := nameParam{}
+	response := client.Post(`/pay`).WithHeader(`Idempotency-Key`, `k1`).WithJson(map[string]interface{}{`name`: `a`}).Do().AssertCode(0).ScanData(&replayed)
+	if calls != 1 || replayed.Name != first.Name || response.GetHeader(`Idempotent-Replayed`) != `true` {
+		t.Fatalf(`expect replayed result %s, got %s after %d calls`, first.Name, replayed.Name, calls)
+	}
+	client.Post(`/pay`).WithHeader(`Idempotency-Key`, `k1`).WithJson(map[string]interface{}{`name`: `b`}).Do().AssertCode(2002).AssertMsg(`idempotency key is used by another request`)
+
+	// 第一次请求还在处理中
+	done := make(chan bool)
+	go func() {
+		client.Post(`/pay`).WithHeader(`Idempotency-Key`, `k2`).WithJson(map[string]interface{}{`name`: `slow`}).Do()
+		done <- true
+	}()
+	<-entered
+	client.Post(`/pay`).WithHeader(`Idempotency-Key`, `k2`).WithJson(map[string]interface{}{`name`: `slow`}).Do().AssertCode(2002).AssertMsg(`request is being processed`)
+	release <- true
+	<-done
+	if calls != 2 {
+		t.Errorf(`expect 2 calls, got %d`, calls)
+	}
+}
+
+// 重放的是第一次实际写入的响应（经过了 ReturnHookFunc），出错的结果不保存
+func TestIdempotencyStrategyClass_ReplayWrittenResponse(t *testing.T) {
+	calls := 0
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/order`,
+			Method: api_session.ApiMethod_Post,
+			Params: nameParam{},
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: api_strategy.NewIdempotencyStrategy(),
+					Param:    api_strategy.IdempotencyParam{},
+				},
+			},
+			ReturnHookFunc: func(apiContext *api_session.ApiSessionClass, apiResult *api.ApiResult) (interface{}, *go_error.ErrorInfo) {
+				apiContext.SetStatusCode(api_session.StatusCode(201))
+				return map[string]interface{}{
+					`wrapped`: apiResult.Data,
+					`call`:    calls,
+				}, nil
+			},
+			TypedController: func(apiSession *api_session.ApiSessionClass, params nameParam) (*nameParam, error) {
+				calls++
+				if params.Name == `fail` && calls == 1 {
+					return nil, errors.New(`fail`)
+				}
+				return &params, nil
+			},
+		},
+	})
+
+	first := client.Post(`/order`).WithHeader(`Idempotency-Key`, `k1`).WithJson(map[string]interface{}{`name`: `a`}).Do().AssertStatus(201)
+	replayed := client.Post(`/order`).WithHeader(`Idempotency-Key`, `k1`).WithJson(map[string]interface{}{`name`: `a`}).Do().AssertStatus(201)
+	if calls != 1 || replayed.GetBody() != first.GetBody() || replayed.GetHeader(`Content-Type`) != first.GetHeader(`Content-Type`) {
+		t.Fatalf(`expect replayed body %s, got %s after %d calls`, first.GetBody(), replayed.GetBody(), calls)
+	}
+
+	calls = 0
+	client.Post(`/order`).WithHeader(`Idempotency-Key`, `k2`).WithJson(map[string]interface{}{`name`: `fail`}).Do()
+	retried := client.Post(`/order`).WithHeader(`Idempotency-Key`, `k2`).WithJson(map[string]interface{}{`name`: `fail`}).Do().AssertStatus(201)
+	if calls != 2 || retried.GetHeader(`Idempotent-Replayed`) != `` {
+		t.Fatalf(`expect failed request to be retried, got %d calls`, calls)
+	}
+}
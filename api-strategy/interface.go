@@ -3,9 +3,51 @@ package api_strategy
 import api_session "github.com/pefish/go-core/api-session"
 
 type InterfaceStrategy interface {
+	// 前置处理。写入了响应的话（如缓存命中）请求到此结束，后面的策略、控制器和后置处理都不再执行
 	Execute(out *api_session.ApiSessionClass, param interface{})
 	GetName() string
 	GetDescription() string
 	GetErrorCode() uint64
 }
 
+// 后置策略。策略可以选择实现此接口，在控制器执行完之后（无论成功还是出错）处理返回结果。
+// 后置阶段按前置阶段的逆序执行，即先执行路由策略（从后往前），再执行全局策略（从后往前）。
+// 只有前置处理（Execute）正常结束的策略才会执行后置处理；控制器返回nil（自行写入了响应）时不执行后置阶段
+type InterfaceAfterStrategy interface {
+	// apiResult.Code 不为0表示请求出错，错误详情见 out.Error。
+	// 返回值会替换原有的返回结果，可以直接修改后返回 apiResult；返回nil表示策略已经自行写入了响应，框架不再写入，剩下的后置策略和 ReturnHookFunc 也不再执行
+	ExecuteAfter(out *api_session.ApiSessionClass, param interface{}, apiResult *api_session.ApiResult) *api_session.ApiResult
+}
+
+// 参数校验。策略可以选择实现此接口，服务启动时会用它检查策略参数，有问题的话拒绝启动
+type InterfaceParamValidator interface {
+	Validate(param interface{}) error
+}
+
+// 策略可以选择实现此接口，生成的文档中显示路由的策略参数，例如需要的角色
+type InterfaceDocDescriber interface {
+	GetDocDescription(param interface{}) string
+}
+
+// 鉴权策略。策略可以选择实现此接口，swagger 文档据此生成安全定义和路由的安全要求
+type InterfaceSecurityStrategy interface {
+	// 可以任选其一的安全定义，例如请求头或者 query 参数
+	GetSecuritySchemes() []SecurityScheme
+	// 路由需要的权限范围
+	GetRequiredScopes(param interface{}) []string
+}
+
+// swagger securityDefinitions 中的一项
+type SecurityScheme struct {
+	Name        string // securityDefinitions 中的名字
+	Type        string // apiKey、basic、oauth2
+	In          string // header、query
+	KeyName     string // 请求头或者 query 参数的名字
+	Description string
+}
+
+// 依赖其他策略的策略可以选择实现此接口，服务启动时检查依赖的策略在它之前执行，否则拒绝启动
+type InterfaceDependentStrategy interface {
+	// 必须在此策略之前执行的策略名字
+	GetDependencies() []string
+}
@@ -1,8 +1,8 @@
 package api_strategy
 
 import (
+	"fmt"
 	"github.com/pefish/go-core/api-session"
-	"github.com/pefish/go-core/driver/logger"
 	"github.com/pefish/go-error"
 )
 
@@ -14,6 +14,13 @@ var IpFilterStrategy = IpFilterStrategyClass{
 	errorCode: go_error.INTERNAL_ERROR_CODE,
 }
 
+// 新建ip过滤策略，不同的服务或路由可以各自配置
+func NewIpFilterStrategy() *IpFilterStrategyClass {
+	return &IpFilterStrategyClass{
+		errorCode: go_error.INTERNAL_ERROR_CODE,
+	}
+}
+
 type IpFilterParam struct {
 	GetValidIp func(apiSession *api_session.ApiSessionClass) []string
 }
@@ -34,8 +41,15 @@ func (this *IpFilterStrategyClass) GetErrorCode() uint64 {
 	return this.errorCode
 }
 
+func (this *IpFilterStrategyClass) Validate(param interface{}) error {
+	if _, ok := param.(IpFilterParam); !ok {
+		return fmt.Errorf(`param of %s must be IpFilterParam, got %T`, this.GetName(), param)
+	}
+	return nil
+}
+
 func (this *IpFilterStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
 	if param == nil {
 		go_error.Throw(`strategy need param`, this.errorCode)
 	}
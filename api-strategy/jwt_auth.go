@@ -1,9 +1,9 @@
 package api_strategy
 
 import (
+	"errors"
 	jwt2 "github.com/dgrijalva/jwt-go"
 	"github.com/pefish/go-core/api-session"
-	"github.com/pefish/go-core/driver/logger"
 	"github.com/pefish/go-core/util"
 	"github.com/pefish/go-error"
 	"github.com/pefish/go-jwt"
@@ -17,6 +17,7 @@ type JwtAuthStrategyClass struct {
 	noCheckExpire       bool
 	disableUserId       bool
 	errorMsg            string
+	revocationList      InterfaceRevocationList
 }
 
 var JwtAuthApiStrategy = JwtAuthStrategyClass{
@@ -24,6 +25,14 @@ var JwtAuthApiStrategy = JwtAuthStrategyClass{
 	errorMsg:            `Unauthorized`,
 }
 
+// 新建jwt鉴权策略，不同的服务可以使用不同的公钥
+func NewJwtAuthStrategy() *JwtAuthStrategyClass {
+	return &JwtAuthStrategyClass{
+		errorCode: go_error.INTERNAL_ERROR_CODE,
+		errorMsg:  `Unauthorized`,
+	}
+}
+
 type JwtAuthParam struct {
 }
 
@@ -59,12 +68,37 @@ func (this *JwtAuthStrategyClass) SetPubKey(pubKey string) {
 	this.pubKey = pubKey
 }
 
+func (this *JwtAuthStrategyClass) GetPubKey() string {
+	return this.pubKey
+}
+
 func (this *JwtAuthStrategyClass) SetHeaderName(headerName string) {
 	this.headerName = headerName
 }
 
+func (this *JwtAuthStrategyClass) GetHeaderName() string {
+	return this.headerName
+}
+
+// 设置吊销列表，jwt 中 jti 被吊销的请求会被拒绝
+func (this *JwtAuthStrategyClass) SetRevocationList(revocationList InterfaceRevocationList) {
+	this.revocationList = revocationList
+}
+
+// jwt鉴权不需要参数，这里检查公钥是否已经设置。
+// 吊销记录只保存到 jwt 过期为止，不检查过期的话吊销的 jwt 过期后又能用了，所以两者不能同时使用
+func (this *JwtAuthStrategyClass) Validate(param interface{}) error {
+	if this.pubKey == `` {
+		return errors.New(`pub key of jwtAuth is not set`)
+	}
+	if this.noCheckExpire && this.revocationList != nil {
+		return errors.New(`revocation list of jwtAuth can not be used with SetNoCheckExpire`)
+	}
+	return nil
+}
+
 func (this *JwtAuthStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
 	out.JwtHeaderName = this.headerName
 	jwt := out.GetHeader(this.headerName)
 
@@ -76,6 +110,15 @@ func (this *JwtAuthStrategyClass) Execute(out *api_session.ApiSessionClass, para
 		go_error.ThrowWithInternalMsg(this.errorMsg, `jwt verify error or jwt expired`, this.errorCode)
 	}
 	out.JwtBody = token.Claims.(jwt2.MapClaims)
+	if tokenId, ok := out.JwtBody[`jti`].(string); ok && tokenId != `` && this.revocationList != nil {
+		revoked, err := this.revocationList.IsRevoked(tokenId)
+		if err != nil {
+			go_error.ThrowInternalError(`check jwt revocation error`, err)
+		}
+		if revoked {
+			go_error.ThrowWithInternalMsg(this.errorMsg, `jwt revoked`, this.errorCode)
+		}
+	}
 	if !this.disableUserId {
 		jwtPayload := out.JwtBody[`payload`].(map[string]interface{})
 		if jwtPayload[`user_id`] == nil {
@@ -0,0 +1,22 @@
+package api_strategy_test
+
+import (
+	"testing"
+
+	api_strategy "github.com/pefish/go-core/api-strategy"
+)
+
+// 吊销记录在 jwt 过期后删除，不检查过期的话不能使用吊销列表
+func TestJwtAuthStrategyClass_Validate(t *testing.T) {
+	jwtAuthStrategy := api_strategy.NewJwtAuthStrategy()
+	jwtAuthStrategy.SetPubKey(`pub key`)
+	jwtAuthStrategy.SetRevocationList(api_strategy.NewMemoryRevocationList())
+	if err := jwtAuthStrategy.Validate(nil); err != nil {
+		t.Fatalf(`expect no error, got %v`, err)
+	}
+	jwtAuthStrategy.SetNoCheckExpire()
+	err := jwtAuthStrategy.Validate(nil)
+	if err == nil || err.Error() != `revocation list of jwtAuth can not be used with SetNoCheckExpire` {
+		t.Errorf(`expect revocation list to be refused, got %v`, err)
+	}
+}
@@ -0,0 +1,47 @@
+package api_strategy
+
+import (
+	"sync"
+	"time"
+)
+
+// 用过的 nonce 的存储，签名策略用来防重放。多实例部署时需要实现成共享存储，Remember 必须是原子操作
+type InterfaceNonceStore interface {
+	// nonce 没有用过的话记录下来（ttl 后过期）并返回 true，用过的话返回 false
+	Remember(nonce string, ttl time.Duration) (bool, error)
+}
+
+// 内存存储，只适用于单实例部署
+type MemoryNonceStoreClass struct {
+	sync.Mutex
+	nonces        map[string]time.Time // nonce -> 过期时间
+	rememberCount int
+}
+
+// 每记录多少次清理一次过期的 nonce
+const memoryNonceCleanInterval = 100
+
+func NewMemoryNonceStore() *MemoryNonceStoreClass {
+	return &MemoryNonceStoreClass{
+		nonces: map[string]time.Time{},
+	}
+}
+
+func (this *MemoryNonceStoreClass) Remember(nonce string, ttl time.Duration) (bool, error) {
+	this.Lock()
+	defer this.Unlock()
+	now := time.Now()
+	this.rememberCount++
+	if this.rememberCount%memoryNonceCleanInterval == 0 {
+		for key, expireAt := range this.nonces {
+			if now.After(expireAt) {
+				delete(this.nonces, key)
+			}
+		}
+	}
+	if expireAt, ok := this.nonces[nonce]; ok && !now.After(expireAt) {
+		return false, nil
+	}
+	this.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
@@ -3,21 +3,36 @@ package api_strategy
 import (
 	"fmt"
 	"github.com/pefish/go-core/api-session"
-	"github.com/pefish/go-core/driver/logger"
 	"github.com/pefish/go-error"
+	"sync"
 	"time"
 )
 
 type RateLimitStrategyClass struct {
 	errorCode uint64
-	db        *map[string]time.Time // 外部传入的存储api访问频率限制的信息，应当是全局变量
+	db        *rateLimitDb // 存储api访问频率限制的信息，每个策略实例一份
 }
 
-var defaultDb = map[string]time.Time{}
+type rateLimitDb struct {
+	sync.Mutex
+	lastAccess map[string]time.Time
+}
 
 var RateLimitApiStrategy = RateLimitStrategyClass{
 	errorCode: go_error.INTERNAL_ERROR_CODE,
-	db:        &defaultDb,
+	db: &rateLimitDb{
+		lastAccess: map[string]time.Time{},
+	},
+}
+
+// 新建限流策略，访问记录与其他实例相互独立
+func NewRateLimitStrategy() *RateLimitStrategyClass {
+	return &RateLimitStrategyClass{
+		errorCode: go_error.INTERNAL_ERROR_CODE,
+		db: &rateLimitDb{
+			lastAccess: map[string]time.Time{},
+		},
+	}
 }
 
 type RateLimitParam struct {
@@ -40,17 +55,30 @@ func (this *RateLimitStrategyClass) GetErrorCode() uint64 {
 	return this.errorCode
 }
 
+func (this *RateLimitStrategyClass) Validate(param interface{}) error {
+	newParam, ok := param.(RateLimitParam)
+	if !ok {
+		return fmt.Errorf(`param of %s must be RateLimitParam, got %T`, this.GetName(), param)
+	}
+	if newParam.Limit <= 0 {
+		return fmt.Errorf(`param of %s: Limit must be greater than 0`, this.GetName())
+	}
+	return nil
+}
+
 func (this *RateLimitStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
 	if param == nil {
 		go_error.Throw(`strategy need param`, this.errorCode)
 	}
 	newParam := param.(RateLimitParam)
 	methodPath := fmt.Sprintf(`%s_%s`, out.GetMethod(), out.GetPath())
 	key := fmt.Sprintf(`%s_%s`, out.GetRemoteAddress(), methodPath)
-	if !(*this.db)[key].IsZero() && time.Now().Sub((*this.db)[key]) < newParam.Limit {
+	this.db.Lock()
+	defer this.db.Unlock()
+	if !this.db.lastAccess[key].IsZero() && time.Now().Sub(this.db.lastAccess[key]) < newParam.Limit {
 		go_error.Throw(`api ratelimit`, this.errorCode)
 	}
 
-	(*this.db)[key] = time.Now()
+	this.db.lastAccess[key] = time.Now()
 }
@@ -0,0 +1,127 @@
+package api_strategy
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 序列化后的响应的 LRU 缓存，缓存策略使用。超过容量时淘汰最久没有访问的响应
+type ResponseCacheClass struct {
+	sync.Mutex
+	capacity int
+	list     *list.List               // 最近访问的在前面
+	items    map[string]*list.Element // key -> *cacheEntry
+}
+
+type cacheEntry struct {
+	key         string
+	method      string
+	path        string
+	contentType string
+	body        []byte
+	etag        string
+	expireAt    time.Time
+}
+
+func NewResponseCache(capacity int) *ResponseCacheClass {
+	return &ResponseCacheClass{
+		capacity: capacity,
+		list:     list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (this *ResponseCacheClass) get(key string) *cacheEntry {
+	this.Lock()
+	defer this.Unlock()
+	element, ok := this.items[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expireAt) {
+		this.removeElement(element)
+		return nil
+	}
+	this.list.MoveToFront(element)
+	return entry
+}
+
+func (this *ResponseCacheClass) set(entry *cacheEntry) {
+	this.Lock()
+	defer this.Unlock()
+	if element, ok := this.items[entry.key]; ok {
+		element.Value = entry
+		this.list.MoveToFront(element)
+		return
+	}
+	this.items[entry.key] = this.list.PushFront(entry)
+	this.evict()
+}
+
+// 修改容量，超出的部分按 LRU 淘汰。可以在服务运行时调用
+func (this *ResponseCacheClass) SetCapacity(capacity int) {
+	this.Lock()
+	defer this.Unlock()
+	this.capacity = capacity
+	this.evict()
+}
+
+// 淘汰超出容量的响应，调用前需要加锁
+func (this *ResponseCacheClass) evict() {
+	for this.capacity > 0 && this.list.Len() > this.capacity {
+		this.removeElement(this.list.Back())
+	}
+}
+
+func (this *ResponseCacheClass) removeElement(element *list.Element) {
+	this.list.Remove(element)
+	delete(this.items, element.Value.(*cacheEntry).key)
+}
+
+// 删除一个路由（所有参数）的缓存。method 为空的话删除所有方法的
+func (this *ResponseCacheClass) Invalidate(method string, path string) int {
+	return this.invalidateFunc(func(entry *cacheEntry) bool {
+		return (method == `` || entry.method == method) && entry.path == path
+	})
+}
+
+// 删除路径以 prefix 开头的缓存，例如 /api/user/ 下的所有路由
+func (this *ResponseCacheClass) InvalidatePrefix(prefix string) int {
+	return this.invalidateFunc(func(entry *cacheEntry) bool {
+		return strings.HasPrefix(entry.path, prefix)
+	})
+}
+
+// 清空缓存
+func (this *ResponseCacheClass) Clear() {
+	this.Lock()
+	defer this.Unlock()
+	this.list.Init()
+	this.items = map[string]*list.Element{}
+}
+
+// 缓存的响应数（包括已经过期但还没有淘汰的）
+func (this *ResponseCacheClass) Len() int {
+	this.Lock()
+	defer this.Unlock()
+	return this.list.Len()
+}
+
+// 返回删除的个数
+func (this *ResponseCacheClass) invalidateFunc(match func(entry *cacheEntry) bool) int {
+	this.Lock()
+	defer this.Unlock()
+	count := 0
+	for element := this.list.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*cacheEntry)) {
+			this.removeElement(element)
+			count++
+		}
+		element = next
+	}
+	return count
+}
@@ -0,0 +1,52 @@
+package api_strategy
+
+import (
+	"sync"
+	"time"
+)
+
+// 吊销的 jwt（按 jti），jwt 鉴权策略每个请求都会检查。多实例部署时需要实现成共享存储
+type InterfaceRevocationList interface {
+	// 吊销 jwt，记录保存到 jwt 过期为止
+	Revoke(tokenId string, expireAt time.Time) error
+	IsRevoked(tokenId string) (bool, error)
+}
+
+// 内存中的吊销列表，只适用于单实例部署
+type MemoryRevocationListClass struct {
+	sync.Mutex
+	tokens      map[string]time.Time // jti -> 过期时间
+	revokeCount int
+}
+
+// 每吊销多少次清理一次过期的记录
+const memoryRevocationCleanInterval = 100
+
+func NewMemoryRevocationList() *MemoryRevocationListClass {
+	return &MemoryRevocationListClass{
+		tokens: map[string]time.Time{},
+	}
+}
+
+func (this *MemoryRevocationListClass) Revoke(tokenId string, expireAt time.Time) error {
+	this.Lock()
+	defer this.Unlock()
+	this.revokeCount++
+	if this.revokeCount%memoryRevocationCleanInterval == 0 {
+		now := time.Now()
+		for key, tokenExpireAt := range this.tokens {
+			if now.After(tokenExpireAt) {
+				delete(this.tokens, key)
+			}
+		}
+	}
+	this.tokens[tokenId] = expireAt
+	return nil
+}
+
+func (this *MemoryRevocationListClass) IsRevoked(tokenId string) (bool, error) {
+	this.Lock()
+	defer this.Unlock()
+	expireAt, ok := this.tokens[tokenId]
+	return ok && time.Now().Before(expireAt), nil
+}
@@ -0,0 +1,93 @@
+package api_strategy
+
+import (
+	"sync"
+	"time"
+)
+
+// 用户的角色和权限
+type Grants struct {
+	Roles       []string
+	Permissions []string
+}
+
+// 根据用户id 查找角色和权限（如查数据库），授权策略使用
+type InterfaceRoleResolver interface {
+	Resolve(userId uint64) (*Grants, error)
+}
+
+// 函数形式的角色来源
+type RoleResolverFunc func(userId uint64) (*Grants, error)
+
+func (this RoleResolverFunc) Resolve(userId uint64) (*Grants, error) {
+	return this(userId)
+}
+
+// 带缓存的角色来源，角色变更后调用 Invalidate 让缓存失效
+type CachedRoleResolverClass struct {
+	sync.Mutex
+	resolver   InterfaceRoleResolver
+	ttl        time.Duration
+	grants     map[uint64]*cachedGrants
+	writeCount int
+}
+
+type cachedGrants struct {
+	grants   *Grants
+	expireAt time.Time
+}
+
+// 每写入多少次清理一次过期的缓存
+const cachedRoleResolverCleanInterval = 100
+
+func NewCachedRoleResolver(resolver InterfaceRoleResolver, ttl time.Duration) *CachedRoleResolverClass {
+	return &CachedRoleResolverClass{
+		resolver: resolver,
+		ttl:      ttl,
+		grants:   map[uint64]*cachedGrants{},
+	}
+}
+
+// 出错的结果不缓存
+func (this *CachedRoleResolverClass) Resolve(userId uint64) (*Grants, error) {
+	this.Lock()
+	cached, ok := this.grants[userId]
+	this.Unlock()
+	if ok && time.Now().Before(cached.expireAt) {
+		return cached.grants, nil
+	}
+	grants, err := this.resolver.Resolve(userId)
+	if err != nil {
+		return nil, err
+	}
+	this.Lock()
+	defer this.Unlock()
+	now := time.Now()
+	this.writeCount++
+	if this.writeCount%cachedRoleResolverCleanInterval == 0 {
+		for cachedUserId, cached := range this.grants {
+			if !now.Before(cached.expireAt) {
+				delete(this.grants, cachedUserId)
+			}
+		}
+	}
+	this.grants[userId] = &cachedGrants{
+		grants:   grants,
+		expireAt: now.Add(this.ttl),
+	}
+	return grants, nil
+}
+
+// 删除用户的缓存
+func (this *CachedRoleResolverClass) Invalidate(userId uint64) {
+	this.Lock()
+	defer this.Unlock()
+	delete(this.grants, userId)
+}
+
+// 清空缓存
+func (this *CachedRoleResolverClass) Clear() {
+	this.Lock()
+	defer this.Unlock()
+	this.grants = map[uint64]*cachedGrants{}
+}
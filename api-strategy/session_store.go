@@ -0,0 +1,214 @@
+package api_strategy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pefish/go-core/api-session"
+)
+
+// 服务端会话的存储。多实例部署时需要实现成共享存储（如 redis）
+type InterfaceSessionStore interface {
+	// 不存在或者已经过期的话返回 nil, nil
+	Get(id string) (*api_session.UserSessionClass, error)
+	// 保存到 session.ExpireAt 为止
+	Save(session *api_session.UserSessionClass) error
+	// 会话还存在（没有被删除、没有过期）的话才保存，返回是否保存了。需要原子地判断和保存，redis 可以用 SET XX
+	SaveIfExists(session *api_session.UserSessionClass) (bool, error)
+	Delete(id string) error
+}
+
+// 内存存储，只适用于单实例部署
+type MemorySessionStoreClass struct {
+	sync.Mutex
+	sessions  map[string]*api_session.UserSessionClass
+	saveCount int
+}
+
+// 每保存多少次清理一次过期的会话
+const sessionStoreCleanInterval = 100
+
+func NewMemorySessionStore() *MemorySessionStoreClass {
+	return &MemorySessionStoreClass{
+		sessions: map[string]*api_session.UserSessionClass{},
+	}
+}
+
+func (this *MemorySessionStoreClass) Get(id string) (*api_session.UserSessionClass, error) {
+	this.Lock()
+	defer this.Unlock()
+	session, ok := this.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(session.ExpireAt) {
+		delete(this.sessions, id)
+		return nil, nil
+	}
+	return session.Clone(), nil
+}
+
+func (this *MemorySessionStoreClass) Save(session *api_session.UserSessionClass) error {
+	this.Lock()
+	defer this.Unlock()
+	this.saveCount++
+	if this.saveCount%sessionStoreCleanInterval == 0 {
+		now := time.Now()
+		for id, saved := range this.sessions {
+			if now.After(saved.ExpireAt) {
+				delete(this.sessions, id)
+			}
+		}
+	}
+	this.sessions[session.Id] = session.Clone()
+	return nil
+}
+
+func (this *MemorySessionStoreClass) SaveIfExists(session *api_session.UserSessionClass) (bool, error) {
+	this.Lock()
+	defer this.Unlock()
+	saved, ok := this.sessions[session.Id]
+	if !ok || time.Now().After(saved.ExpireAt) {
+		return false, nil
+	}
+	this.sessions[session.Id] = session.Clone()
+	return true, nil
+}
+
+func (this *MemorySessionStoreClass) Delete(id string) error {
+	this.Lock()
+	defer this.Unlock()
+	delete(this.sessions, id)
+	return nil
+}
+
+// 文件存储，每个会话一个 json 文件（文件名是会话id 的 hash），重启后会话还在。只适用于单实例部署
+type FileSessionStoreClass struct {
+	sync.Mutex
+	dir       string
+	saveCount int
+}
+
+// 新建文件存储，目录不存在的话创建
+func NewFileSessionStore(dir string) (*FileSessionStoreClass, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf(`create session dir error: %v`, err)
+	}
+	return &FileSessionStoreClass{
+		dir: dir,
+	}, nil
+}
+
+func (this *FileSessionStoreClass) Get(id string) (*api_session.UserSessionClass, error) {
+	this.Lock()
+	defer this.Unlock()
+	session, err := this.read(this.getPath(id))
+	if err != nil || session == nil {
+		return nil, err
+	}
+	if session.Id != id {
+		return nil, nil
+	}
+	if time.Now().After(session.ExpireAt) {
+		os.Remove(this.getPath(id))
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (this *FileSessionStoreClass) Save(session *api_session.UserSessionClass) error {
+	this.Lock()
+	defer this.Unlock()
+	this.saveCount++
+	if this.saveCount%sessionStoreCleanInterval == 0 {
+		this.clean()
+	}
+	return this.write(session)
+}
+
+func (this *FileSessionStoreClass) SaveIfExists(session *api_session.UserSessionClass) (bool, error) {
+	this.Lock()
+	defer this.Unlock()
+	saved, err := this.read(this.getPath(session.Id))
+	if err != nil {
+		return false, err
+	}
+	if saved == nil || saved.Id != session.Id || time.Now().After(saved.ExpireAt) {
+		return false, nil
+	}
+	if err := this.write(session); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (this *FileSessionStoreClass) Delete(id string) error {
+	this.Lock()
+	defer this.Unlock()
+	if err := os.Remove(this.getPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (this *FileSessionStoreClass) getPath(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(this.dir, hex.EncodeToString(sum[:])+`.json`)
+}
+
+// 写入会话文件，调用前需要加锁
+func (this *FileSessionStoreClass) write(session *api_session.UserSessionClass) error {
+	content, err := json.Marshal(session.Clone())
+	if err != nil {
+		return err
+	}
+	path := this.getPath(session.Id)
+	tempPath := path + `.tmp`
+	if err := ioutil.WriteFile(tempPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path) // 先写临时文件再改名，读取时不会读到写了一半的文件
+}
+
+// 文件不存在的话返回 nil, nil
+func (this *FileSessionStoreClass) read(path string) (*api_session.UserSessionClass, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	session := api_session.UserSessionClass{}
+	if err := json.Unmarshal(content, &session); err != nil {
+		return nil, fmt.Errorf(`decode session file %s error: %v`, path, err)
+	}
+	return &session, nil
+}
+
+// 删除过期的会话文件，调用前需要加锁
+func (this *FileSessionStoreClass) clean() {
+	files, err := ioutil.ReadDir(this.dir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), `.json`) {
+			continue
+		}
+		path := filepath.Join(this.dir, file.Name())
+		session, err := this.read(path)
+		if err != nil || (session != nil && now.After(session.ExpireAt)) {
+			os.Remove(path)
+		}
+	}
+}
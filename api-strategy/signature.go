@@ -0,0 +1,146 @@
+package api_strategy
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/signature"
+	"github.com/pefish/go-core/util"
+	"github.com/pefish/go-error"
+)
+
+/**
+请求签名策略，用于服务之间的调用。调用方用自己 app 的密钥对方法、路径、排好序的参数、时间戳和 nonce 签名，
+放在请求头 X-App-Id、X-Timestamp、X-Nonce、X-Signature 中。时间戳偏差超过 MaxSkew 或者 nonce 重复的请求被拒绝。
+验证通过后 ApiSessionClass.AppId 为调用方的 app id。策略要放在参数校验策略之后
+*/
+type SignatureStrategyClass struct {
+	errorCode   uint64
+	keyProvider InterfaceSignKeyProvider
+	nonceStore  InterfaceNonceStore
+}
+
+var SignatureApiStrategy = SignatureStrategyClass{
+	errorCode:  go_error.INTERNAL_ERROR_CODE,
+	nonceStore: NewMemoryNonceStore(),
+}
+
+// 新建签名策略，需要设置密钥来源。默认使用内存存储 nonce
+func NewSignatureStrategy() *SignatureStrategyClass {
+	return &SignatureStrategyClass{
+		errorCode:  go_error.INTERNAL_ERROR_CODE,
+		nonceStore: NewMemoryNonceStore(),
+	}
+}
+
+// app 的密钥。HMAC-SHA256 是共享密钥，Ed25519 是调用方的公钥
+type SignKey struct {
+	Algorithm signature.Algorithm
+	Key       []byte
+}
+
+// 根据 app id 查找密钥。app 不存在或者被禁用的话返回 error
+type InterfaceSignKeyProvider interface {
+	GetSignKey(appId string) (*SignKey, error)
+}
+
+// 函数形式的密钥来源
+type SignKeyProviderFunc func(appId string) (*SignKey, error)
+
+func (this SignKeyProviderFunc) GetSignKey(appId string) (*SignKey, error) {
+	return this(appId)
+}
+
+type SignatureParam struct {
+	MaxSkew time.Duration // 时间戳允许的偏差，默认5分钟。nonce 保存 2*MaxSkew
+}
+
+func (this *SignatureStrategyClass) GetName() string {
+	return `signature`
+}
+
+func (this *SignatureStrategyClass) GetDescription() string {
+	return `verify request signature`
+}
+
+func (this *SignatureStrategyClass) SetErrorCode(code uint64) {
+	this.errorCode = code
+}
+
+func (this *SignatureStrategyClass) GetErrorCode() uint64 {
+	return this.errorCode
+}
+
+func (this *SignatureStrategyClass) SetKeyProvider(keyProvider InterfaceSignKeyProvider) {
+	this.keyProvider = keyProvider
+}
+
+// 设置 nonce 的存储，多实例部署时需要使用共享存储
+func (this *SignatureStrategyClass) SetNonceStore(nonceStore InterfaceNonceStore) {
+	this.nonceStore = nonceStore
+}
+
+func (this *SignatureStrategyClass) Validate(param interface{}) error {
+	if this.keyProvider == nil {
+		return fmt.Errorf(`key provider of %s is not set`, this.GetName())
+	}
+	if this.nonceStore == nil {
+		return fmt.Errorf(`nonce store of %s is not set`, this.GetName())
+	}
+	if param == nil {
+		return nil
+	}
+	newParam, ok := param.(SignatureParam)
+	if !ok {
+		return fmt.Errorf(`param of %s must be SignatureParam, got %T`, this.GetName(), param)
+	}
+	if newParam.MaxSkew < 0 {
+		return fmt.Errorf(`param of %s: MaxSkew must not be negative`, this.GetName())
+	}
+	return nil
+}
+
+func (this *SignatureStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	maxSkew := 5 * time.Minute
+	if newParam, ok := param.(SignatureParam); ok && newParam.MaxSkew > 0 {
+		maxSkew = newParam.MaxSkew
+	}
+	appId := out.GetHeader(string(api_session.HeaderName_XAppId))
+	timestampStr := out.GetHeader(string(api_session.HeaderName_XTimestamp))
+	nonce := out.GetHeader(string(api_session.HeaderName_XNonce))
+	sign := out.GetHeader(string(api_session.HeaderName_XSignature))
+	if appId == `` || timestampStr == `` || nonce == `` || sign == `` {
+		go_error.ThrowWithInternalMsg(`signature required`, `app id, timestamp, nonce or signature header is empty`, this.errorCode)
+	}
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		go_error.ThrowWithInternalMsg(`signature timestamp error`, err.Error(), this.errorCode)
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		go_error.ThrowWithInternalMsg(`signature expired`, fmt.Sprintf(`timestamp %d is out of %s`, timestamp, maxSkew), this.errorCode)
+	}
+
+	signKey, err := this.keyProvider.GetSignKey(appId)
+	if err != nil || signKey == nil {
+		go_error.ThrowWithInternalMsg(`signature verify error`, fmt.Sprintf(`app %s: %v`, appId, err), this.errorCode)
+	}
+	canonical := signature.BuildCanonical(out.GetMethod(), out.GetPath(), out.OriginalParams, timestamp, nonce)
+	if !signature.Verify(signKey.Algorithm, signKey.Key, canonical, sign) {
+		go_error.ThrowWithInternalMsg(`signature verify error`, fmt.Sprintf(`app %s: signature mismatch`, appId), this.errorCode)
+	}
+
+	// 签名正确后才记录 nonce，否则伪造的请求可以把 nonce 占掉
+	fresh, err := this.nonceStore.Remember(appId+`_`+nonce, 2*maxSkew)
+	if err != nil {
+		go_error.ThrowInternalError(`nonce store error`, err)
+	}
+	if !fresh {
+		go_error.ThrowWithInternalMsg(`signature replayed`, fmt.Sprintf(`app %s: nonce %s is used`, appId, nonce), this.errorCode)
+	}
+	out.AppId = appId
+	util.UpdateSessionErrorMsg(out, `appId`, appId)
+}
@@ -0,0 +1,83 @@
+package api_strategy_test
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	external_service "github.com/pefish/go-core/driver/external-service"
+	"github.com/pefish/go-core/signature"
+	test_client "github.com/pefish/go-core/test-client"
+)
+
+// 外接服务基类签名的请求可以通过校验；篡改、重放、过期和没有签名的请求被拒绝
+func TestSignatureStrategyClass_Verify(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureStrategy := api_strategy.NewSignatureStrategy()
+	signatureStrategy.SetErrorCode(2003)
+	signatureStrategy.SetKeyProvider(api_strategy.SignKeyProviderFunc(func(appId string) (*api_strategy.SignKey, error) {
+		switch appId {
+		case `hmac-app`:
+			return &api_strategy.SignKey{Algorithm: signature.Algorithm_HmacSha256, Key: []byte(`secret`)}, nil
+		case `ed25519-app`:
+			return &api_strategy.SignKey{Algorithm: signature.Algorithm_Ed25519, Key: publicKey}, nil
+		}
+		return nil, fmt.Errorf(`app %s not found`, appId)
+	}))
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/notify`,
+			Method: api_session.ApiMethod_Post,
+			Params: nameParam{},
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: signatureStrategy,
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return apiSession.AppId
+			},
+		},
+	})
+	params := map[string]interface{}{`name`: `a`, `amount`: 12.5}
+	withHeaders := func(headers map[string]interface{}, sentParams map[string]interface{}) *test_client.RequestClass {
+		request := client.Post(`/notify`).WithJson(sentParams)
+		for key, value := range headers {
+			request.WithHeader(key, value.(string))
+		}
+		return request
+	}
+	request := func(signer *external_service.BaseExternalServiceClass, signedParams map[string]interface{}, sentParams map[string]interface{}) *test_client.RequestClass {
+		return withHeaders(signer.SignHeaders(`POST`, `http://localhost/notify`, signedParams), sentParams)
+	}
+
+	hmacSigner := &external_service.BaseExternalServiceClass{}
+	hmacSigner.SetSigner(`hmac-app`, signature.Algorithm_HmacSha256, []byte(`secret`))
+	appId := ``
+	request(hmacSigner, params, params).Do().AssertCode(0).ScanData(&appId)
+	if appId != `hmac-app` {
+		t.Errorf(`unexpected app id %s`, appId)
+	}
+	ed25519Signer := &external_service.BaseExternalServiceClass{}
+	ed25519Signer.SetSigner(`ed25519-app`, signature.Algorithm_Ed25519, privateKey)
+	request(ed25519Signer, params, params).Do().AssertCode(0)
+
+	// 参数被篡改
+	request(hmacSigner, params, map[string]interface{}{`name`: `a`, `amount`: 13}).Do().AssertCode(2003).AssertMsg(`signature verify error`)
+	// 重放
+	headers := hmacSigner.SignHeaders(`POST`, `http://localhost/notify`, params)
+	withHeaders(headers, params).Do().AssertCode(0)
+	withHeaders(headers, params).Do().AssertCode(2003).AssertMsg(`signature replayed`)
+	// 过期
+	timestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	request(hmacSigner, params, params).WithHeader(`X-Timestamp`, timestamp).Do().AssertCode(2003).AssertMsg(`signature expired`)
+	client.Post(`/notify`).WithJson(params).Do().AssertCode(2003).AssertMsg(`signature required`)
+}
@@ -1,14 +1,20 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	global_api_strategy "github.com/pefish/go-core/driver/global-api-strategy"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/pefish/go-application"
 	api_session "github.com/pefish/go-core/api-session"
 	api_strategy2 "github.com/pefish/go-core/api-strategy"
 	"github.com/pefish/go-core/driver/logger"
+	"github.com/pefish/go-core/sse"
+	"github.com/pefish/go-core/websocket"
+	"github.com/pefish/go-core/upload"
 	"github.com/pefish/go-error"
 	"github.com/pefish/go-stack"
 )
@@ -18,13 +24,27 @@ type Api struct {
 	Path                   string                       // api路径
 	IgnoreRootPath         bool                         // api路径是否忽略根路径
 	IgnoreGlobalStrategies bool                         // 是否跳过全局策略
+	SkipGlobalStrategies   []string                     // 跳过这些名字的全局策略，其他全局策略照常执行
+	GlobalStrategyParams   map[string]interface{}       // 按名字覆盖全局策略在这个路由上的Param
 	Method                 api_session.ApiMethod        // api方法
-	Strategies             []api_strategy2.StrategyData // api前置处理策略,不包含全局策略
+	Strategies             []api_strategy2.StrategyData // api处理策略,不包含全局策略。实现了后置接口的策略也会处理返回结果
 	Params                 interface{}                  // api参数
 	Return                 interface{}                  // api返回值
 	Controller             ApiHandlerType               // api业务处理器
-	ParamType              string                       // 参数类型。默认 application/json，可选 multipart/form-data，空表示都支持
+	TypedController        interface{}                  // 带类型的api业务处理器，与Controller二选一，见 ResolveTypedController
+	SseController          SseHandlerType               // Server-Sent Events 处理器。设置后路由是事件流，策略执行完后打开事件流交给它，不返回统一结构
+	SseHeartbeatInterval   time.Duration                // 事件流心跳间隔，默认15秒，负数表示不发送
+	WebSocketController    WebSocketHandlerType         // WebSocket 处理器。设置后路由是 WebSocket，升级请求先经过策略，然后把连接交给它
+	WebSocketOption        websocket.OptionClass        // WebSocket 连接配置（消息大小上限、保活、Origin 检查）
+	ParamType              string                       // 参数类型。默认 application/json，可选 multipart/form-data、application/x-www-form-urlencoded，空表示都支持
+	Formats                []string                     // 允许的响应和请求体格式（json、xml、yaml、msgpack），按 Accept 协商，空表示所有已注册的格式。第一个是默认格式
+	SkipNegotiation        bool                         // 不按 Accept 协商，使用默认格式。控制器自行写入响应的路由（如 /healthz、404）使用
 	ReturnHookFunc         ReturnHookFuncType           // 返回前的处理函数
+	MaxUploadSize          int64                        // multipart 请求体大小上限，0 表示 32M
+	AllowedFileTypes       []string                     // 允许上传的文件类型（只按内容嗅探），支持 image/* 通配，空表示不限制
+	UploadStorage          upload.InterfaceStorage      // 上传文件的存储后端，nil 使用参数校验策略的存储后端
+
+	typedControllerResolved bool
 }
 
 func (this *Api) GetDescription() string {
@@ -39,17 +59,128 @@ func (this *Api) GetParams() interface{} {
 	return this.Params
 }
 
-type ReturnHookFuncType func(apiContext *api_session.ApiSessionClass, apiResult *ApiResult) (interface{}, *go_error.ErrorInfo)
+func (this *Api) GetFormats() []string {
+	return this.Formats
+}
+
+func (this *Api) GetMaxUploadSize() int64 {
+	return this.MaxUploadSize
+}
+
+func (this *Api) GetAllowedFileTypes() []string {
+	return this.AllowedFileTypes
+}
+
+func (this *Api) GetUploadStorage() upload.InterfaceStorage {
+	return this.UploadStorage
+}
+
+// 路由所在的服务。通过接口访问，避免循环引用
+type InterfaceService interface {
+	GetGlobalApiStrategyDriver() *global_api_strategy.GlobalApiStrategyDriverClass
+	GetLoggerDriver() *logger.LoggerDriverClass
+}
+
+// 路由实际要执行的所有策略（不包含禁用的），全局策略在前，按执行顺序排列
+func (this *Api) GetEffectiveStrategies(globalStrategyDriver *global_api_strategy.GlobalApiStrategyDriverClass) []api_strategy2.StrategyData {
+	strategies := make([]api_strategy2.StrategyData, 0)
+	if !this.IgnoreGlobalStrategies {
+		for _, strategyData := range globalStrategyDriver.GlobalStrategies {
+			if strategyData.Disable || this.isGlobalStrategySkipped(strategyData.Strategy.GetName()) {
+				continue
+			}
+			param := strategyData.Param
+			if overrideParam, ok := this.GlobalStrategyParams[strategyData.Strategy.GetName()]; ok {
+				param = overrideParam
+			}
+			strategies = append(strategies, api_strategy2.StrategyData{
+				Strategy: strategyData.Strategy,
+				Param:    param,
+			})
+		}
+	}
+	for _, strategyData := range this.Strategies {
+		if strategyData.Disable {
+			continue
+		}
+		strategies = append(strategies, strategyData)
+	}
+	return strategies
+}
+
+func (this *Api) isGlobalStrategySkipped(name string) bool {
+	for _, skipName := range this.SkipGlobalStrategies {
+		if skipName == name {
+			return true
+		}
+	}
+	return false
+}
 
-type ApiResult struct {
-	Msg         string      `json:"msg"`
-	InternalMsg string      `json:"internal_msg"`
-	Code        uint64      `json:"code"`
-	Data        interface{} `json:"data"`
+// 路由的处理管道描述，例如 serviceBaseInfo -> paramValidate -> controller -> paramValidate(after)
+func (this *Api) GetPipelineDesc(globalStrategyDriver *global_api_strategy.GlobalApiStrategyDriverClass) string {
+	strategies := this.GetEffectiveStrategies(globalStrategyDriver)
+	steps := make([]string, 0, len(strategies)*2+1)
+	for _, strategyData := range strategies {
+		steps = append(steps, strategyData.Strategy.GetName())
+	}
+	steps = append(steps, `controller`)
+	for i := len(strategies) - 1; i >= 0; i-- {
+		if _, ok := strategies[i].Strategy.(api_strategy2.InterfaceAfterStrategy); ok {
+			steps = append(steps, strategies[i].Strategy.GetName()+`(after)`)
+		}
+	}
+	return strings.Join(steps, ` -> `)
 }
 
+type ReturnHookFuncType func(apiContext *api_session.ApiSessionClass, apiResult *ApiResult) (interface{}, *go_error.ErrorInfo)
+
+type ApiResult = api_session.ApiResult
+
+// 返回值作为 ApiResult 中的 data。返回nil表示控制器已经自行写入了响应（或不需要响应），后置策略和 ReturnHookFunc 不再执行
 type ApiHandlerType func(apiSession *api_session.ApiSessionClass) interface{}
 
+// 事件流处理器，返回后事件流结束。返回错误的话以 error 事件发给客户端
+type SseHandlerType func(apiSession *api_session.ApiSessionClass, stream *sse.StreamClass) error
+
+// WebSocket 处理器，返回后连接关闭。返回错误的话先把错误结果发给客户端再关闭
+type WebSocketHandlerType func(apiSession *api_session.ApiSessionClass, conn *websocket.ConnClass) error
+
+// 是否设置了处理器
+func (this *Api) HasHandler() bool {
+	return this.Controller != nil || this.SseController != nil || this.WebSocketController != nil
+}
+
+// 设置了几个处理器，只能设置一个
+func (this *Api) countHandlers() int {
+	count := 0
+	for _, set := range []bool{this.Controller != nil, this.SseController != nil, this.WebSocketController != nil} {
+		if set {
+			count++
+		}
+	}
+	return count
+}
+
+// 是否是长连接路由（事件流、WebSocket），不返回统一结构
+func (this *Api) IsStream() bool {
+	return this.SseController != nil || this.WebSocketController != nil
+}
+
+// 检查处理器的设置
+func (this *Api) CheckHandler() error {
+	if err := this.ResolveTypedController(); err != nil {
+		return err
+	}
+	if !this.HasHandler() {
+		return errors.New(`controller is not set`)
+	}
+	if this.countHandlers() > 1 {
+		return errors.New(`only one of Controller, SseController and WebSocketController can be set`)
+	}
+	return nil
+}
+
 func DefaultReturnDataFunc(msg string, internalMsg string, code uint64, data interface{}) *ApiResult {
 	if go_application.Application.Debug {
 		return &ApiResult{
@@ -76,20 +207,26 @@ func NewApi() *Api {
 }
 
 /**
-wrap api处理器. 一个path一个，方法内分别处理method
+wrap api处理器. 一个path一个，方法内分别处理method。使用所属服务的全局策略和日志器
 */
-func WrapJson(methodController map[string]*Api) func(response http.ResponseWriter, request *http.Request) {
+func WrapJson(svc InterfaceService, methodController map[string]*Api) func(response http.ResponseWriter, request *http.Request) {
 	return func(response http.ResponseWriter, request *http.Request) {
 		apiSession := api_session.NewApiSession() // 新建会话
-		apiSession.ResponseWriter = response
+		apiSession.Logger = svc.GetLoggerDriver().Logger
+		apiSession.SetResponseWriter(response)
 		apiSession.Request = request
 		apiSession.SetStatusCode(api_session.StatusCode_OK)
 		// 应用层直接允许跨域。推荐接口层做跨域处理
-		apiSession.SetHeader("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
-		apiSession.SetHeader("Access-Control-Allow-Origin", apiSession.GetHeader("Origin"))
-		apiSession.SetHeader("Access-Control-Allow-Methods", apiSession.GetMethod())
-		apiSession.SetHeader("Access-Control-Allow-Headers", "*")
-		apiSession.SetHeader("Access-Control-Allow-Credentials", "true")
+		apiSession.SetHeader(string(api_session.HeaderName_Vary), strings.Join([]string{
+			string(api_session.HeaderName_Origin),
+			string(api_session.HeaderName_AccessControlRequestMethod),
+			string(api_session.HeaderName_AccessControlRequestHeaders),
+			string(api_session.HeaderName_Accept),
+		}, ", "))
+		apiSession.SetHeader(string(api_session.HeaderName_AccessControlAllowOrigin), apiSession.GetHeader(string(api_session.HeaderName_Origin)))
+		apiSession.SetHeader(string(api_session.HeaderName_AccessControlAllowMethods), apiSession.GetMethod())
+		apiSession.SetHeader(string(api_session.HeaderName_AccessControlAllowHeaders), "*")
+		apiSession.SetHeader(string(api_session.HeaderName_AccessControlAllowCredentials), "true")
 		requestMethod := apiSession.GetMethod()
 		if requestMethod == string(api_session.ApiMethod_Option) {
 			apiSession.WriteText(`ok`)
@@ -107,9 +244,36 @@ func WrapJson(methodController map[string]*Api) func(response http.ResponseWrite
 			return
 		}
 
+		var serializer api_session.InterfaceSerializer
+		if currentApi.SkipNegotiation {
+			serializer = api_session.Serializers.GetAllowed(currentApi.Formats)[0]
+		} else {
+			serializer = api_session.Serializers.Negotiate(apiSession.GetHeader(string(api_session.HeaderName_Accept)), currentApi.Formats)
+		}
+		if serializer == nil && currentApi.IsStream() { // 事件流路由的 Accept 是 text/event-stream，打开事件流前的错误使用默认格式
+			serializer = api_session.Serializers.GetAllowed(currentApi.Formats)[0]
+		}
+		if serializer == nil { // 客户端不接受路由支持的任何格式
+			if allowed := api_session.Serializers.GetAllowed(currentApi.Formats); len(allowed) > 0 {
+				apiSession.Serializer = allowed[0]
+			}
+			apiSession.SetStatusCode(api_session.StatusCode_NotAcceptable)
+			apiSession.WriteResult(DefaultReturnDataFunc(`not acceptable`, ``, go_error.INTERNAL_ERROR_CODE, nil))
+			return
+		}
+		apiSession.Serializer = serializer
+
+		strategies := currentApi.GetEffectiveStrategies(svc.GetGlobalApiStrategyDriver())
+		executed := 0 // 前置处理正常结束的策略数，只有这些策略执行后置处理
+
+		defer func() { // 最后执行，这时错误结果也已经写入响应。策略中途出错的话，之前的策略添加的函数也会执行
+			for i := len(apiSession.Defers) - 1; i >= 0; i-- {
+				apiSession.Defers[i]()
+			}
+		}()
 		defer go_error.Recover(func(msg string, internalMsg string, code uint64, data interface{}, err interface{}) {
 			errMsg := fmt.Sprintf("msg: %s\ninternal_msg: %s", msg, internalMsg)
-			logger.LoggerDriver.Logger.Error(
+			apiSession.Logger.Error(
 				"err: " +
 					fmt.Sprint(err) +
 					"\n" +
@@ -118,74 +282,104 @@ func WrapJson(methodController map[string]*Api) func(response http.ResponseWrite
 					apiSession.Datas[`error_msg`].(string) +
 					"\n" +
 					go_stack.Stack.GetStack(go_stack.Option{Skip: 0, Count: 30}))
-			apiResult := DefaultReturnDataFunc(msg, internalMsg, code, data)
-			if currentApi.ReturnHookFunc != nil {
-				hookApiResult, err := currentApi.ReturnHookFunc(apiSession, apiResult)
-				if err != nil {
-					apiSession.WriteJson(DefaultReturnDataFunc(err.ErrorMessage, err.InternalErrorMessage, err.ErrorCode, err.Data))
-					return
-				}
-				if hookApiResult == nil {
-					return
-				}
-				apiSession.WriteJson(hookApiResult)
-			} else {
-				apiSession.WriteJson(apiResult)
+			apiSession.Error = &go_error.ErrorInfo{
+				InternalErrorMessage: internalMsg,
+				ErrorMessage:         msg,
+				ErrorCode:            code,
+				Data:                 data,
+				Err:                  err,
+			}
+			if apiSession.IsResponseStarted() { // 已经发出了部分内容（如下载中途出错），不能再追加错误结果
+				return
 			}
+			writeResult(apiSession, currentApi, strategies[:executed], DefaultReturnDataFunc(msg, internalMsg, code, data))
 		})
 
-		if !currentApi.IgnoreGlobalStrategies {
-			for _, strategyData := range global_api_strategy.GlobalApiStrategyDriver.GlobalStrategies {
-				if strategyData.Disable {
-					continue
-				}
-				func() {
-					defer go_error.Recover(func(msg string, internalMsg string, code uint64, data interface{}, err interface{}) {
-						if code == go_error.INTERNAL_ERROR_CODE {
-							code = strategyData.Strategy.GetErrorCode()
-						}
-						go_error.ThrowErrorWithDataInternalMsg(msg, internalMsg, code, data, err)
-					})
-					strategyData.Strategy.Execute(apiSession, strategyData.Param)
-				}()
+		for _, strategyData := range strategies {
+			executeStrategy(apiSession, strategyData)
+			if apiSession.IsResponseStarted() { // 策略已经写入了响应（如缓存命中），请求结束
+				return
 			}
+			executed++
 		}
 
-		for _, strategyData := range currentApi.Strategies {
-			if strategyData.Disable {
-				continue
-			}
-			func() {
-				defer go_error.Recover(func(msg string, internalMsg string, code uint64, data interface{}, err interface{}) {
-					if code == go_error.INTERNAL_ERROR_CODE {
-						code = strategyData.Strategy.GetErrorCode()
-					}
-					go_error.ThrowErrorWithDataInternalMsg(msg, internalMsg, code, data, err)
-				})
-				strategyData.Strategy.Execute(apiSession, strategyData.Param)
-			}()
+		if currentApi.SseController != nil {
+			serveSse(apiSession, currentApi)
+			return
 		}
-		for _, defer_ := range apiSession.Defers {
-			defer defer_()
+		if currentApi.WebSocketController != nil {
+			serveWebSocket(apiSession, currentApi)
+			return
 		}
 
 		result := currentApi.Controller(apiSession)
-		if result == nil {
+		if result == nil { // 控制器自行写入了响应
 			return
 		}
-		apiResult := DefaultReturnDataFunc(``, ``, 0, result)
-		if currentApi.ReturnHookFunc != nil {
-			hookApiResult, err := currentApi.ReturnHookFunc(apiSession, apiResult)
-			if err != nil {
-				apiSession.WriteJson(DefaultReturnDataFunc(err.ErrorMessage, err.InternalErrorMessage, err.ErrorCode, err.Data))
-				return
-			}
-			if hookApiResult == nil {
-				return
-			}
-			apiSession.WriteJson(hookApiResult)
-		} else {
-			apiSession.WriteJson(apiResult)
+		writeResult(apiSession, currentApi, strategies, DefaultReturnDataFunc(``, ``, 0, result))
+	}
+}
+
+// 执行策略的前置处理。策略抛出的内部错误使用策略自己的错误码
+func executeStrategy(apiSession *api_session.ApiSessionClass, strategyData api_strategy2.StrategyData) {
+	defer go_error.Recover(func(msg string, internalMsg string, code uint64, data interface{}, err interface{}) {
+		if code == go_error.INTERNAL_ERROR_CODE {
+			code = strategyData.Strategy.GetErrorCode()
 		}
+		go_error.ThrowErrorWithDataInternalMsg(msg, internalMsg, code, data, err)
+	})
+	strategyData.Strategy.Execute(apiSession, strategyData.Param)
+}
+
+// 按前置阶段的逆序执行策略的后置处理。返回nil表示响应已被某个策略写入
+func executeAfterStrategies(apiSession *api_session.ApiSessionClass, strategies []api_strategy2.StrategyData, apiResult *ApiResult) *ApiResult {
+	for i := len(strategies) - 1; i >= 0; i-- {
+		strategyData := strategies[i]
+		afterStrategy, ok := strategyData.Strategy.(api_strategy2.InterfaceAfterStrategy)
+		if !ok {
+			continue
+		}
+		func() {
+			defer go_error.Recover(func(msg string, internalMsg string, code uint64, data interface{}, err interface{}) {
+				if code == go_error.INTERNAL_ERROR_CODE {
+					code = strategyData.Strategy.GetErrorCode()
+				}
+				apiSession.Logger.ErrorF(`after strategy %s error: %s; %s; %v`, strategyData.Strategy.GetName(), msg, internalMsg, err)
+				apiSession.Error = &go_error.ErrorInfo{
+					InternalErrorMessage: internalMsg,
+					ErrorMessage:         msg,
+					ErrorCode:            code,
+					Data:                 data,
+					Err:                  err,
+				}
+				apiResult = DefaultReturnDataFunc(msg, internalMsg, code, data) // 后续的后置策略拿到的是这个错误结果
+			})
+			apiResult = afterStrategy.ExecuteAfter(apiSession, strategyData.Param, apiResult)
+		}()
+		if apiResult == nil {
+			return nil
+		}
+	}
+	return apiResult
+}
+
+// 经过后置策略、返回hook后写入响应
+func writeResult(apiSession *api_session.ApiSessionClass, currentApi *Api, strategies []api_strategy2.StrategyData, apiResult *ApiResult) {
+	apiResult = executeAfterStrategies(apiSession, strategies, apiResult)
+	if apiResult == nil {
+		return
+	}
+	if currentApi.ReturnHookFunc != nil {
+		hookApiResult, err := currentApi.ReturnHookFunc(apiSession, apiResult)
+		if err != nil {
+			apiSession.WriteResult(DefaultReturnDataFunc(err.ErrorMessage, err.InternalErrorMessage, err.ErrorCode, err.Data))
+			return
+		}
+		if hookApiResult == nil {
+			return
+		}
+		apiSession.WriteResult(hookApiResult)
+	} else {
+		apiSession.WriteResult(apiResult)
 	}
 }
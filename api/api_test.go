@@ -0,0 +1,338 @@
+package api_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	api_strategy_driver "github.com/pefish/go-core/driver/global-api-strategy"
+	"github.com/pefish/go-core/driver/logger"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+// 记录前置、后置处理的执行顺序，Param 是字符串的话记为 name:param。Param 为 `fail` 时前置处理抛错
+type recordStrategyClass struct {
+	name    string
+	records *[]string
+}
+
+func (this *recordStrategyClass) Init(logger logger.InterfaceLogger, param interface{}) {}
+
+func (this *recordStrategyClass) GetName() string {
+	return this.name
+}
+
+func (this *recordStrategyClass) GetDescription() string {
+	return `record`
+}
+
+func (this *recordStrategyClass) GetErrorCode() uint64 {
+	return 2999
+}
+
+func (this *recordStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	if paramStr, ok := param.(string); ok {
+		*this.records = append(*this.records, this.name+`:`+paramStr)
+	} else {
+		*this.records = append(*this.records, this.name)
+	}
+	if param == `fail` {
+		go_error.Throw(this.name+` fail`, this.GetErrorCode())
+	}
+}
+
+func (this *recordStrategyClass) ExecuteAfter(out *api_session.ApiSessionClass, param interface{}, apiResult *api_session.ApiResult) *api_session.ApiResult {
+	*this.records = append(*this.records, this.name+`(after)`)
+	return apiResult
+}
+
+func TestWrapJson_AfterStrategies(t *testing.T) {
+	records := []string{}
+	a := &recordStrategyClass{name: `a`, records: &records}
+	b := &recordStrategyClass{name: `b`, records: &records}
+	c := &recordStrategyClass{name: `c`, records: &records}
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/ok`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{Strategy: a},
+				{Strategy: b},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				records = append(records, `controller`)
+				return `ok`
+			},
+		},
+		{
+			Path:   `/fail`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{Strategy: a},
+				{Strategy: b, Param: `fail`},
+				{Strategy: c},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				records = append(records, `controller`)
+				return `ok`
+			},
+		},
+		{
+			Path:   `/self-written`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{Strategy: a},
+			},
+			ReturnHookFunc: func(apiContext *api_session.ApiSessionClass, apiResult *api.ApiResult) (interface{}, *go_error.ErrorInfo) {
+				records = append(records, `hook`)
+				return apiResult, nil
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				records = append(records, `controller`)
+				apiSession.WriteText(`raw`)
+				return nil
+			},
+		},
+	})
+
+	client.Get(`/ok`).Do().AssertCode(0)
+	if expect := []string{`a`, `b`, `controller`, `b(after)`, `a(after)`}; !reflect.DeepEqual(records, expect) {
+		t.Errorf(`expect %v, got %v`, expect, records)
+	}
+
+	// 前置处理出错的策略和之后的策略不执行后置处理
+	records = records[:0]
+	client.Get(`/fail`).Do().AssertCode(2999).AssertMsg(`b fail`)
+	if expect := []string{`a`, `b:fail`, `a(after)`}; !reflect.DeepEqual(records, expect) {
+		t.Errorf(`expect %v, got %v`, expect, records)
+	}
+
+	// 控制器返回nil表示自行写入了响应，不执行后置阶段和 ReturnHookFunc
+	records = records[:0]
+	response := client.Get(`/self-written`).Do().AssertStatus(200)
+	if body := response.GetBody(); body != `raw` {
+		t.Errorf(`unexpected body %s`, body)
+	}
+	if expect := []string{`a`, `controller`}; !reflect.DeepEqual(records, expect) {
+		t.Errorf(`expect %v, got %v`, expect, records)
+	}
+}
+
+func TestApi_SkipGlobalStrategies(t *testing.T) {
+	records := []string{}
+	controller := func(apiSession *api_session.ApiSessionClass) interface{} {
+		return `ok`
+	}
+	svc, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:       `/all`,
+			Method:     api_session.ApiMethod_Get,
+			Controller: controller,
+		},
+		{
+			Path:                 `/skip`,
+			Method:               api_session.ApiMethod_Get,
+			SkipGlobalStrategies: []string{`a`},
+			Controller:           controller,
+		},
+	})
+	svc.GetGlobalApiStrategyDriver().Register(api_strategy_driver.GlobalStrategyData{
+		Strategy: &recordStrategyClass{name: `a`, records: &records},
+	})
+	svc.GetGlobalApiStrategyDriver().Register(api_strategy_driver.GlobalStrategyData{
+		Strategy: &recordStrategyClass{name: `b`, records: &records},
+	})
+
+	client.Get(`/all`).Do().AssertCode(0)
+	if expect := []string{`a`, `b`, `b(after)`, `a(after)`}; !reflect.DeepEqual(records, expect) {
+		t.Errorf(`expect %v, got %v`, expect, records)
+	}
+
+	// 只跳过 a，b 照常执行
+	records = records[:0]
+	client.Get(`/skip`).Do().AssertCode(0)
+	if expect := []string{`b`, `b(after)`}; !reflect.DeepEqual(records, expect) {
+		t.Errorf(`expect %v, got %v`, expect, records)
+	}
+}
+
+func TestApi_GlobalStrategyParams(t *testing.T) {
+	records := []string{}
+	controller := func(apiSession *api_session.ApiSessionClass) interface{} {
+		return `ok`
+	}
+	svc, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:       `/default`,
+			Method:     api_session.ApiMethod_Get,
+			Controller: controller,
+		},
+		{
+			Path:   `/override`,
+			Method: api_session.ApiMethod_Get,
+			GlobalStrategyParams: map[string]interface{}{
+				`a`: `route`,
+			},
+			Controller: controller,
+		},
+	})
+	svc.GetGlobalApiStrategyDriver().Register(api_strategy_driver.GlobalStrategyData{
+		Strategy: &recordStrategyClass{name: `a`, records: &records},
+		Param:    `global`,
+	})
+	svc.GetGlobalApiStrategyDriver().Register(api_strategy_driver.GlobalStrategyData{
+		Strategy: &recordStrategyClass{name: `b`, records: &records},
+		Param:    `global`,
+	})
+
+	client.Get(`/default`).Do().AssertCode(0)
+	if expect := []string{`a:global`, `b:global`, `b(after)`, `a(after)`}; !reflect.DeepEqual(records, expect) {
+		t.Errorf(`expect %v, got %v`, expect, records)
+	}
+
+	// 只覆盖 a 的参数，全局注册的参数不受影响
+	records = records[:0]
+	client.Get(`/override`).Do().AssertCode(0)
+	if expect := []string{`a:route`, `b:global`, `b(after)`, `a(after)`}; !reflect.DeepEqual(records, expect) {
+		t.Errorf(`expect %v, got %v`, expect, records)
+	}
+	records = records[:0]
+	client.Get(`/default`).Do().AssertCode(0)
+	if expect := []string{`a:global`, `b:global`, `b(after)`, `a(after)`}; !reflect.DeepEqual(records, expect) {
+		t.Errorf(`expect %v, got %v`, expect, records)
+	}
+}
+
+// 自行写入响应的路由不做格式协商，浏览器的 Accept 得到默认格式
+func TestWrapJson_Negotiation(t *testing.T) {
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/hello`,
+			Method: api_session.ApiMethod_Get,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return `hello`
+			},
+		},
+	})
+
+	if response := client.Get(`/healthz`).WithHeader(`Accept`, `text/plain`).Do().AssertStatus(200); response.GetBody() != `ok` {
+		t.Errorf(`unexpected healthz body %s`, response.GetBody())
+	}
+	client.Get(`/not-found`).WithHeader(`Accept`, `image/png`).Do().AssertStatus(404)
+
+	response := client.Get(`/hello`).WithHeader(`Accept`, `text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8`).Do().AssertStatus(200).AssertCode(0)
+	if contentType := response.GetHeader(`Content-Type`); !strings.HasPrefix(contentType, `application/json`) {
+		t.Errorf(`expect json, got %s`, contentType)
+	}
+	response = client.Get(`/hello`).WithHeader(`Accept`, `application/xml`).Do().AssertStatus(200)
+	if contentType := response.GetHeader(`Content-Type`); !strings.HasPrefix(contentType, `application/xml`) {
+		t.Errorf(`expect xml, got %s`, contentType)
+	}
+	client.Get(`/hello`).WithHeader(`Accept`, `application/json;q=0, application/xml;q=0, application/x-yaml;q=0, text/yaml;q=0, application/msgpack;q=0, application/x-msgpack;q=0`).Do().AssertStatus(406)
+}
+
+type helloParam struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// 按 Content-Type 解析请求体，按 Accept 选择返回格式，路由限制了格式的话不可接受时返回 406
+func TestWrapJson_Formats(t *testing.T) {
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/hello`,
+			Method: api_session.ApiMethod_Post,
+			Params: helloParam{},
+			TypedController: func(apiSession *api_session.ApiSessionClass, params helloParam) (*helloParam, error) {
+				return &params, nil
+			},
+		},
+		{
+			Path:    `/json-only`,
+			Method:  api_session.ApiMethod_Get,
+			Formats: []string{`json`},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return `ok`
+			},
+		},
+	})
+
+	response := client.Post(`/hello`).
+		WithHeader(`Content-Type`, `application/xml`).
+		WithHeader(`Accept`, `application/x-yaml;q=0.9, application/msgpack`).
+		WithBody([]byte(`<request><name>xml</name></request>`)).
+		Do().AssertStatus(200)
+	if response.GetHeader(`Content-Type`) != `application/msgpack` {
+		t.Errorf(`unexpected content type %s`, response.GetHeader(`Content-Type`))
+	}
+	result := map[string]interface{}{}
+	if err := api_session.MsgpackSerializer.Unmarshal(response.Recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := result[`data`].(map[string]interface{}); !ok || data[`name`] != `xml` {
+		t.Errorf(`unexpected result %#v`, result)
+	}
+
+	client.Get(`/json-only`).WithHeader(`Accept`, `application/xml`).Do().AssertStatus(406)
+	client.Get(`/json-only`).WithHeader(`Accept`, `application/xml, */*;q=0.1`).Do().AssertStatus(200).AssertCode(0)
+}
+
+// 控制器自行写入文件（支持 Range、ETag）后返回nil；开始写入后出错的话不再追加错误结果
+func TestWrapJson_Download(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `go-core-download`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, `report.txt`)
+	if err := ioutil.WriteFile(path, []byte(`0123456789`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/download`,
+			Method: api_session.ApiMethod_Get,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				if err := apiSession.WriteFile(path, `报表.txt`); err != nil {
+					go_error.ThrowInternal(err.Error())
+				}
+				return nil
+			},
+		},
+		{
+			Path:   `/export`,
+			Method: api_session.ApiMethod_Get,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				writer := apiSession.StartChunked(`text/csv`)
+				writer.WriteString("id,name\n")
+				go_error.ThrowInternal(`export failed`)
+				return nil
+			},
+		},
+	})
+
+	response := client.Get(`/download`).Do().AssertStatus(200)
+	if response.GetBody() != `0123456789` || !strings.Contains(response.GetHeader(`Content-Disposition`), `filename*=utf-8''%E6%8A%A5%E8%A1%A8.txt`) {
+		t.Fatalf(`unexpected download %s, headers %v`, response.GetBody(), response.Recorder.Header())
+	}
+	etag := response.GetHeader(`ETag`)
+
+	response = client.Get(`/download`).WithHeader(`Range`, `bytes=2-4`).Do().AssertStatus(206)
+	if response.GetBody() != `234` {
+		t.Errorf(`unexpected range body %s`, response.GetBody())
+	}
+	client.Get(`/download`).WithHeader(`If-None-Match`, etag).Do().AssertStatus(304)
+
+	// 开始写入后出错，不能再追加错误结果
+	response = client.Get(`/export`).Do().AssertStatus(200)
+	if response.GetBody() != "id,name\n" {
+		t.Errorf(`unexpected export body %q`, response.GetBody())
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"time"
+
+	api_session "github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/sse"
+	go_error "github.com/pefish/go-error"
+)
+
+const defaultSseHeartbeatInterval = 15 * time.Second
+
+// 打开事件流并交给处理器。事件流打开后出错的话不能再返回统一结构，改为发送 error 事件
+func serveSse(apiSession *api_session.ApiSessionClass, currentApi *Api) {
+	stream, err := sse.NewStream(apiSession.ResponseWriter, apiSession.Request)
+	if err != nil {
+		go_error.ThrowInternalErrorWithInternalMsg(go_error.INTERNAL_ERROR, err.Error(), err)
+	}
+	defer stream.Close()
+	stream.Open()
+
+	interval := currentApi.SseHeartbeatInterval
+	if interval == 0 {
+		interval = defaultSseHeartbeatInterval
+	}
+	stream.StartHeartbeat(interval)
+
+	defer go_error.Recover(func(msg string, internalMsg string, code uint64, data interface{}, err interface{}) {
+		apiSession.Logger.ErrorF(`event stream error: %s; %s; %v`, msg, internalMsg, err)
+		apiSession.Error = &go_error.ErrorInfo{
+			InternalErrorMessage: internalMsg,
+			ErrorMessage:         msg,
+			ErrorCode:            code,
+			Data:                 data,
+			Err:                  err,
+		}
+		stream.Send(sse.EventClass{
+			Event: `error`,
+			Data:  DefaultReturnDataFunc(msg, internalMsg, code, data),
+		})
+	})
+	if err := currentApi.SseController(apiSession, stream); err != nil && err != sse.ErrClosed {
+		throwControllerError(err)
+	}
+}
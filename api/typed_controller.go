@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	api_session "github.com/pefish/go-core/api-session"
+	go_error "github.com/pefish/go-error"
+)
+
+var (
+	apiSessionType = reflect.TypeOf(&api_session.ApiSessionClass{})
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+	apiResultType  = reflect.TypeOf(ApiResult{})
+)
+
+/**
+解析 TypedController。检查函数签名，生成 Controller，并在没有设置 Params/Return 时根据签名推断。
+签名支持：
+	func(apiSession *api_session.ApiSessionClass, params P) R
+	func(apiSession *api_session.ApiSessionClass, params P) (R, error)
+P 是结构体或结构体指针，框架把校验过的参数绑定进去。R 是结构体或切片的话推断出 Return。
+服务 SetRoutes/AddRoute 时会调用，签名与 Params/Return 不一致的话返回错误
+*/
+func (this *Api) ResolveTypedController() error {
+	if this.TypedController == nil || this.typedControllerResolved {
+		return nil
+	}
+	if this.Controller != nil {
+		return errors.New(`Controller and TypedController can not be set at the same time`)
+	}
+	funcValue := reflect.ValueOf(this.TypedController)
+	funcType := funcValue.Type()
+	if funcType.Kind() != reflect.Func {
+		return fmt.Errorf(`TypedController must be a func, got %s`, funcType)
+	}
+	if funcType.NumIn() != 2 || funcType.In(0) != apiSessionType {
+		return fmt.Errorf(`TypedController must accept (*api_session.ApiSessionClass, params), got %s`, funcType)
+	}
+	paramsType := funcType.In(1)
+	paramsIsPtr := paramsType.Kind() == reflect.Ptr
+	paramsStructType := paramsType
+	if paramsIsPtr {
+		paramsStructType = paramsType.Elem()
+	}
+	if paramsStructType.Kind() != reflect.Struct {
+		return fmt.Errorf(`params of TypedController must be a struct or a pointer to struct, got %s`, paramsType)
+	}
+	if funcType.NumOut() == 0 || funcType.NumOut() > 2 || (funcType.NumOut() == 2 && funcType.Out(1) != errorType) {
+		return fmt.Errorf(`TypedController must return R or (R, error), got %s`, funcType)
+	}
+	returnType := funcType.Out(0)
+
+	if this.Params != nil {
+		if declared := indirectType(reflect.TypeOf(this.Params)); declared != paramsStructType {
+			return fmt.Errorf(`Params is %s but TypedController accepts %s`, declared, paramsStructType)
+		}
+	} else {
+		this.Params = reflect.New(paramsStructType).Elem().Interface()
+	}
+	if this.Return != nil {
+		declared := reflect.TypeOf(this.Return)
+		if declared == apiResultType { // 按惯例 Return 是包了一层的 ApiResult，比较的是 Data 的类型
+			data := this.Return.(ApiResult).Data
+			if data == nil {
+				declared = nil
+			} else {
+				declared = reflect.TypeOf(data)
+			}
+		}
+		if declared != nil && indirectType(declared) != indirectType(returnType) && returnType.Kind() != reflect.Interface {
+			return fmt.Errorf(`Return is %s but TypedController returns %s`, declared, returnType)
+		}
+	} else if data, ok := returnExample(returnType); ok {
+		this.Return = ApiResult{
+			Data: data,
+		}
+	}
+
+	this.Controller = func(apiSession *api_session.ApiSessionClass) interface{} {
+		params := reflect.New(paramsStructType)
+		apiSession.ScanParams(params.Interface())
+		if !paramsIsPtr {
+			params = params.Elem()
+		}
+		results := funcValue.Call([]reflect.Value{reflect.ValueOf(apiSession), params})
+		if len(results) == 2 && !results[1].IsNil() {
+			throwControllerError(results[1].Interface().(error))
+		}
+		return results[0].Interface() // R 是指针时，nil 指针也会正常返回 null；R 是 interface{} 且返回nil表示控制器已自行写入响应
+	}
+	this.typedControllerResolved = true
+	return nil
+}
+
+// 控制器返回的错误按框架的错误抛出，*go_error.ErrorInfo 原样抛出
+func throwControllerError(err error) {
+	if errorInfo, ok := err.(*go_error.ErrorInfo); ok {
+		panic(errorInfo)
+	}
+	go_error.ThrowInternalErrorWithInternalMsg(go_error.INTERNAL_ERROR, err.Error(), err)
+}
+
+// 根据返回值类型生成 Return 中的 data 示例。结构体是零值，切片带一个零值元素，其他类型不推断
+func returnExample(returnType reflect.Type) (interface{}, bool) {
+	type_ := indirectType(returnType)
+	switch type_.Kind() {
+	case reflect.Struct:
+		return reflect.New(type_).Elem().Interface(), true
+	case reflect.Slice:
+		slice := reflect.MakeSlice(type_, 1, 1)
+		if type_.Elem().Kind() == reflect.Ptr {
+			slice.Index(0).Set(reflect.New(type_.Elem().Elem()))
+		}
+		return slice.Interface(), true
+	}
+	return nil, false
+}
+
+func indirectType(type_ reflect.Type) reflect.Type {
+	if type_.Kind() == reflect.Ptr {
+		return type_.Elem()
+	}
+	return type_
+}
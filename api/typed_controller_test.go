@@ -0,0 +1,117 @@
+package api_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	go_core "github.com/pefish/go-core"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+)
+
+type typedParams struct {
+	Name string `json:"name"`
+}
+
+type typedItem struct {
+	Id uint64 `json:"id"`
+}
+
+func TestApi_ResolveTypedController(t *testing.T) {
+	cases := []struct {
+		route  *api.Api
+		errMsg string
+	}{
+		{
+			route: &api.Api{
+				TypedController: `not a func`,
+			},
+			errMsg: `TypedController must be a func, got string`,
+		},
+		{
+			route: &api.Api{
+				TypedController: func(params typedParams) typedItem { return typedItem{} },
+			},
+			errMsg: `TypedController must accept (*api_session.ApiSessionClass, params)`,
+		},
+		{
+			route: &api.Api{
+				TypedController: func(apiSession *api_session.ApiSessionClass, params string) typedItem { return typedItem{} },
+			},
+			errMsg: `params of TypedController must be a struct or a pointer to struct, got string`,
+		},
+		{
+			route: &api.Api{
+				TypedController: func(apiSession *api_session.ApiSessionClass, params typedParams) (typedItem, string) {
+					return typedItem{}, ``
+				},
+			},
+			errMsg: `TypedController must return R or (R, error)`,
+		},
+		{
+			route: &api.Api{
+				Params:          typedItem{},
+				TypedController: func(apiSession *api_session.ApiSessionClass, params typedParams) typedItem { return typedItem{} },
+			},
+			errMsg: `Params is api_test.typedItem but TypedController accepts api_test.typedParams`,
+		},
+		{
+			route: &api.Api{
+				Return:          api.ApiResult{Data: typedParams{}},
+				TypedController: func(apiSession *api_session.ApiSessionClass, params typedParams) []typedItem { return nil },
+			},
+			errMsg: `Return is api_test.typedParams but TypedController returns []api_test.typedItem`,
+		},
+		{
+			route: &api.Api{
+				Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+					return nil
+				},
+				TypedController: func(apiSession *api_session.ApiSessionClass, params typedParams) typedItem { return typedItem{} },
+			},
+			errMsg: `Controller and TypedController can not be set at the same time`,
+		},
+	}
+	for i, c := range cases {
+		err := c.route.ResolveTypedController()
+		if err == nil || !strings.Contains(err.Error(), c.errMsg) {
+			t.Errorf(`case %d: expect error %q, got %v`, i, c.errMsg, err)
+		}
+	}
+}
+
+// 返回切片的话也推断出 Return
+func TestApi_ResolveTypedController_InferReturn(t *testing.T) {
+	route := &api.Api{
+		TypedController: func(apiSession *api_session.ApiSessionClass, params *typedParams) ([]*typedItem, error) {
+			return nil, nil
+		},
+	}
+	if err := route.ResolveTypedController(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(route.Params, typedParams{}) {
+		t.Errorf(`unexpected Params %#v`, route.Params)
+	}
+	if !reflect.DeepEqual(route.Return, api.ApiResult{Data: []*typedItem{{}}}) {
+		t.Errorf(`unexpected Return %#v`, route.Return)
+	}
+}
+
+// 注册路由时就检查带类型的处理器
+func TestApi_TypedControllerCheckedOnRegister(t *testing.T) {
+	svc := go_core.NewStandaloneService(`test`)
+	defer func() {
+		err := recover()
+		if err == nil || !strings.Contains(fmt.Sprint(err), `route POST /typed: TypedController must be a func`) {
+			t.Errorf(`unexpected panic %v`, err)
+		}
+	}()
+	svc.AddRoute(&api.Api{
+		Path:            `/typed`,
+		Method:          api_session.ApiMethod_Post,
+		TypedController: `not a func`,
+	})
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	api_session "github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/websocket"
+	go_error "github.com/pefish/go-error"
+)
+
+// 升级成 WebSocket 并交给处理器。升级后出错的话把错误结果作为消息发送，然后以 1011 关闭
+func serveWebSocket(apiSession *api_session.ApiSessionClass, currentApi *Api) {
+	conn, err := websocket.Upgrade(apiSession.ResponseWriter, apiSession.Request, currentApi.WebSocketOption)
+	if err != nil { // 已经向客户端返回了错误响应
+		apiSession.Logger.WarnF(`websocket upgrade error: %s`, err)
+		return
+	}
+	closeCode, closeReason := websocket.CloseNormalClosure, ``
+	defer func() {
+		conn.Close(closeCode, closeReason)
+	}()
+
+	defer go_error.Recover(func(msg string, internalMsg string, code uint64, data interface{}, err interface{}) {
+		apiSession.Logger.ErrorF(`websocket error: %s; %s; %v`, msg, internalMsg, err)
+		apiSession.Error = &go_error.ErrorInfo{
+			InternalErrorMessage: internalMsg,
+			ErrorMessage:         msg,
+			ErrorCode:            code,
+			Data:                 data,
+			Err:                  err,
+		}
+		conn.WriteJson(DefaultReturnDataFunc(msg, internalMsg, code, data))
+		closeCode, closeReason = websocket.CloseInternalServerErr, msg
+		if len(closeReason) > 120 { // 关闭帧的原因不能超过123字节
+			closeReason = closeReason[:120]
+		}
+	})
+	if err := currentApi.WebSocketController(apiSession, conn); err != nil && !websocket.IsNormalClose(err) {
+		throwControllerError(err)
+	}
+}
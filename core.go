@@ -1,23 +1,45 @@
 package go_core
 
 import (
+	external_service "github.com/pefish/go-core/driver/external-service"
 	api_strategy2 "github.com/pefish/go-core/driver/global-api-strategy"
+	"github.com/pefish/go-core/driver/logger"
 	"github.com/pefish/go-core/global-api-strategy"
 	"github.com/pefish/go-core/service"
 )
 
-// New Service instance
+// New Service instance.
+// 与 go_core.Service 一样使用包级别的驱动，默认全局策略只注册一次。需要独立配置的服务见 NewStandaloneService
 func NewService(name string) *service.ServiceClass {
 	svc := &service.ServiceClass{}
 	svc.SetName(name)
-	api_strategy2.GlobalApiStrategyDriver.Register(api_strategy2.GlobalStrategyData{
-		Strategy: &global_api_strategy.ServiceBaseInfoApiStrategy,
-	})
-	api_strategy2.GlobalApiStrategyDriver.Register(api_strategy2.GlobalStrategyData{
-		Strategy: &global_api_strategy.ParamValidateStrategy,
-	})
+	registerDefaultStrategy(&api_strategy2.GlobalApiStrategyDriver, &global_api_strategy.ServiceBaseInfoApiStrategy)
+	registerDefaultStrategy(&api_strategy2.GlobalApiStrategyDriver, &global_api_strategy.ParamValidateStrategy)
+	return svc
+}
+
+// 新建独立的服务。服务持有自己的全局策略、日志、外接服务驱动和默认全局策略实例，
+// 多个服务可以在同一个进程里使用不同的配置。注册到包级别驱动上的内容对它不生效
+func NewStandaloneService(name string) *service.ServiceClass {
+	svc := &service.ServiceClass{}
+	svc.SetName(name)
+	svc.SetGlobalApiStrategyDriver(api_strategy2.NewGlobalApiStrategyDriver())
+	svc.SetLoggerDriver(logger.NewLoggerDriver())
+	svc.SetExternalServiceDriver(external_service.NewExternalServiceDriver())
+	registerDefaultStrategy(svc.GetGlobalApiStrategyDriver(), global_api_strategy.NewServiceBaseInfoStrategy())
+	registerDefaultStrategy(svc.GetGlobalApiStrategyDriver(), global_api_strategy.NewParamValidateStrategy())
 	return svc
 }
 
+// 同名策略还没有注册的话注册
+func registerDefaultStrategy(driver *api_strategy2.GlobalApiStrategyDriverClass, strategy api_strategy2.InterfaceGlobalStrategy) {
+	if _, ok := driver.Get(strategy.GetName()); ok {
+		return
+	}
+	driver.Register(api_strategy2.GlobalStrategyData{
+		Strategy: strategy,
+	})
+}
+
 // Default Service instance
 var Service = NewService(`default`)
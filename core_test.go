@@ -0,0 +1,54 @@
+package go_core_test
+
+import (
+	"testing"
+
+	go_core "github.com/pefish/go-core"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	external_service "github.com/pefish/go-core/driver/external-service"
+	api_strategy_driver "github.com/pefish/go-core/driver/global-api-strategy"
+	"github.com/pefish/go-core/driver/logger"
+	test_client "github.com/pefish/go-core/test-client"
+)
+
+// 只注册了服务自己的日志器时，全局策略的初始化和执行都使用服务的日志器
+func TestNewService_OwnLogger(t *testing.T) {
+	if logger.LoggerDriver.Logger != nil {
+		t.Fatal(`default logger driver should not be set in this test`)
+	}
+	svc := go_core.NewStandaloneService(`test`)
+	fakeLogger := test_client.NewFakeLogger()
+	svc.GetLoggerDriver().Register(fakeLogger)
+	svc.SetRoutes([]*api.Api{
+		{
+			Path:   `/hello`,
+			Method: api_session.ApiMethod_Get,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return `hello`
+			},
+		},
+	})
+	test_client.NewTestClient(t, svc).Get(`/hello`).Do().AssertCode(0)
+	if len(fakeLogger.GetEntries(`debug`)) == 0 {
+		t.Error(`strategies should log with the service logger`)
+	}
+	if logger.LoggerDriver.Logger != nil {
+		t.Error(`default logger driver should not be changed`)
+	}
+}
+
+// NewService 使用包级别的驱动，之后注册到包级别驱动上的策略同样生效，默认策略不重复注册
+func TestNewService_PackageDrivers(t *testing.T) {
+	svc := go_core.NewService(`test`)
+	go_core.NewService(`test2`)
+	if svc.GetGlobalApiStrategyDriver() != &api_strategy_driver.GlobalApiStrategyDriver {
+		t.Fatal(`service should use the package-level global strategy driver`)
+	}
+	if len(api_strategy_driver.GlobalApiStrategyDriver.GlobalStrategies) != 2 {
+		t.Errorf(`default strategies should be registered once, got %d`, len(api_strategy_driver.GlobalApiStrategyDriver.GlobalStrategies))
+	}
+	if svc.GetLoggerDriver() != &logger.LoggerDriver || svc.GetExternalServiceDriver() != &external_service.ExternalServiceDriver {
+		t.Error(`service should use the package-level drivers`)
+	}
+}
@@ -0,0 +1,124 @@
+package external_service
+
+import (
+	"context"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 负载均衡，从可用的节点中选择一个。peers 不为空
+type InterfaceBalancer interface {
+	Pick(peers []*PeerClass, key string) *PeerClass
+}
+
+type hashKeyType struct{}
+
+// 设置一致性哈希的 key（如用户id），同一个 key 的调用会落到同一个节点
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKeyType{}, key)
+}
+
+func getHashKey(ctx context.Context) string {
+	key, _ := ctx.Value(hashKeyType{}).(string)
+	return key
+}
+
+// 轮询
+type RoundRobinBalancerClass struct {
+	counter uint64
+}
+
+func NewRoundRobinBalancer() *RoundRobinBalancerClass {
+	return &RoundRobinBalancerClass{}
+}
+
+func (this *RoundRobinBalancerClass) Pick(peers []*PeerClass, key string) *PeerClass {
+	return peers[(atomic.AddUint64(&this.counter, 1)-1)%uint64(len(peers))]
+}
+
+// 选择正在处理的请求最少的节点，一样多的话轮流选择
+type LeastInflightBalancerClass struct {
+	counter uint64
+}
+
+func NewLeastInflightBalancer() *LeastInflightBalancerClass {
+	return &LeastInflightBalancerClass{}
+}
+
+func (this *LeastInflightBalancerClass) Pick(peers []*PeerClass, key string) *PeerClass {
+	start := int((atomic.AddUint64(&this.counter, 1) - 1) % uint64(len(peers)))
+	var result *PeerClass
+	for i := 0; i < len(peers); i++ {
+		peer := peers[(start+i)%len(peers)]
+		if result == nil || peer.GetInflight() < result.GetInflight() {
+			result = peer
+		}
+	}
+	return result
+}
+
+// 一致性哈希，key 通过 WithHashKey 设置。节点变化时只有少部分 key 换节点。没有 key 的话轮询
+type ConsistentHashBalancerClass struct {
+	sync.Mutex
+	replicas   int // 每个节点的虚拟节点数
+	ringKey    string
+	hashes     []uint32
+	hashToUrl  map[uint32]string
+	roundRobin RoundRobinBalancerClass
+}
+
+// replicas 是每个节点在哈希环上的虚拟节点数，小于等于0的话默认100
+func NewConsistentHashBalancer(replicas int) *ConsistentHashBalancerClass {
+	if replicas <= 0 {
+		replicas = 100
+	}
+	return &ConsistentHashBalancerClass{
+		replicas: replicas,
+	}
+}
+
+func (this *ConsistentHashBalancerClass) Pick(peers []*PeerClass, key string) *PeerClass {
+	if key == `` {
+		return this.roundRobin.Pick(peers, key)
+	}
+	peerByUrl := make(map[string]*PeerClass, len(peers))
+	urls := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		peerByUrl[peer.GetUrl()] = peer
+		urls = append(urls, peer.GetUrl())
+	}
+	sort.Strings(urls)
+
+	this.Lock()
+	defer this.Unlock()
+	if ringKey := strings.Join(urls, ` `); ringKey != this.ringKey { // 节点变化后重建哈希环
+		this.ringKey = ringKey
+		this.hashes = make([]uint32, 0, len(urls)*this.replicas)
+		this.hashToUrl = make(map[uint32]string, len(urls)*this.replicas)
+		for _, url := range urls {
+			for i := 0; i < this.replicas; i++ {
+				hash := crc32.ChecksumIEEE([]byte(url + `#` + strconv.Itoa(i)))
+				if _, ok := this.hashToUrl[hash]; ok {
+					continue
+				}
+				this.hashes = append(this.hashes, hash)
+				this.hashToUrl[hash] = url
+			}
+		}
+		sort.Slice(this.hashes, func(i, j int) bool {
+			return this.hashes[i] < this.hashes[j]
+		})
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	index := sort.Search(len(this.hashes), func(i int) bool {
+		return this.hashes[i] >= hash
+	})
+	if index == len(this.hashes) {
+		index = 0
+	}
+	return peerByUrl[this.hashToUrl[this.hashes[index]]]
+}
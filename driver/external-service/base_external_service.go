@@ -1,16 +1,50 @@
 package external_service
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/pefish/go-core/api"
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/signature"
 	"github.com/pefish/go-error"
-	"github.com/pefish/go-http"
+	"github.com/pefish/go-reflect"
+	"go.opencensus.io/plugin/ochttp/propagation/b3"
+	"go.opencensus.io/trace"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 
 // 让外部服务可以通过这个基类调用内部功能
 type BaseExternalServiceClass struct {
+	signAppId     string
+	signAlgorithm signature.Algorithm
+	signKey       []byte
+	headers       map[string]string // 每个请求都带上的请求头（如 api key）
 
+	configLock sync.Mutex
+	name       string // 注册时的名字
+	policy     Policy
+	breaker    *CircuitBreakerClass
+	client     *http.Client
+	transport  http.RoundTripper // 为nil的话使用按调用策略创建的 http.Transport
+	baseUrl    string
+	discovery  *DiscoveryClass // 设置的话，以 / 开头的请求地址拼接在选择的节点地址后面
+	endpoints  map[string]Endpoint
+}
+
+// 新建外部服务。只通过 ExternalServiceDriverClass.Call 调用声明的接口的话，不需要定义自己的服务结构体
+func NewBaseExternalService() *BaseExternalServiceClass {
+	return &BaseExternalServiceClass{}
 }
 
 
@@ -18,6 +52,192 @@ func (this *BaseExternalServiceClass) Init(driver *ExternalServiceDriverClass) {
 
 }
 
+// 注册到驱动时设置服务名和调用策略
+func (this *BaseExternalServiceClass) configure(name string, policy Policy) {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	this.configureLocked(name, policy)
+}
+
+// 调用前需要加锁
+func (this *BaseExternalServiceClass) configureLocked(name string, policy Policy) {
+	this.name = name
+	this.policy = policy.withDefaults()
+	this.breaker = NewCircuitBreaker(this.policy.Breaker)
+	this.breaker.SetOnStateChange(func(from BreakerState, to BreakerState) {
+		recordBreakerState(name, to)
+	})
+	this.client = this.buildClient()
+}
+
+// 按调用策略创建 http 客户端，调用前需要加锁
+func (this *BaseExternalServiceClass) buildClient() *http.Client {
+	transport := this.transport
+	if transport == nil {
+		dialer := &net.Dialer{
+			Timeout:   this.policy.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}
+		transport = &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   this.policy.ConnectTimeout,
+			ResponseHeaderTimeout: this.policy.ReadTimeout,
+			MaxIdleConnsPerHost:   16,
+			IdleConnTimeout:       90 * time.Second,
+		}
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   this.policy.ConnectTimeout + this.policy.ReadTimeout,
+	}
+}
+
+// 替换发送请求的 transport（录制、回放、假服务等），超时、重试和熔断照常生效。传nil 恢复默认
+func (this *BaseExternalServiceClass) SetTransport(transport http.RoundTripper) {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	this.transport = transport
+	if this.client != nil {
+		this.client = this.buildClient()
+	}
+}
+
+// 没有注册到驱动就直接使用的话，使用默认策略。判断和配置在同一个锁内，并发调用只配置一次
+func (this *BaseExternalServiceClass) ensureConfigured() {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	if this.client == nil {
+		this.configureLocked(this.name, DefaultPolicy)
+	}
+}
+
+func (this *BaseExternalServiceClass) getBase() *BaseExternalServiceClass {
+	return this
+}
+
+// 注册时的名字
+func (this *BaseExternalServiceClass) GetName() string {
+	return this.name
+}
+
+func (this *BaseExternalServiceClass) SetBaseUrl(baseUrl string) {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	this.baseUrl = strings.TrimRight(baseUrl, `/`)
+}
+
+func (this *BaseExternalServiceClass) GetBaseUrl() string {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	return this.baseUrl
+}
+
+// 设置服务发现，替换之前的服务发现（之前的会被关闭）。传nil 恢复使用 baseUrl
+func (this *BaseExternalServiceClass) SetDiscovery(discovery *DiscoveryClass) {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	if this.discovery != nil && this.discovery != discovery {
+		this.discovery.Close()
+	}
+	if discovery != nil {
+		discovery.getClient = func() *http.Client {
+			this.ensureConfigured()
+			this.configLock.Lock()
+			defer this.configLock.Unlock()
+			return this.client
+		}
+	}
+	this.discovery = discovery
+}
+
+func (this *BaseExternalServiceClass) GetDiscovery() *DiscoveryClass {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	return this.discovery
+}
+
+// 声明接口，同名的接口会被覆盖
+func (this *BaseExternalServiceClass) AddEndpoints(endpoints ...Endpoint) {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	if this.endpoints == nil {
+		this.endpoints = map[string]Endpoint{}
+	}
+	for _, endpoint := range endpoints {
+		this.endpoints[endpoint.Name] = endpoint
+	}
+}
+
+func (this *BaseExternalServiceClass) GetEndpoint(name string) (Endpoint, bool) {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	endpoint, ok := this.endpoints[name]
+	return endpoint, ok
+}
+
+// 按名字排序的所有接口
+func (this *BaseExternalServiceClass) GetEndpoints() []Endpoint {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	return sortEndpoints(this.endpoints)
+}
+
+// 设置每个请求都带上的请求头，用于 api key 等鉴权方式
+func (this *BaseExternalServiceClass) SetHeader(key string, value string) {
+	this.configLock.Lock()
+	defer this.configLock.Unlock()
+	if this.headers == nil {
+		this.headers = map[string]string{}
+	}
+	this.headers[key] = value
+}
+
+func (this *BaseExternalServiceClass) GetPolicy() Policy {
+	this.ensureConfigured()
+	return this.policy
+}
+
+// 熔断器的当前状态
+func (this *BaseExternalServiceClass) GetBreakerState() BreakerState {
+	this.ensureConfigured()
+	return this.breaker.GetState()
+}
+
+// 设置签名的 app id 和密钥（Ed25519 是私钥），之后的请求都带上签名请求头，对方服务使用签名策略验证
+func (this *BaseExternalServiceClass) SetSigner(appId string, algorithm signature.Algorithm, key []byte) {
+	this.signAppId = appId
+	this.signAlgorithm = algorithm
+	this.signKey = key
+}
+
+// 签名请求头，没有设置签名的话返回nil
+func (this *BaseExternalServiceClass) SignHeaders(method string, requestUrl string, params map[string]interface{}) map[string]interface{} {
+	if this.signAppId == `` {
+		return nil
+	}
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		panic(err)
+	}
+	path := parsedUrl.Path
+	if path == `` {
+		path = `/`
+	}
+	timestamp := time.Now().Unix()
+	nonce := signature.NewNonce()
+	sign, err := signature.Sign(this.signAlgorithm, this.signKey, signature.BuildCanonical(method, path, params, timestamp, nonce))
+	if err != nil {
+		panic(err)
+	}
+	return map[string]interface{}{
+		string(api_session.HeaderName_XAppId):     this.signAppId,
+		string(api_session.HeaderName_XTimestamp): strconv.FormatInt(timestamp, 10),
+		string(api_session.HeaderName_XNonce):     nonce,
+		string(api_session.HeaderName_XSignature): sign,
+	}
+}
+
 func (this *BaseExternalServiceClass) PostJsonForStruct(url string, params map[string]interface{}, struct_ interface{}) {
 	data := this.PostJson(url, params)
 	inrec, err := json.Marshal(data)
@@ -31,15 +251,7 @@ func (this *BaseExternalServiceClass) PostJsonForStruct(url string, params map[s
 }
 
 func (this *BaseExternalServiceClass) PostJson(url string, params map[string]interface{}) interface{} {
-	result := api.ApiResult{}
-	go_http.Http.PostJsonForStruct(go_http.RequestParam{
-		Url:    url,
-		Params: params,
-	}, &result)
-	if result.Code != 0 {
-		go_error.Throw(result.Msg, result.Code)
-	}
-	return result.Data
+	return this.mustRequest(string(api_session.ApiMethod_Post), url, params)
 }
 
 func (this *BaseExternalServiceClass) GetJsonForStruct(url string, params map[string]interface{}, struct_ interface{}) {
@@ -55,13 +267,212 @@ func (this *BaseExternalServiceClass) GetJsonForStruct(url string, params map[st
 }
 
 func (this *BaseExternalServiceClass) GetJson(url string, params map[string]interface{}) interface{} {
-	result := api.ApiResult{}
-	go_http.Http.GetForStruct(go_http.RequestParam{
-		Url:    url,
-		Params: params,
-	}, &result)
+	return this.mustRequest(string(api_session.ApiMethod_Get), url, params)
+}
+
+// 调用失败的话抛出内部错误，对方返回的业务错误原样抛出
+func (this *BaseExternalServiceClass) mustRequest(method string, requestUrl string, params map[string]interface{}) interface{} {
+	result, err := this.Request(method, requestUrl, params)
+	if err != nil {
+		go_error.ThrowInternalError(fmt.Sprintf(`call external service %s error`, this.name), err)
+	}
 	if result.Code != 0 {
 		go_error.Throw(result.Msg, result.Code)
 	}
 	return result.Data
 }
+
+func (this *BaseExternalServiceClass) Request(method string, requestUrl string, params map[string]interface{}) (*api.ApiResult, error) {
+	return this.RequestContext(context.Background(), method, requestUrl, params)
+}
+
+/**
+按调用策略发送请求，返回对方的统一返回结构。ctx 取消后不再重试，ctx 中有 trace 的话传递给对方（b3 请求头）。
+requestUrl 以 / 开头的话，设置了服务发现时每次尝试按负载均衡选择节点并拼接在节点地址后面，否则拼接在 baseUrl 后面。
+ctx 取消或者超时、请求没有发出（地址有误、没有可用节点）不计入熔断器和节点摘除。连接失败、超时、5xx 和 429 算作失败，计入熔断器，幂等的调用会按指数退避重试；熔断器打开时直接返回 ErrBreakerOpen
+*/
+func (this *BaseExternalServiceClass) RequestContext(ctx context.Context, method string, requestUrl string, params map[string]interface{}) (*api.ApiResult, error) {
+	result, err := this.request(ctx, method, requestUrl, params)
+	if err != nil {
+		return nil, err
+	}
+	return &result.ApiResult, nil
+}
+
+// 同 RequestContext，返回结果中保留了 data 的原始内容
+func (this *BaseExternalServiceClass) request(ctx context.Context, method string, requestUrl string, params map[string]interface{}) (*rawApiResult, error) {
+	this.ensureConfigured()
+	start := time.Now()
+	retryable := method == string(api_session.ApiMethod_Get) || this.policy.RetryPost
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			recordRetry(this.name)
+			timer := time.NewTimer(this.policy.retryDelay(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				recordCall(this.name, `error`, start)
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if err := this.breaker.Allow(); err != nil {
+			recordCall(this.name, `rejected`, start)
+			return nil, err
+		}
+		target, peer, err := this.resolveUrl(ctx, requestUrl)
+		if err != nil {
+			this.breaker.Release()
+			recordCall(this.name, `error`, start)
+			return nil, err
+		}
+		request, err := this.newRequest(ctx, method, target, params)
+		if err != nil { // 地址或者参数有问题，请求没有发出，不计入熔断器和节点摘除
+			this.breaker.Release()
+			if peer != nil {
+				this.GetDiscovery().release(peer)
+			}
+			recordCall(this.name, `error`, start)
+			return nil, err
+		}
+		result, statusCode, err := this.doRequest(request)
+		if err != nil && ctx.Err() != nil { // 调用方取消或者超时，不是对方服务的问题，不计入熔断器和节点摘除，也不再重试
+			this.breaker.Release()
+			if peer != nil {
+				this.GetDiscovery().release(peer)
+			}
+			recordCall(this.name, `error`, start)
+			return nil, err
+		}
+		failed := err != nil && (statusCode == 0 || statusCode >= 500 || statusCode == int(api_session.StatusCode_TooManyRequests))
+		this.breaker.Record(!failed)
+		if peer != nil {
+			this.GetDiscovery().done(peer, failed)
+		}
+		if err == nil {
+			recordCall(this.name, `success`, start)
+			return result, nil
+		}
+		if !failed || !retryable || attempt >= this.policy.MaxRetries {
+			recordCall(this.name, `error`, start)
+			return nil, err
+		}
+	}
+}
+
+// 相对地址拼接在选择的节点地址或者 baseUrl 后面，选择了节点的话返回节点，请求结束后需要调用 DiscoveryClass.done
+func (this *BaseExternalServiceClass) resolveUrl(ctx context.Context, requestUrl string) (string, *PeerClass, error) {
+	if !strings.HasPrefix(requestUrl, `/`) {
+		return requestUrl, nil, nil
+	}
+	discovery := this.GetDiscovery()
+	if discovery == nil {
+		return this.GetBaseUrl() + requestUrl, nil, nil
+	}
+	peer, err := discovery.pick(ctx)
+	if err != nil {
+		return ``, nil, err
+	}
+	return strings.TrimRight(peer.GetUrl(), `/`) + requestUrl, peer, nil
+}
+
+// 构造一次请求，每次重试都重新构造（重新签名）
+func (this *BaseExternalServiceClass) newRequest(ctx context.Context, method string, requestUrl string, params map[string]interface{}) (*http.Request, error) {
+	target := requestUrl
+	var body io.Reader
+	if method == string(api_session.ApiMethod_Get) {
+		if len(params) > 0 {
+			query := url.Values{}
+			for key, value := range params {
+				if number, ok := value.(json.Number); ok {
+					query.Set(key, number.String())
+					continue
+				}
+				query.Set(key, go_reflect.Reflect.MustToString(value))
+			}
+			separator := `?`
+			if strings.Contains(target, `?`) {
+				separator = `&`
+			}
+			target += separator + query.Encode()
+		}
+	} else {
+		content, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(content)
+	}
+	request, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	if body != nil {
+		request.Header.Set(string(api_session.HeaderName_ContentType), `application/json`)
+	}
+	if span := trace.FromContext(ctx); span != nil {
+		(&b3.HTTPFormat{}).SpanContextToRequest(span.SpanContext(), request)
+	}
+	this.configLock.Lock()
+	for key, value := range this.headers {
+		request.Header.Set(key, value)
+	}
+	this.configLock.Unlock()
+	for key, value := range this.SignHeaders(method, requestUrl, params) { // 每次重试重新签名，nonce 不能重复
+		request.Header.Set(key, go_reflect.Reflect.MustToString(value))
+	}
+	return request, nil
+}
+
+// 对方的统一返回结构。data 保留原始内容，Call 直接解码到调用方的结构，不经过 interface{} 丢失精度
+type rawApiResult struct {
+	api.ApiResult
+	Data json.RawMessage `json:"data"`
+}
+
+// 发送一次请求。连接失败或者读取响应失败时返回的状态码为0
+func (this *BaseExternalServiceClass) doRequest(request *http.Request) (*rawApiResult, int, error) {
+	this.configLock.Lock()
+	client := this.client
+	this.configLock.Unlock()
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := rawApiResult{}
+	decodeErr := unmarshalUseNumber(content, &result)
+	if decodeErr == nil && len(result.Data) > 0 { // 数字解码成 json.Number，大整数不丢精度
+		decodeErr = unmarshalUseNumber(result.Data, &result.ApiResult.Data)
+	}
+	if response.StatusCode >= 400 {
+		if decodeErr == nil && result.Code != 0 && response.StatusCode < 500 && response.StatusCode != int(api_session.StatusCode_TooManyRequests) {
+			return &result, response.StatusCode, nil // 非 200 但是带有业务错误码
+		}
+		return nil, response.StatusCode, fmt.Errorf(`unexpected status %d: %s`, response.StatusCode, truncate(string(content), 256))
+	}
+	if decodeErr != nil {
+		return nil, response.StatusCode, fmt.Errorf(`decode response error: %v`, decodeErr)
+	}
+	return &result, response.StatusCode, nil
+}
+
+// 数字解码成 json.Number 而不是 float64
+func unmarshalUseNumber(content []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func truncate(str string, length int) string {
+	if len(str) <= length {
+		return str
+	}
+	return str[:length] + `...`
+}
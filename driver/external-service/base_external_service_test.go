@@ -0,0 +1,105 @@
+package external_service_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	external_service "github.com/pefish/go-core/driver/external-service"
+)
+
+// 调用方取消或者超时的请求不重试，也不计入熔断器和节点摘除
+func TestBaseExternalServiceClass_CallerCancel(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	base := external_service.NewBaseExternalService()
+	driver := external_service.NewExternalServiceDriver()
+	defer driver.Stop()
+	driver.Register(`slow`, base, external_service.WithPolicy(external_service.Policy{
+		MaxRetries:     2,
+		RetryBaseDelay: time.Millisecond,
+		Breaker: external_service.BreakerOption{
+			FailureThreshold: 1,
+		},
+	}), external_service.WithDiscovery(external_service.NewStaticResolver(upstream.URL), external_service.DiscoveryOption{
+		Outlier:     external_service.OutlierOption{ConsecutiveFailures: 1, MaxEjectionPercent: 100},
+		HealthCheck: external_service.HealthCheckOption{Disable: true},
+	}))
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := base.RequestContext(ctx, `GET`, `/slow`, nil)
+		cancel()
+		if err == nil {
+			t.Fatal(`request should fail`)
+		}
+	}
+	if hits := atomic.LoadInt32(&hits); hits != 3 {
+		t.Errorf(`canceled requests should not be retried, got %d hits`, hits)
+	}
+	if state := base.GetBreakerState(); state != external_service.BreakerState_Closed {
+		t.Errorf(`breaker should stay closed, got %s`, state)
+	}
+	if peers := base.GetDiscovery().GetPeers(); len(peers) != 1 || peers[0].Ejected || peers[0].Inflight != 0 {
+		t.Errorf(`peer should not be ejected, got %+v`, peers)
+	}
+}
+
+// 没有发出的请求（没有可用节点、地址有误）释放熔断器半开状态的探测名额，也不计为失败
+func TestBaseExternalServiceClass_NotSent(t *testing.T) {
+	var failing int32 = 1
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"code":0,"msg":"","data":null}`))
+	}))
+	defer upstream.Close()
+
+	base := external_service.NewBaseExternalService()
+	driver := external_service.NewExternalServiceDriver()
+	defer driver.Stop()
+	driver.Register(`empty`, base, external_service.WithPolicy(external_service.Policy{
+		Breaker: external_service.BreakerOption{
+			FailureThreshold:    1,
+			OpenTimeout:         20 * time.Millisecond,
+			HalfOpenMaxRequests: 1,
+		},
+	}), external_service.WithDiscovery(external_service.NewStaticResolver(), external_service.DiscoveryOption{
+		HealthCheck: external_service.HealthCheckOption{Disable: true},
+	}))
+
+	if _, err := base.Request(`GET`, upstream.URL, nil); err == nil {
+		t.Fatal(`request should fail`)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if state := base.GetBreakerState(); state != external_service.BreakerState_HalfOpen {
+		t.Fatalf(`breaker should be half-open, got %s`, state)
+	}
+	if _, err := base.Request(`GET`, `/ping`, nil); !errors.Is(err, external_service.ErrNoPeers) {
+		t.Fatalf(`expect no peers, got %v`, err)
+	}
+	if _, err := base.Request(`GET`, `http://[::1`, nil); err == nil || err == external_service.ErrBreakerOpen {
+		t.Fatalf(`expect malformed url error, got %v`, err)
+	}
+	if state := base.GetBreakerState(); state != external_service.BreakerState_HalfOpen {
+		t.Fatalf(`requests not sent should not be recorded, got %s`, state)
+	}
+	atomic.StoreInt32(&failing, 0)
+	if _, err := base.Request(`GET`, upstream.URL, nil); err != nil {
+		t.Fatalf(`probe should be allowed, got %v`, err)
+	}
+	if state := base.GetBreakerState(); state != external_service.BreakerState_Closed {
+		t.Errorf(`breaker should be closed, got %s`, state)
+	}
+}
@@ -0,0 +1,149 @@
+package external_service
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrBreakerOpen = errors.New(`circuit breaker is open`)
+
+type BreakerState int
+
+const (
+	BreakerState_Closed BreakerState = iota
+	BreakerState_Open
+	BreakerState_HalfOpen
+)
+
+func (this BreakerState) String() string {
+	switch this {
+	case BreakerState_Open:
+		return `open`
+	case BreakerState_HalfOpen:
+		return `half-open`
+	default:
+		return `closed`
+	}
+}
+
+// 熔断器，一个外部服务一个
+type CircuitBreakerClass struct {
+	sync.Mutex
+	option        BreakerOption
+	state         BreakerState
+	failures      int       // 关闭状态下连续失败的次数
+	openedAt      time.Time // 打开的时间
+	probes        int       // 半开状态下已经放行的探测调用
+	probeSuccess  int       // 半开状态下成功的探测调用
+	onStateChange func(from BreakerState, to BreakerState)
+}
+
+func NewCircuitBreaker(option BreakerOption) *CircuitBreakerClass {
+	return &CircuitBreakerClass{
+		option: option,
+	}
+}
+
+// 状态变化时的回调，在锁内调用，不要在回调中调用熔断器的方法
+func (this *CircuitBreakerClass) SetOnStateChange(onStateChange func(from BreakerState, to BreakerState)) {
+	this.onStateChange = onStateChange
+}
+
+func (this *CircuitBreakerClass) GetState() BreakerState {
+	this.Lock()
+	defer this.Unlock()
+	if this.state == BreakerState_Open && time.Since(this.openedAt) >= this.option.OpenTimeout {
+		return BreakerState_HalfOpen // 下一个调用会作为探测放行
+	}
+	return this.state
+}
+
+// 调用前检查，不允许调用的话返回 ErrBreakerOpen。允许的话调用结束后需要调用 Record
+func (this *CircuitBreakerClass) Allow() error {
+	if this.option.Disable {
+		return nil
+	}
+	this.Lock()
+	defer this.Unlock()
+	switch this.state {
+	case BreakerState_Open:
+		if time.Since(this.openedAt) < this.option.OpenTimeout {
+			return ErrBreakerOpen
+		}
+		this.setState(BreakerState_HalfOpen)
+		this.probes = 1
+		return nil
+	case BreakerState_HalfOpen:
+		if this.probes >= this.option.HalfOpenMaxRequests {
+			return ErrBreakerOpen
+		}
+		this.probes++
+		return nil
+	}
+	return nil
+}
+
+// 记录调用结果
+func (this *CircuitBreakerClass) Record(success bool) {
+	if this.option.Disable {
+		return
+	}
+	this.Lock()
+	defer this.Unlock()
+	switch this.state {
+	case BreakerState_Closed:
+		if success {
+			this.failures = 0
+			return
+		}
+		this.failures++
+		if this.failures >= this.option.FailureThreshold {
+			this.open()
+		}
+	case BreakerState_HalfOpen:
+		if !success {
+			this.open()
+			return
+		}
+		this.probeSuccess++
+		if this.probeSuccess >= this.option.HalfOpenMaxRequests {
+			this.failures = 0
+			this.setState(BreakerState_Closed)
+		}
+	}
+}
+
+// 调用被调用方取消或者超时，不计入结果。半开状态下归还探测名额
+func (this *CircuitBreakerClass) Release() {
+	if this.option.Disable {
+		return
+	}
+	this.Lock()
+	defer this.Unlock()
+	if this.state == BreakerState_HalfOpen && this.probes > 0 {
+		this.probes--
+	}
+}
+
+func (this *CircuitBreakerClass) open() {
+	this.openedAt = time.Now()
+	this.probes = 0
+	this.probeSuccess = 0
+	this.setState(BreakerState_Open)
+}
+
+func (this *CircuitBreakerClass) setState(state BreakerState) {
+	if state == this.state {
+		return
+	}
+	from := this.state
+	this.state = state
+	if state == BreakerState_HalfOpen {
+		this.probes = 0
+		this.probeSuccess = 0
+	}
+	if this.onStateChange != nil {
+		this.onStateChange(from, state)
+	}
+}
@@ -0,0 +1,365 @@
+package external_service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	go_application "github.com/pefish/go-application"
+)
+
+var ErrNoPeers = errors.New(`no available peer`)
+
+// 服务发现和负载均衡的配置
+type DiscoveryOption struct {
+	Balancer        InterfaceBalancer // 默认轮询
+	RefreshInterval time.Duration     // 重新解析节点的间隔，默认10秒
+	Outlier         OutlierOption
+	HealthCheck     HealthCheckOption
+}
+
+// 被动摘除异常节点。节点连续失败 ConsecutiveFailures 次后摘除一段时间，时间为 BaseEjectionTime 乘以连续被摘除的次数，
+// 不超过 MaxEjectionTime。被摘除的节点占比不超过 MaxEjectionPercent
+type OutlierOption struct {
+	Disable             bool
+	ConsecutiveFailures int           // 默认5
+	BaseEjectionTime    time.Duration // 默认30秒
+	MaxEjectionTime     time.Duration // 默认5分钟
+	MaxEjectionPercent  int           // 默认50
+}
+
+// 主动健康检查。定时请求各个节点的 Path（相对于节点的 host，默认 /healthz），返回 200 算健康，
+// 连续失败 UnhealthyThreshold 次后不再选择该节点，成功一次后恢复
+type HealthCheckOption struct {
+	Disable            bool
+	Path               string        // 默认 /healthz
+	Interval           time.Duration // 默认10秒
+	Timeout            time.Duration // 默认2秒
+	UnhealthyThreshold int           // 默认2
+}
+
+var DefaultDiscoveryOption = DiscoveryOption{
+	RefreshInterval: 10 * time.Second,
+	Outlier: OutlierOption{
+		ConsecutiveFailures: 5,
+		BaseEjectionTime:    30 * time.Second,
+		MaxEjectionTime:     5 * time.Minute,
+		MaxEjectionPercent:  50,
+	},
+	HealthCheck: HealthCheckOption{
+		Path:               `/healthz`,
+		Interval:           10 * time.Second,
+		Timeout:            2 * time.Second,
+		UnhealthyThreshold: 2,
+	},
+}
+
+// 没有设置的字段使用默认值
+func (this DiscoveryOption) withDefaults() DiscoveryOption {
+	if this.Balancer == nil {
+		this.Balancer = NewRoundRobinBalancer()
+	}
+	if this.RefreshInterval <= 0 {
+		this.RefreshInterval = DefaultDiscoveryOption.RefreshInterval
+	}
+	if this.Outlier.ConsecutiveFailures <= 0 {
+		this.Outlier.ConsecutiveFailures = DefaultDiscoveryOption.Outlier.ConsecutiveFailures
+	}
+	if this.Outlier.BaseEjectionTime <= 0 {
+		this.Outlier.BaseEjectionTime = DefaultDiscoveryOption.Outlier.BaseEjectionTime
+	}
+	if this.Outlier.MaxEjectionTime <= 0 {
+		this.Outlier.MaxEjectionTime = DefaultDiscoveryOption.Outlier.MaxEjectionTime
+	}
+	if this.Outlier.MaxEjectionPercent <= 0 {
+		this.Outlier.MaxEjectionPercent = DefaultDiscoveryOption.Outlier.MaxEjectionPercent
+	}
+	if this.HealthCheck.Path == `` {
+		this.HealthCheck.Path = DefaultDiscoveryOption.HealthCheck.Path
+	}
+	if this.HealthCheck.Interval <= 0 {
+		this.HealthCheck.Interval = DefaultDiscoveryOption.HealthCheck.Interval
+	}
+	if this.HealthCheck.Timeout <= 0 {
+		this.HealthCheck.Timeout = DefaultDiscoveryOption.HealthCheck.Timeout
+	}
+	if this.HealthCheck.UnhealthyThreshold <= 0 {
+		this.HealthCheck.UnhealthyThreshold = DefaultDiscoveryOption.HealthCheck.UnhealthyThreshold
+	}
+	return this
+}
+
+// 通过服务发现找到节点，按负载均衡选择节点。注册时设置 WithDiscovery 的话，以 / 开头的请求地址拼接在选择的节点地址后面
+func WithDiscovery(resolver InterfaceResolver, option DiscoveryOption) RegisterOptionFunc {
+	return func(options *RegisterOption) {
+		options.resolver = resolver
+		options.discoveryOption = option
+	}
+}
+
+// 外部服务的一个节点。除 inflight 外的状态由 DiscoveryClass 的锁保护
+type PeerClass struct {
+	url                 string
+	inflight            int64
+	consecutiveFailures int       // 连续失败的调用次数
+	ejections           int       // 连续被摘除的次数，调用成功后清零
+	ejectedUntil        time.Time // 被摘除到什么时候
+	healthFailures      int       // 连续失败的健康检查次数
+}
+
+// 节点的基础地址
+func (this *PeerClass) GetUrl() string {
+	return this.url
+}
+
+// 正在处理的请求数
+func (this *PeerClass) GetInflight() int64 {
+	return atomic.LoadInt64(&this.inflight)
+}
+
+// 节点的状态，用于列出注册的服务
+type PeerInfo struct {
+	Url      string `json:"url"`
+	Inflight int64  `json:"inflight"`
+	Healthy  bool   `json:"healthy"`
+	Ejected  bool   `json:"ejected"`
+}
+
+// 一个外部服务的服务发现、负载均衡、异常节点摘除和健康检查。第一次选择节点时解析节点并启动后台的定时刷新和健康检查
+type DiscoveryClass struct {
+	sync.Mutex
+	name       string
+	resolver   InterfaceResolver
+	option     DiscoveryOption
+	getClient  func() *http.Client // 健康检查使用服务的 http 客户端，替换的 transport 同样生效
+	peers      []*PeerClass
+	resolveErr error // 最近一次解析的错误
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+func NewDiscovery(name string, resolver InterfaceResolver, option DiscoveryOption) *DiscoveryClass {
+	return &DiscoveryClass{
+		name:      name,
+		resolver:  resolver,
+		option:    option.withDefaults(),
+		getClient: func() *http.Client { return http.DefaultClient },
+		peers:     []*PeerClass{},
+		closeChan: make(chan struct{}),
+	}
+}
+
+// 停止后台的定时刷新和健康检查。应用退出时也会自动停止
+func (this *DiscoveryClass) Close() {
+	this.closeOnce.Do(func() {
+		close(this.closeChan)
+	})
+}
+
+// 首次解析节点，并启动后台任务
+func (this *DiscoveryClass) start() {
+	this.startOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), this.option.RefreshInterval)
+		this.Refresh(ctx)
+		cancel()
+		go this.loop()
+	})
+}
+
+func (this *DiscoveryClass) loop() {
+	refreshTicker := time.NewTicker(this.option.RefreshInterval)
+	defer refreshTicker.Stop()
+	var healthChan <-chan time.Time
+	if !this.option.HealthCheck.Disable {
+		healthTicker := time.NewTicker(this.option.HealthCheck.Interval)
+		defer healthTicker.Stop()
+		healthChan = healthTicker.C
+	}
+	for {
+		select {
+		case <-this.closeChan:
+			return
+		case <-go_application.Application.OnFinished(): // 应用退出时停止
+			return
+		case <-refreshTicker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), this.option.RefreshInterval)
+			this.Refresh(ctx)
+			cancel()
+		case <-healthChan:
+			this.CheckHealth(context.Background())
+		}
+	}
+}
+
+// 重新解析节点。地址没有变的节点保留原来的状态；解析失败或者结果为空的话保留之前的节点
+func (this *DiscoveryClass) Refresh(ctx context.Context) error {
+	urls, err := this.resolver.Resolve(ctx)
+	if err == nil && len(urls) == 0 {
+		err = fmt.Errorf(`resolver returns no peer`)
+	}
+	this.Lock()
+	defer this.Unlock()
+	this.resolveErr = err
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]*PeerClass, len(this.peers))
+	for _, peer := range this.peers {
+		existing[peer.url] = peer
+	}
+	peers := make([]*PeerClass, 0, len(urls))
+	seen := map[string]bool{}
+	for _, peerUrl := range urls {
+		if seen[peerUrl] {
+			continue
+		}
+		seen[peerUrl] = true
+		if peer, ok := existing[peerUrl]; ok {
+			peers = append(peers, peer)
+		} else {
+			peers = append(peers, &PeerClass{url: peerUrl})
+		}
+	}
+	this.peers = peers
+	return nil
+}
+
+// 对所有节点做一次健康检查
+func (this *DiscoveryClass) CheckHealth(ctx context.Context) {
+	this.Lock()
+	peers := append([]*PeerClass{}, this.peers...)
+	this.Unlock()
+	client := this.getClient()
+	wg := sync.WaitGroup{}
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer *PeerClass) {
+			defer wg.Done()
+			healthy := this.checkPeer(ctx, client, peer)
+			this.Lock()
+			defer this.Unlock()
+			if healthy {
+				peer.healthFailures = 0
+			} else {
+				peer.healthFailures++
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+func (this *DiscoveryClass) checkPeer(ctx context.Context, client *http.Client, peer *PeerClass) bool {
+	healthUrl, err := url.Parse(peer.url)
+	if err != nil {
+		return false
+	}
+	healthUrl.Path = this.option.HealthCheck.Path
+	healthUrl.RawQuery = ``
+	ctx, cancel := context.WithTimeout(ctx, this.option.HealthCheck.Timeout)
+	defer cancel()
+	request, err := http.NewRequest(http.MethodGet, healthUrl.String(), nil)
+	if err != nil {
+		return false
+	}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode == http.StatusOK
+}
+
+// 选择一个节点。没有健康且未被摘除的节点的话，在所有节点中选择，避免全部不可用
+func (this *DiscoveryClass) pick(ctx context.Context) (*PeerClass, error) {
+	this.start()
+	this.Lock()
+	if len(this.peers) == 0 {
+		err := this.resolveErr
+		this.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf(`%w: %s: %v`, ErrNoPeers, this.name, err)
+		}
+		return nil, fmt.Errorf(`%w: %s`, ErrNoPeers, this.name)
+	}
+	now := time.Now()
+	available := make([]*PeerClass, 0, len(this.peers))
+	for _, peer := range this.peers {
+		if this.isHealthy(peer) && !peer.ejectedUntil.After(now) {
+			available = append(available, peer)
+		}
+	}
+	if len(available) == 0 {
+		available = append(available, this.peers...)
+	}
+	this.Unlock()
+	peer := this.option.Balancer.Pick(available, getHashKey(ctx))
+	atomic.AddInt64(&peer.inflight, 1)
+	return peer, nil
+}
+
+// 请求被调用方取消或者超时，不计入节点的结果
+func (this *DiscoveryClass) release(peer *PeerClass) {
+	atomic.AddInt64(&peer.inflight, -1)
+}
+
+// 请求结束后记录结果，连续失败的节点被摘除
+func (this *DiscoveryClass) done(peer *PeerClass, failed bool) {
+	atomic.AddInt64(&peer.inflight, -1)
+	this.Lock()
+	defer this.Unlock()
+	if !failed {
+		peer.consecutiveFailures = 0
+		peer.ejections = 0
+		return
+	}
+	peer.consecutiveFailures++
+	now := time.Now()
+	if this.option.Outlier.Disable || peer.consecutiveFailures < this.option.Outlier.ConsecutiveFailures || peer.ejectedUntil.After(now) {
+		return
+	}
+	ejected := 0
+	for _, p := range this.peers {
+		if p.ejectedUntil.After(now) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(this.peers)*this.option.Outlier.MaxEjectionPercent {
+		return
+	}
+	peer.ejections++
+	ejectionTime := this.option.Outlier.BaseEjectionTime * time.Duration(peer.ejections)
+	if ejectionTime > this.option.Outlier.MaxEjectionTime {
+		ejectionTime = this.option.Outlier.MaxEjectionTime
+	}
+	peer.ejectedUntil = now.Add(ejectionTime)
+	peer.consecutiveFailures = 0
+}
+
+// 调用前需要加锁
+func (this *DiscoveryClass) isHealthy(peer *PeerClass) bool {
+	return this.option.HealthCheck.Disable || peer.healthFailures < this.option.HealthCheck.UnhealthyThreshold
+}
+
+// 各个节点的状态
+func (this *DiscoveryClass) GetPeers() []PeerInfo {
+	this.Lock()
+	defer this.Unlock()
+	now := time.Now()
+	infos := make([]PeerInfo, 0, len(this.peers))
+	for _, peer := range this.peers {
+		infos = append(infos, PeerInfo{
+			Url:      peer.url,
+			Inflight: peer.GetInflight(),
+			Healthy:  this.isHealthy(peer),
+			Ejected:  peer.ejectedUntil.After(now),
+		})
+	}
+	return infos
+}
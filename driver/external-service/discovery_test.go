@@ -0,0 +1,249 @@
+package external_service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	go_core "github.com/pefish/go-core"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	external_service "github.com/pefish/go-core/driver/external-service"
+	test_client "github.com/pefish/go-core/test-client"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// 返回自己名字的上游服务，unhealthy 为 true 时 /healthz 返回失败
+func newWhoUpstream(name string, unhealthy *int32) *httptest.Server {
+	type whoParam struct{}
+	upstreamService := go_core.NewStandaloneService(name)
+	upstreamService.SetPath(`/api`)
+	upstreamService.SetRoutes([]*api.Api{
+		{
+			Path:   `/who`,
+			Method: api_session.ApiMethod_Post,
+			TypedController: func(apiSession *api_session.ApiSessionClass, params whoParam) (string, error) {
+				return name, nil
+			},
+		},
+	})
+	upstreamService.SetHealthyCheckFunc(func() {
+		if unhealthy != nil && atomic.LoadInt32(unhealthy) == 1 {
+			panic(`unhealthy`)
+		}
+	})
+	upstreamService.GetLoggerDriver().Register(test_client.NewFakeLogger())
+	return httptest.NewServer(upstreamService)
+}
+
+// 只回答一条 SRV 记录的本地 DNS 服务
+func newSrvDnsServer(t *testing.T, name string, target string, port uint16) string {
+	conn, err := net.ListenPacket(`udp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := dnsmessage.Message{}
+			if err := request.Unpack(buf[:n]); err != nil || len(request.Questions) == 0 {
+				continue
+			}
+			question := request.Questions[0]
+			response := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: request.ID, Response: true, Authoritative: true},
+				Questions: request.Questions,
+			}
+			if question.Type == dnsmessage.TypeSRV && question.Name.String() == name {
+				response.Answers = []dnsmessage.Resource{
+					{
+						Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &dnsmessage.SRVResource{Priority: 1, Weight: 10, Port: port, Target: dnsmessage.MustNewName(target)},
+					},
+					{
+						Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &dnsmessage.SRVResource{Priority: 2, Weight: 10, Port: 1, Target: dnsmessage.MustNewName(`backup.example.test.`)},
+					},
+				}
+			} else {
+				response.RCode = dnsmessage.RCodeNameError
+			}
+			packed, err := response.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// 轮询、一致性哈希、被动摘除、主动健康检查，以及静态、文件和 DNS SRV 解析
+func TestDiscoveryClass_Balance(t *testing.T) {
+	var unhealthyB int32
+	upstreamA := newWhoUpstream(`a`, nil)
+	defer upstreamA.Close()
+	upstreamB := newWhoUpstream(`b`, &unhealthyB)
+	defer upstreamB.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	endpoint := external_service.Endpoint{
+		Name: `who`,
+		Path: `/who`,
+	}
+	driver := external_service.NewExternalServiceDriver()
+	defer driver.Stop()
+	callWho := func(ctx context.Context) (string, error) {
+		var who string
+		err := driver.Call(ctx, `who`, `who`, map[string]interface{}{}, &who)
+		return who, err
+	}
+
+	// 轮询
+	driver.Register(`who`, external_service.NewBaseExternalService(), external_service.WithEndpoints(endpoint), external_service.WithDiscovery(external_service.NewStaticResolver(upstreamA.URL+`/api`, upstreamB.URL+`/api/`), external_service.DiscoveryOption{
+		HealthCheck: external_service.HealthCheckOption{Disable: true},
+	}))
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		who, err := callWho(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[who]++
+	}
+	if counts[`a`] != 2 || counts[`b`] != 2 {
+		t.Errorf(`round robin should spread calls evenly, got %v`, counts)
+	}
+
+	// 一致性哈希
+	driver.Register(`who`, external_service.NewBaseExternalService(), external_service.WithEndpoints(endpoint), external_service.WithDiscovery(external_service.NewStaticResolver(upstreamA.URL+`/api`, upstreamB.URL+`/api`), external_service.DiscoveryOption{
+		Balancer:    external_service.NewConsistentHashBalancer(0),
+		HealthCheck: external_service.HealthCheckOption{Disable: true},
+	}))
+	for _, key := range []string{`user-1`, `user-2`, `user-3`} {
+		first, err := callWho(external_service.WithHashKey(context.Background(), key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if who, _ := callWho(external_service.WithHashKey(context.Background(), key)); who != first {
+				t.Errorf(`key %s should stick to %s, got %s`, key, first, who)
+			}
+		}
+	}
+
+	// 被动摘除：不可用的节点失败一次后被摘除，之后的调用不再选择它
+	driver.Register(`who`, external_service.NewBaseExternalService(), external_service.WithEndpoints(endpoint), external_service.WithPolicy(external_service.Policy{
+		Breaker: external_service.BreakerOption{Disable: true},
+	}), external_service.WithDiscovery(external_service.NewStaticResolver(dead.URL, upstreamA.URL+`/api`, upstreamB.URL+`/api`), external_service.DiscoveryOption{
+		Balancer:    external_service.NewLeastInflightBalancer(),
+		Outlier:     external_service.OutlierOption{ConsecutiveFailures: 1},
+		HealthCheck: external_service.HealthCheckOption{Disable: true},
+	}))
+	failures := 0
+	for i := 0; i < 6; i++ {
+		if _, err := callWho(context.Background()); err != nil {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Errorf(`dead peer should fail once and be ejected, got %d failures`, failures)
+	}
+	if peers := driver.List()[0].Peers; len(peers) != 3 || peers[0].Url != dead.URL || !peers[0].Ejected || peers[1].Ejected {
+		t.Errorf(`unexpected peers %+v`, peers)
+	}
+
+	// 主动健康检查：/healthz 连续失败的节点不再被选择，恢复后重新被选择
+	base := external_service.NewBaseExternalService()
+	driver.Register(`who`, base, external_service.WithEndpoints(endpoint), external_service.WithDiscovery(external_service.NewStaticResolver(upstreamA.URL+`/api`, upstreamB.URL+`/api`), external_service.DiscoveryOption{
+		HealthCheck: external_service.HealthCheckOption{Interval: time.Hour},
+	}))
+	atomic.StoreInt32(&unhealthyB, 1)
+	base.GetDiscovery().Refresh(context.Background())
+	base.GetDiscovery().CheckHealth(context.Background())
+	base.GetDiscovery().CheckHealth(context.Background())
+	for i := 0; i < 4; i++ {
+		if who, err := callWho(context.Background()); err != nil || who != `a` {
+			t.Errorf(`unhealthy peer should be skipped, got %s, err %v`, who, err)
+		}
+	}
+	atomic.StoreInt32(&unhealthyB, 0)
+	base.GetDiscovery().CheckHealth(context.Background())
+	counts = map[string]int{}
+	for i := 0; i < 4; i++ {
+		who, _ := callWho(context.Background())
+		counts[who]++
+	}
+	if counts[`b`] != 2 {
+		t.Errorf(`recovered peer should be picked again, got %v`, counts)
+	}
+
+	// 文件：修改文件后重新解析
+	peersFile := filepath.Join(t.TempDir(), `peers.txt`)
+	if err := ioutil.WriteFile(peersFile, []byte("# who\n"+upstreamA.URL+"/api\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	base = external_service.NewBaseExternalService()
+	driver.Register(`who`, base, external_service.WithEndpoints(endpoint), external_service.WithDiscovery(external_service.NewFileResolver(peersFile), external_service.DiscoveryOption{
+		HealthCheck: external_service.HealthCheckOption{Disable: true},
+	}))
+	if who, err := callWho(context.Background()); err != nil || who != `a` {
+		t.Fatalf(`unexpected peer %s, err %v`, who, err)
+	}
+	if err := ioutil.WriteFile(peersFile, []byte(upstreamB.URL+"/api\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(peersFile, later, later)
+	if err := base.GetDiscovery().Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if who, err := callWho(context.Background()); err != nil || who != `b` {
+		t.Errorf(`file change should be picked up, got %s, err %v`, who, err)
+	}
+
+	// DNS SRV：使用本地 DNS 服务，只使用优先级最高的记录
+	upstreamUrl, _ := url.Parse(upstreamA.URL)
+	port, _ := strconv.Atoi(upstreamUrl.Port())
+	dnsAddr := newSrvDnsServer(t, `_who._tcp.example.test.`, `localhost.`, uint16(port))
+	resolver := external_service.NewDnsSrvResolver(`who`, `tcp`, `example.test`).SetPath(`/api`).SetResolver(&net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, `udp`, dnsAddr)
+		},
+	})
+	urls, err := resolver.Resolve(context.Background())
+	if err != nil || len(urls) != 1 || urls[0] != fmt.Sprintf(`http://localhost:%d/api`, port) {
+		t.Fatalf(`unexpected srv urls %v, err %v`, urls, err)
+	}
+	driver.Register(`who`, external_service.NewBaseExternalService(), external_service.WithEndpoints(endpoint), external_service.WithDiscovery(resolver, external_service.DiscoveryOption{
+		HealthCheck: external_service.HealthCheckOption{Disable: true},
+	}))
+	if who, err := callWho(context.Background()); err != nil || who != `a` {
+		t.Errorf(`unexpected peer from srv %s, err %v`, who, err)
+	}
+
+	driver.Register(`who`, external_service.NewBaseExternalService(), external_service.WithEndpoints(endpoint), external_service.WithDiscovery(external_service.NewStaticResolver(), external_service.DiscoveryOption{}))
+	if _, err := callWho(context.Background()); !errors.Is(err, external_service.ErrNoPeers) {
+		t.Errorf(`expect no peers, got %v`, err)
+	}
+}
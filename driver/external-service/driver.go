@@ -1,5 +1,17 @@
 package external_service
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/pefish/go-error"
+	"go.opencensus.io/trace"
+)
+
 // 接口驱动
 type ExternalServiceDriverClass struct {
 	externalServices map[string]InterfaceExternalService
@@ -9,27 +21,218 @@ var ExternalServiceDriver = ExternalServiceDriverClass{
 	externalServices: map[string]InterfaceExternalService{},
 }
 
+// 新建外接服务驱动，每个服务可以持有自己的一份
+func NewExternalServiceDriver() *ExternalServiceDriverClass {
+	return &ExternalServiceDriverClass{
+		externalServices: map[string]InterfaceExternalService{},
+	}
+}
+
 func (this *ExternalServiceDriverClass) Startup() {
 	for _, v := range this.externalServices {
 		v.Init(this)
 	}
 }
 
-func (this *ExternalServiceDriverClass) Register(name string, svc InterfaceExternalService) bool {
+// 注册外部服务。服务嵌入了 BaseExternalServiceClass 的话，按 name 设置调用策略（超时、重试、熔断）、基础地址和接口，
+// 见 WithPolicy、WithBaseUrl、WithEndpoints、WithTransport、WithDiscovery
+func (this *ExternalServiceDriverClass) Register(name string, svc InterfaceExternalService, opts ...RegisterOptionFunc) bool {
+	if this.externalServices == nil {
+		this.externalServices = map[string]InterfaceExternalService{}
+	}
+	option := RegisterOption{
+		policy: DefaultPolicy,
+	}
+	for _, o := range opts {
+		o(&option)
+	}
+	if base, ok := svc.(interfaceBaseExternalService); ok {
+		base.getBase().configure(name, option.policy)
+		if option.baseUrl != `` {
+			base.getBase().SetBaseUrl(option.baseUrl)
+		}
+		base.getBase().AddEndpoints(option.endpoints...)
+		if option.transport != nil {
+			base.getBase().SetTransport(option.transport)
+		}
+		if option.resolver != nil {
+			base.getBase().SetDiscovery(NewDiscovery(name, option.resolver, option.discoveryOption))
+		}
+	}
+	if old, ok := this.externalServices[name].(interfaceBaseExternalService); ok && this.externalServices[name] != svc { // 替换的服务停止服务发现
+		if discovery := old.getBase().GetDiscovery(); discovery != nil {
+			discovery.Close()
+		}
+	}
 	this.externalServices[name] = svc
 	return true
 }
 
-func (this *ExternalServiceDriverClass) Call(name string, method string) interface{} {
-	// TODO 调用name外部服务
+// 各个外部服务熔断器的状态，按服务名
+func (this *ExternalServiceDriverClass) GetBreakerStates() map[string]BreakerState {
+	states := map[string]BreakerState{}
+	for name, svc := range this.externalServices {
+		if base, ok := svc.(interfaceBaseExternalService); ok {
+			states[name] = base.getBase().GetBreakerState()
+		}
+	}
+	return states
+}
+
+// 停止各个外部服务服务发现的后台任务
+func (this *ExternalServiceDriverClass) Stop() {
+	for _, svc := range this.externalServices {
+		if base, ok := svc.(interfaceBaseExternalService); ok {
+			if discovery := base.getBase().GetDiscovery(); discovery != nil {
+				discovery.Close()
+			}
+		}
+	}
+}
+
+// 注册的外部服务名，按字母排序
+func (this *ExternalServiceDriverClass) GetNames() []string {
+	names := make([]string, 0, len(this.externalServices))
+	for name := range this.externalServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/**
+调用外部服务声明的接口。params 是结构体（或其指针）或 map，out 是返回值（ApiResult 中的 data）要解析到的指针，不需要的话传nil。
+按服务的调用策略重试和熔断，带上服务的签名和请求头，ctx 中有 trace 的话新建子 span 并传递给对方。
+对方返回业务错误的话返回 *go_error.ErrorInfo（错误码和消息与对方一致）
+*/
+func (this *ExternalServiceDriverClass) Call(ctx context.Context, name string, endpointName string, params interface{}, out interface{}) error {
+	svc, ok := this.externalServices[name]
+	if !ok {
+		return fmt.Errorf(`%w: %s`, ErrServiceNotFound, name)
+	}
+	base, ok := svc.(interfaceBaseExternalService)
+	if !ok {
+		return fmt.Errorf(`external service %s does not embed BaseExternalServiceClass`, name)
+	}
+	endpoint, ok := base.getBase().GetEndpoint(endpointName)
+	if !ok {
+		return fmt.Errorf(`%w: %s.%s`, ErrEndpointNotFound, name, endpointName)
+	}
+	if err := checkCallTypes(endpoint, params, out); err != nil {
+		return fmt.Errorf(`call %s.%s: %v`, name, endpointName, err)
+	}
+	paramsMap, err := toParamsMap(params)
+	if err != nil {
+		return fmt.Errorf(`call %s.%s: encode params error: %v`, name, endpointName, err)
+	}
+
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf(`external_service/%s/%s`, name, endpointName), trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	result, err := base.getBase().request(ctx, endpoint.getMethod(), endpoint.getPath(), paramsMap)
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnavailable, Message: err.Error()})
+		return err
+	}
+	if result.Code != 0 {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: result.Msg})
+		return &go_error.ErrorInfo{
+			ErrorMessage:         result.Msg,
+			InternalErrorMessage: result.InternalMsg,
+			ErrorCode:            result.Code,
+			Data:                 result.ApiResult.Data,
+		}
+	}
+	if out == nil || len(result.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(result.Data, out); err != nil {
+		return fmt.Errorf(`call %s.%s: decode data error: %v`, name, endpointName, err)
+	}
+	return nil
+}
+
+// 列出注册的外部服务和它们声明的接口，按服务名排序
+func (this *ExternalServiceDriverClass) List() []ServiceInfo {
+	services := make([]ServiceInfo, 0, len(this.externalServices))
+	for _, name := range this.GetNames() {
+		info := ServiceInfo{
+			Name:      name,
+			Endpoints: []EndpointInfo{},
+		}
+		if base, ok := this.externalServices[name].(interfaceBaseExternalService); ok {
+			info.BaseUrl = base.getBase().GetBaseUrl()
+			info.BreakerState = base.getBase().GetBreakerState().String()
+			if discovery := base.getBase().GetDiscovery(); discovery != nil {
+				info.Peers = discovery.GetPeers()
+			}
+			for _, endpoint := range base.getBase().GetEndpoints() {
+				info.Endpoints = append(info.Endpoints, endpoint.toInfo())
+			}
+		}
+		services = append(services, info)
+	}
+	return services
+}
+
+// 检查参数和返回值的类型与接口声明是否一致，map 不检查
+func checkCallTypes(endpoint Endpoint, params interface{}, out interface{}) error {
+	if declared, actual := indirectType(endpoint.Params), indirectType(params); declared != nil && actual != nil && declared != actual && actual.Kind() != reflect.Map {
+		return fmt.Errorf(`params must be %s, got %s`, declared, actual)
+	}
+	if out != nil && reflect.TypeOf(out).Kind() != reflect.Ptr {
+		return fmt.Errorf(`out must be a pointer, got %T`, out)
+	}
+	if declared, actual := indirectType(endpoint.Return), indirectType(out); declared != nil && actual != nil && declared != actual && actual.Kind() != reflect.Interface && actual.Kind() != reflect.Map {
+		return fmt.Errorf(`out must be *%s, got %T`, declared, out)
+	}
 	return nil
 }
 
+// 参数转成 map，结构体按 json tag。数字转成 json.Number，大整数不丢精度
+func toParamsMap(params interface{}) (map[string]interface{}, error) {
+	if params == nil {
+		return nil, nil
+	}
+	if paramsMap, ok := params.(map[string]interface{}); ok {
+		return paramsMap, nil
+	}
+	content, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	paramsMap := map[string]interface{}{}
+	if err := unmarshalUseNumber(content, &paramsMap); err != nil {
+		return nil, err
+	}
+	return paramsMap, nil
+}
+
+// 外部服务。嵌入 BaseExternalServiceClass 的话可以使用调用策略、声明接口并通过 Call 调用
 type InterfaceExternalService interface {
 	Init(driver *ExternalServiceDriverClass)
+}
+
+type RegisterOptionFunc func(options *RegisterOption)
+
+// 注册外部服务的选项
+type RegisterOption struct {
+	policy    Policy
+	baseUrl   string
+	endpoints []Endpoint
+	transport http.RoundTripper
+
+	resolver        InterfaceResolver
+	discoveryOption DiscoveryOption
+}
+
+// 设置外部服务的调用策略，没有设置的字段使用 DefaultPolicy 中的值
+func WithPolicy(policy Policy) RegisterOptionFunc {
+	return func(options *RegisterOption) {
+		options.policy = policy
+	}
+}
 
-	PostJsonForStruct(url string, params map[string]interface{}, struct_ interface{})
-	PostJson(url string, params map[string]interface{}) interface{}
-	GetJsonForStruct(url string, params map[string]interface{}, struct_ interface{})
-	GetJson(url string, params map[string]interface{}) interface{}
+// 嵌入了 BaseExternalServiceClass 的服务
+type interfaceBaseExternalService interface {
+	getBase() *BaseExternalServiceClass
 }
@@ -0,0 +1,122 @@
+package external_service_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	go_core "github.com/pefish/go-core"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	external_service "github.com/pefish/go-core/driver/external-service"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+type otherParam struct {
+	Name string `json:"name"`
+}
+
+// 按声明的接口调用，检查参数类型，业务错误返回 ErrorInfo
+func TestExternalServiceDriverClass_Call(t *testing.T) {
+	type quoteParam struct {
+		Symbol string `json:"symbol" validate:"required"`
+	}
+	type quoteReturn struct {
+		Symbol string  `json:"symbol"`
+		Price  float64 `json:"price"`
+	}
+	upstreamService := go_core.NewStandaloneService(`upstream`)
+	upstreamService.SetPath(`/api`)
+	upstreamService.SetRoutes([]*api.Api{
+		{
+			Path:   `/quote`,
+			Method: api_session.ApiMethod_Post,
+			TypedController: func(apiSession *api_session.ApiSessionClass, params quoteParam) (*quoteReturn, error) {
+				if params.Symbol == `none` {
+					return nil, &go_error.ErrorInfo{ErrorMessage: `symbol not found`, ErrorCode: 3001}
+				}
+				return &quoteReturn{Symbol: params.Symbol, Price: 1.5}, nil
+			},
+		},
+	})
+	upstreamService.GetLoggerDriver().Register(test_client.NewFakeLogger())
+	upstream := httptest.NewServer(upstreamService)
+	defer upstream.Close()
+
+	driver := external_service.NewExternalServiceDriver()
+	driver.Register(`market`, external_service.NewBaseExternalService(), external_service.WithBaseUrl(upstream.URL+`/api/`), external_service.WithEndpoints(external_service.Endpoint{
+		Name:   `quote`,
+		Path:   `/quote`,
+		Params: quoteParam{},
+		Return: quoteReturn{},
+	}))
+
+	quote := quoteReturn{}
+	if err := driver.Call(context.Background(), `market`, `quote`, quoteParam{Symbol: `btc`}, &quote); err != nil || quote.Price != 1.5 {
+		t.Fatalf(`unexpected quote %v, err %v`, quote, err)
+	}
+	err := driver.Call(context.Background(), `market`, `quote`, &quoteParam{Symbol: `none`}, nil)
+	if errorInfo, ok := err.(*go_error.ErrorInfo); !ok || errorInfo.ErrorCode != 3001 || errorInfo.ErrorMessage != `symbol not found` {
+		t.Errorf(`business error should be returned as ErrorInfo, got %v`, err)
+	}
+	if err := driver.Call(context.Background(), `market`, `quote`, map[string]interface{}{`symbol`: `eth`}, &map[string]interface{}{}); err != nil {
+		t.Errorf(`map params should be accepted, got %v`, err)
+	}
+	if err := driver.Call(context.Background(), `market`, `quote`, otherParam{}, nil); err == nil {
+		t.Error(`params of another type should be rejected`)
+	}
+	if err := driver.Call(context.Background(), `market`, `price`, nil, nil); !errors.Is(err, external_service.ErrEndpointNotFound) {
+		t.Errorf(`expect endpoint not found, got %v`, err)
+	}
+	if err := driver.Call(context.Background(), `bank`, `quote`, nil, nil); !errors.Is(err, external_service.ErrServiceNotFound) {
+		t.Errorf(`expect service not found, got %v`, err)
+	}
+
+	services := driver.List()
+	if len(services) != 1 || services[0].BaseUrl != upstream.URL+`/api` || len(services[0].Endpoints) != 1 {
+		t.Fatalf(`unexpected services %+v`, services)
+	}
+	if endpoint := services[0].Endpoints[0]; endpoint.Method != `POST` || endpoint.Params != `external_service_test.quoteParam` || endpoint.Return != `external_service_test.quoteReturn` {
+		t.Errorf(`unexpected endpoint %+v`, endpoint)
+	}
+}
+
+// 超过 2^53 的整数在参数和返回值中都不丢精度
+func TestExternalServiceDriverClass_CallInt64(t *testing.T) {
+	type orderParam struct {
+		Id int64 `json:"id"`
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := []byte(`{"id":` + r.URL.Query().Get(`id`) + `}`)
+		if r.Method == http.MethodPost {
+			data, _ = ioutil.ReadAll(r.Body)
+		}
+		w.Write([]byte(`{"code":0,"msg":"","data":` + string(data) + `}`))
+	}))
+	defer upstream.Close()
+
+	driver := external_service.NewExternalServiceDriver()
+	driver.Register(`order`, external_service.NewBaseExternalService(), external_service.WithBaseUrl(upstream.URL), external_service.WithEndpoints(external_service.Endpoint{
+		Name:   `get`,
+		Path:   `/order`,
+		Method: api_session.ApiMethod_Get,
+		Params: orderParam{},
+		Return: orderParam{},
+	}, external_service.Endpoint{
+		Name:   `create`,
+		Path:   `/order`,
+		Params: orderParam{},
+		Return: orderParam{},
+	}))
+
+	for _, endpointName := range []string{`get`, `create`} {
+		order := orderParam{}
+		if err := driver.Call(context.Background(), `order`, endpointName, orderParam{Id: 1234567890123456789}, &order); err != nil || order.Id != 1234567890123456789 {
+			t.Errorf(`%s: expect id 1234567890123456789, got %d, err %v`, endpointName, order.Id, err)
+		}
+	}
+}
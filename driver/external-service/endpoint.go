@@ -0,0 +1,114 @@
+package external_service
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pefish/go-core/api-session"
+)
+
+var (
+	ErrServiceNotFound  = errors.New(`external service not found`)
+	ErrEndpointNotFound = errors.New(`endpoint not found`)
+)
+
+// 外部服务的接口声明。Params、Return 是参数和返回值（ApiResult 中的 data）的类型样例，用于检查调用时的类型和列出接口
+type Endpoint struct {
+	Name        string                // 调用时使用的名字
+	Path        string                // 拼接在服务的 baseUrl 或者服务发现选择的节点地址后面
+	Method      api_session.ApiMethod // 默认 POST
+	Params      interface{}
+	Return      interface{}
+	Description string
+}
+
+// 外部服务的信息，用于列出注册的服务和接口
+type ServiceInfo struct {
+	Name         string         `json:"name"`
+	BaseUrl      string         `json:"base_url"`
+	BreakerState string         `json:"breaker_state"`
+	Peers        []PeerInfo     `json:"peers,omitempty"` // 使用服务发现的话，各个节点的状态
+	Endpoints    []EndpointInfo `json:"endpoints"`
+}
+
+type EndpointInfo struct {
+	Name        string `json:"name"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Params      string `json:"params"` // 参数类型名
+	Return      string `json:"return"` // 返回值类型名
+	Description string `json:"description"`
+}
+
+func (this Endpoint) getMethod() string {
+	if this.Method == `` {
+		return string(api_session.ApiMethod_Post)
+	}
+	return strings.ToUpper(string(this.Method))
+}
+
+// 以 / 开头，请求时拼接在 baseUrl 或者节点地址后面
+func (this Endpoint) getPath() string {
+	if strings.HasPrefix(this.Path, `/`) {
+		return this.Path
+	}
+	return `/` + this.Path
+}
+
+func (this Endpoint) toInfo() EndpointInfo {
+	return EndpointInfo{
+		Name:        this.Name,
+		Method:      this.getMethod(),
+		Path:        this.Path,
+		Params:      typeName(this.Params),
+		Return:      typeName(this.Return),
+		Description: this.Description,
+	}
+}
+
+// 设置服务的基础地址
+func WithBaseUrl(baseUrl string) RegisterOptionFunc {
+	return func(options *RegisterOption) {
+		options.baseUrl = baseUrl
+	}
+}
+
+// 声明服务的接口，通过 ExternalServiceDriverClass.Call 按名字调用
+func WithEndpoints(endpoints ...Endpoint) RegisterOptionFunc {
+	return func(options *RegisterOption) {
+		options.endpoints = append(options.endpoints, endpoints...)
+	}
+}
+
+// 按接口名排序
+func sortEndpoints(endpoints map[string]Endpoint) []Endpoint {
+	result := make([]Endpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		result = append(result, endpoint)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func typeName(sample interface{}) string {
+	if sample == nil {
+		return ``
+	}
+	return reflect.TypeOf(sample).String()
+}
+
+// 去掉指针后的类型，nil 返回nil
+func indirectType(value interface{}) reflect.Type {
+	if value == nil {
+		return nil
+	}
+	type_ := reflect.TypeOf(value)
+	for type_.Kind() == reflect.Ptr {
+		type_ = type_.Elem()
+	}
+	return type_
+}
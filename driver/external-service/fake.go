@@ -0,0 +1,168 @@
+package external_service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-error"
+)
+
+// 假服务收到的一次调用
+type FakeCall struct {
+	Method string
+	Path   string
+	Params map[string]interface{} // GET 是 query 参数，其他是 json 请求体（数字是 json.Number）
+	Header http.Header
+}
+
+// 假服务上一个接口的响应
+type FakeRouteClass struct {
+	handler func(call *FakeCall) (int, interface{}, error)
+}
+
+// 返回成功结果，data 放在 ApiResult 的 data 中
+func (this *FakeRouteClass) Reply(data interface{}) {
+	this.handler = func(call *FakeCall) (int, interface{}, error) {
+		return http.StatusOK, data, nil
+	}
+}
+
+// 返回业务错误，Call 会得到错误码和消息一致的 *go_error.ErrorInfo
+func (this *FakeRouteClass) ReplyError(code uint64, msg string) {
+	this.handler = func(call *FakeCall) (int, interface{}, error) {
+		return http.StatusOK, nil, &go_error.ErrorInfo{
+			ErrorMessage: msg,
+			ErrorCode:    code,
+		}
+	}
+}
+
+// 返回指定的 http 状态码和空结果，用于模拟对方故障（重试、熔断）
+func (this *FakeRouteClass) ReplyStatus(statusCode int) {
+	this.handler = func(call *FakeCall) (int, interface{}, error) {
+		return statusCode, nil, nil
+	}
+}
+
+// 按调用动态返回。返回 *go_error.ErrorInfo 的话作为业务错误，其他错误作为错误码 1 的业务错误
+func (this *FakeRouteClass) ReplyFunc(fun func(call *FakeCall) (interface{}, error)) {
+	this.handler = func(call *FakeCall) (int, interface{}, error) {
+		data, err := fun(call)
+		return http.StatusOK, data, err
+	}
+}
+
+// 可编程的假 transport，按方法和路径返回设置好的响应，不访问网络。没有设置的接口返回 404
+type FakeTransportClass struct {
+	sync.Mutex
+	routes map[string]*FakeRouteClass
+	calls  []FakeCall
+}
+
+func NewFakeTransport() *FakeTransportClass {
+	return &FakeTransportClass{
+		routes: map[string]*FakeRouteClass{},
+		calls:  []FakeCall{},
+	}
+}
+
+// 设置接口的响应，同一个接口重复设置的话后面的生效
+func (this *FakeTransportClass) On(method api_session.ApiMethod, path string) *FakeRouteClass {
+	this.Lock()
+	defer this.Unlock()
+	route := &FakeRouteClass{}
+	route.Reply(nil)
+	this.routes[fakeRouteKey(string(method), path)] = route
+	return route
+}
+
+// 收到的所有调用，按时间顺序
+func (this *FakeTransportClass) GetCalls() []FakeCall {
+	this.Lock()
+	defer this.Unlock()
+	calls := make([]FakeCall, len(this.calls))
+	copy(calls, this.calls)
+	return calls
+}
+
+// 清空设置的响应和收到的调用
+func (this *FakeTransportClass) Reset() {
+	this.Lock()
+	defer this.Unlock()
+	this.routes = map[string]*FakeRouteClass{}
+	this.calls = []FakeCall{}
+}
+
+func (this *FakeTransportClass) RoundTrip(request *http.Request) (*http.Response, error) {
+	call := FakeCall{
+		Method: request.Method,
+		Path:   request.URL.Path,
+		Params: map[string]interface{}{},
+		Header: request.Header.Clone(),
+	}
+	if request.Method == string(api_session.ApiMethod_Get) {
+		for key, values := range request.URL.Query() {
+			call.Params[key] = values[0]
+		}
+	} else {
+		body, err := readRequestBody(request)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > 0 {
+			if err := unmarshalUseNumber(body, &call.Params); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	this.Lock()
+	this.calls = append(this.calls, call)
+	route, ok := this.routes[fakeRouteKey(call.Method, call.Path)]
+	this.Unlock()
+	if !ok {
+		return newJsonResponse(request, http.StatusNotFound, []byte(`{}`)), nil
+	}
+	statusCode, data, err := route.handler(&call)
+	result := api_session.ApiResult{
+		Data: data,
+	}
+	if err != nil {
+		if errorInfo, ok := err.(*go_error.ErrorInfo); ok {
+			result.Msg = errorInfo.ErrorMessage
+			result.Code = errorInfo.ErrorCode
+			result.Data = errorInfo.Data
+		} else {
+			result.Msg = err.Error()
+			result.Code = 1
+		}
+	}
+	content, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return newJsonResponse(request, statusCode, content), nil
+}
+
+func fakeRouteKey(method string, path string) string {
+	return strings.ToUpper(method) + ` ` + path
+}
+
+// 假的外部服务，测试时以真实服务的名字注册，Call 的请求都由假 transport 处理
+type FakeExternalServiceClass struct {
+	BaseExternalServiceClass
+	*FakeTransportClass
+}
+
+// 新建假的外部服务，baseUrl 默认为 http://fake，可以通过 WithBaseUrl 覆盖
+func NewFakeExternalService() *FakeExternalServiceClass {
+	fake := &FakeExternalServiceClass{
+		FakeTransportClass: NewFakeTransport(),
+	}
+	fake.SetBaseUrl(`http://fake`)
+	fake.SetTransport(fake.FakeTransportClass)
+	return fake
+}
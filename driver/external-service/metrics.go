@@ -0,0 +1,78 @@
+package external_service
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// 外部服务调用的指标（OpenCensus）。需要导出的话注册 DefaultViews：view.Register(external_service.DefaultViews...)
+var (
+	KeyService, _ = tag.NewKey(`external_service`)
+	KeyOutcome, _ = tag.NewKey(`outcome`) // success、error、rejected（熔断拒绝）
+
+	MeasureCalls        = stats.Int64(`external_service/calls`, `Number of external service calls`, stats.UnitDimensionless)
+	MeasureLatency      = stats.Float64(`external_service/latency`, `Latency of external service calls, including retries`, stats.UnitMilliseconds)
+	MeasureRetries      = stats.Int64(`external_service/retries`, `Number of retried external service requests`, stats.UnitDimensionless)
+	MeasureBreakerState = stats.Int64(`external_service/breaker_state`, `Circuit breaker state, 0 closed, 1 open, 2 half-open`, stats.UnitDimensionless)
+)
+
+var (
+	CallCountView = &view.View{
+		Name:        `external_service/calls`,
+		Description: `Count of external service calls by service and outcome`,
+		Measure:     MeasureCalls,
+		TagKeys:     []tag.Key{KeyService, KeyOutcome},
+		Aggregation: view.Count(),
+	}
+	CallLatencyView = &view.View{
+		Name:        `external_service/latency`,
+		Description: `Latency distribution of external service calls`,
+		Measure:     MeasureLatency,
+		TagKeys:     []tag.Key{KeyService},
+		Aggregation: view.Distribution(5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000),
+	}
+	RetryCountView = &view.View{
+		Name:        `external_service/retries`,
+		Description: `Count of retried external service requests`,
+		Measure:     MeasureRetries,
+		TagKeys:     []tag.Key{KeyService},
+		Aggregation: view.Count(),
+	}
+	BreakerStateView = &view.View{
+		Name:        `external_service/breaker_state`,
+		Description: `Current circuit breaker state of external services`,
+		Measure:     MeasureBreakerState,
+		TagKeys:     []tag.Key{KeyService},
+		Aggregation: view.LastValue(),
+	}
+
+	DefaultViews = []*view.View{CallCountView, CallLatencyView, RetryCountView, BreakerStateView}
+)
+
+func recordCall(serviceName string, outcome string, start time.Time) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(KeyService, serviceName), tag.Upsert(KeyOutcome, outcome))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, MeasureCalls.M(1), MeasureLatency.M(float64(time.Since(start))/float64(time.Millisecond)))
+}
+
+func recordRetry(serviceName string) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(KeyService, serviceName))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, MeasureRetries.M(1))
+}
+
+func recordBreakerState(serviceName string, state BreakerState) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(KeyService, serviceName))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, MeasureBreakerState.M(int64(state)))
+}
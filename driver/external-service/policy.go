@@ -0,0 +1,77 @@
+package external_service
+
+import (
+	"math/rand"
+	"time"
+)
+
+// 调用外部服务的策略：超时、重试和熔断
+type Policy struct {
+	ConnectTimeout time.Duration // 建立连接的超时，默认3秒
+	ReadTimeout    time.Duration // 连接建立后等待并读取响应的超时，默认10秒
+	MaxRetries     int           // 失败后最多重试几次，默认0。只重试幂等的调用（GET，或者 RetryPost 为 true 时的 POST）
+	RetryPost      bool          // POST 也是幂等的（如对方支持幂等 key），可以重试
+	RetryBaseDelay time.Duration // 第一次重试前等待的时间，之后每次翻倍，默认100毫秒
+	RetryMaxDelay  time.Duration // 重试等待时间的上限，默认2秒
+	Breaker        BreakerOption
+}
+
+// 熔断器配置。连续失败 FailureThreshold 次后打开，拒绝所有调用；OpenTimeout 后进入半开，
+// 放行 HalfOpenMaxRequests 个探测调用，都成功的话关闭，有一个失败的话重新打开
+type BreakerOption struct {
+	Disable             bool
+	FailureThreshold    int           // 默认5
+	OpenTimeout         time.Duration // 默认30秒
+	HalfOpenMaxRequests int           // 默认1
+}
+
+var DefaultPolicy = Policy{
+	ConnectTimeout: 3 * time.Second,
+	ReadTimeout:    10 * time.Second,
+	RetryBaseDelay: 100 * time.Millisecond,
+	RetryMaxDelay:  2 * time.Second,
+	Breaker: BreakerOption{
+		FailureThreshold:    5,
+		OpenTimeout:         30 * time.Second,
+		HalfOpenMaxRequests: 1,
+	},
+}
+
+// 没有设置的字段使用默认值
+func (this Policy) withDefaults() Policy {
+	if this.ConnectTimeout <= 0 {
+		this.ConnectTimeout = DefaultPolicy.ConnectTimeout
+	}
+	if this.ReadTimeout <= 0 {
+		this.ReadTimeout = DefaultPolicy.ReadTimeout
+	}
+	if this.RetryBaseDelay <= 0 {
+		this.RetryBaseDelay = DefaultPolicy.RetryBaseDelay
+	}
+	if this.RetryMaxDelay <= 0 {
+		this.RetryMaxDelay = DefaultPolicy.RetryMaxDelay
+	}
+	if this.Breaker.FailureThreshold <= 0 {
+		this.Breaker.FailureThreshold = DefaultPolicy.Breaker.FailureThreshold
+	}
+	if this.Breaker.OpenTimeout <= 0 {
+		this.Breaker.OpenTimeout = DefaultPolicy.Breaker.OpenTimeout
+	}
+	if this.Breaker.HalfOpenMaxRequests <= 0 {
+		this.Breaker.HalfOpenMaxRequests = DefaultPolicy.Breaker.HalfOpenMaxRequests
+	}
+	return this
+}
+
+// 第 retry 次重试（从1开始）前等待的时间。指数退避，加上随机抖动（一半固定，一半随机），避免所有实例同时重试
+func (this Policy) retryDelay(retry int) time.Duration {
+	delay := this.RetryBaseDelay
+	for i := 1; i < retry && delay < this.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > this.RetryMaxDelay {
+		delay = this.RetryMaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,97 @@
+package external_service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	go_core "github.com/pefish/go-core"
+	external_service "github.com/pefish/go-core/driver/external-service"
+	test_client "github.com/pefish/go-core/test-client"
+)
+
+type pingServiceClass struct {
+	external_service.BaseExternalServiceClass
+}
+
+// 失败重试、POST 不重试、熔断器打开和半开探测，熔断器状态显示在 /healthz
+func TestBaseExternalServiceClass_Policy(t *testing.T) {
+	var lock sync.Mutex
+	hits, failing := 0, 2
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		hits++
+		if failing != 0 {
+			if failing > 0 {
+				failing--
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"code":0,"msg":"","data":"pong"}`))
+	}))
+	defer upstream.Close()
+	setUpstream := func(newFailing int) {
+		lock.Lock()
+		defer lock.Unlock()
+		hits, failing = 0, newFailing
+	}
+	getHits := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return hits
+	}
+
+	pingService := &pingServiceClass{}
+	driver := external_service.NewExternalServiceDriver()
+	driver.Register(`ping`, pingService, external_service.WithPolicy(external_service.Policy{
+		MaxRetries:     2,
+		RetryBaseDelay: time.Millisecond,
+		Breaker: external_service.BreakerOption{
+			FailureThreshold: 3,
+			OpenTimeout:      50 * time.Millisecond,
+		},
+	}))
+	svc := go_core.NewStandaloneService(`test`)
+	svc.SetExternalServiceDriver(driver)
+	client := test_client.NewTestClient(t, svc)
+
+	// 前两次 503，重试后成功
+	if data := pingService.GetJson(upstream.URL, map[string]interface{}{`a`: 1}); data != `pong` || getHits() != 3 {
+		t.Fatalf(`expect pong after 3 hits, got %v after %d hits`, data, getHits())
+	}
+
+	// POST 不重试
+	setUpstream(1)
+	if _, err := pingService.Request(`POST`, upstream.URL, nil); err == nil || getHits() != 1 {
+		t.Errorf(`post should fail without retry, err %v, hits %d`, err, getHits())
+	}
+
+	// 连续失败，熔断器打开后不再请求
+	setUpstream(-1)
+	if _, err := pingService.Request(`GET`, upstream.URL, nil); err == nil {
+		t.Error(`request should fail`)
+	}
+	if _, err := pingService.Request(`GET`, upstream.URL, nil); err != external_service.ErrBreakerOpen || getHits() != 2 {
+		t.Errorf(`breaker should open after 3 failures, err %v, hits %d`, err, getHits())
+	}
+	if body := client.Get(`/healthz`).Do().AssertStatus(200).GetBody(); body != "ok\nexternal service ping: open" {
+		t.Errorf(`unexpected healthz %q`, body)
+	}
+
+	// 半开状态下探测成功后关闭
+	setUpstream(0)
+	time.Sleep(60 * time.Millisecond)
+	if pingService.GetBreakerState() != external_service.BreakerState_HalfOpen {
+		t.Errorf(`breaker should be half-open, got %s`, pingService.GetBreakerState())
+	}
+	if _, err := pingService.Request(`GET`, upstream.URL, nil); err != nil {
+		t.Errorf(`probe should succeed, got %v`, err)
+	}
+	if pingService.GetBreakerState() != external_service.BreakerState_Closed {
+		t.Errorf(`breaker should be closed, got %s`, pingService.GetBreakerState())
+	}
+}
@@ -0,0 +1,148 @@
+package external_service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 服务发现，返回外部服务各个节点的基础地址（如 http://10.0.0.1:8000/api）
+type InterfaceResolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// 固定的节点列表
+type StaticResolverClass struct {
+	urls []string
+}
+
+func NewStaticResolver(urls ...string) *StaticResolverClass {
+	return &StaticResolverClass{
+		urls: urls,
+	}
+}
+
+func (this *StaticResolverClass) Resolve(ctx context.Context) ([]string, error) {
+	return this.urls, nil
+}
+
+// 从文件读取节点列表，每行一个地址，忽略空行和 # 开头的注释。文件修改时间变化后才重新读取，
+// 配合 DiscoveryOption.RefreshInterval 实现对文件的监听
+type FileResolverClass struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	urls    []string
+}
+
+func NewFileResolver(path string) *FileResolverClass {
+	return &FileResolverClass{
+		path: path,
+	}
+}
+
+func (this *FileResolverClass) Resolve(ctx context.Context) ([]string, error) {
+	this.Lock()
+	defer this.Unlock()
+	info, err := os.Stat(this.path)
+	if err != nil {
+		return nil, err
+	}
+	if this.urls != nil && info.ModTime().Equal(this.modTime) && info.Size() == this.size {
+		return this.urls, nil
+	}
+	content, err := ioutil.ReadFile(this.path)
+	if err != nil {
+		return nil, err
+	}
+	urls := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == `` || strings.HasPrefix(line, `#`) {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	this.urls = urls
+	this.modTime = info.ModTime()
+	this.size = info.Size()
+	return urls, nil
+}
+
+// 通过 DNS SRV 记录发现节点，只使用优先级最高（priority 最小）的一组记录，地址为 scheme://target:port
+type DnsSrvResolverClass struct {
+	service  string
+	proto    string
+	name     string
+	scheme   string
+	path     string
+	resolver *net.Resolver
+}
+
+// 查询 _service._proto.name 的 SRV 记录，service 和 proto 都为空的话直接查询 name
+func NewDnsSrvResolver(service string, proto string, name string) *DnsSrvResolverClass {
+	return &DnsSrvResolverClass{
+		service:  service,
+		proto:    proto,
+		name:     name,
+		scheme:   `http`,
+		resolver: net.DefaultResolver,
+	}
+}
+
+// 节点地址的协议，默认 http
+func (this *DnsSrvResolverClass) SetScheme(scheme string) *DnsSrvResolverClass {
+	this.scheme = scheme
+	return this
+}
+
+// 拼接在节点地址后面的路径，如 /api
+func (this *DnsSrvResolverClass) SetPath(path string) *DnsSrvResolverClass {
+	this.path = strings.TrimRight(path, `/`)
+	return this
+}
+
+// 使用指定的 DNS 解析器，如指向本地 DNS 服务的 net.Resolver
+func (this *DnsSrvResolverClass) SetResolver(resolver *net.Resolver) *DnsSrvResolverClass {
+	this.resolver = resolver
+	return this
+}
+
+func (this *DnsSrvResolverClass) Resolve(ctx context.Context) ([]string, error) {
+	_, records, err := this.resolver.LookupSRV(ctx, this.service, this.proto, this.name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf(`no srv record for %s`, this.name)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		return records[i].Weight > records[j].Weight
+	})
+	urls := []string{}
+	for _, record := range records {
+		if record.Priority != records[0].Priority {
+			break
+		}
+		host := strings.TrimSuffix(record.Target, `.`)
+		urls = append(urls, this.scheme+`://`+net.JoinHostPort(host, strconv.Itoa(int(record.Port)))+this.path)
+	}
+	return urls, nil
+}
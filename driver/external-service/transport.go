@@ -0,0 +1,214 @@
+package external_service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/pefish/go-core/api-session"
+)
+
+// 设置外部服务发送请求的 transport，用于录制、回放和假服务，见 NewRecordingTransport、NewReplayTransport、NewFakeTransport
+func WithTransport(transport http.RoundTripper) RegisterOptionFunc {
+	return func(options *RegisterOption) {
+		options.transport = transport
+	}
+}
+
+// 录制下来的一次请求和响应
+type Interaction struct {
+	Method       string          `json:"method"`
+	Path         string          `json:"path"`
+	Query        string          `json:"query,omitempty"`
+	RequestBody  json.RawMessage `json:"request_body,omitempty"`
+	StatusCode   int             `json:"status_code"`
+	ResponseBody json.RawMessage `json:"response_body"`
+}
+
+type MatchMode int
+
+const (
+	MatchMode_Strict  MatchMode = iota // 方法、路径、query 和请求体（按 json 比较）都要一致
+	MatchMode_Lenient                  // 只比较方法和路径
+)
+
+// 录制模式，把请求转发给真实服务，并把每次的请求和响应追加保存到 fixture 文件（json 数组）。签名等请求头不保存
+type RecordingTransportClass struct {
+	sync.Mutex
+	next         http.RoundTripper
+	fixturePath  string
+	interactions []Interaction
+}
+
+// 新建录制 transport，next 为nil 的话使用 http.DefaultTransport。fixture 文件会被覆盖
+func NewRecordingTransport(fixturePath string, next http.RoundTripper) *RecordingTransportClass {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RecordingTransportClass{
+		next:         next,
+		fixturePath:  fixturePath,
+		interactions: []Interaction{},
+	}
+}
+
+func (this *RecordingTransportClass) RoundTrip(request *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := this.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	this.Lock()
+	defer this.Unlock()
+	this.interactions = append(this.interactions, Interaction{
+		Method:       request.Method,
+		Path:         request.URL.Path,
+		Query:        request.URL.RawQuery,
+		RequestBody:  toRawJson(requestBody),
+		StatusCode:   response.StatusCode,
+		ResponseBody: toRawJson(responseBody),
+	})
+	if err := this.save(); err != nil {
+		return nil, fmt.Errorf(`save fixture error: %v`, err)
+	}
+	return response, nil
+}
+
+// 每次录制后整个文件重写，调用前需要加锁
+func (this *RecordingTransportClass) save() error {
+	content, err := json.MarshalIndent(this.interactions, ``, `  `)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(this.fixturePath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(this.fixturePath, content, 0644)
+}
+
+// 回放模式，从 fixture 文件中找到匹配的请求返回录制的响应，不访问网络。
+// 按录制的顺序优先使用还没有用过的记录，都用过的话重复使用最后匹配的一条
+type ReplayTransportClass struct {
+	sync.Mutex
+	matchMode    MatchMode
+	interactions []Interaction
+	used         []bool
+}
+
+func NewReplayTransport(fixturePath string, matchMode MatchMode) (*ReplayTransportClass, error) {
+	content, err := ioutil.ReadFile(fixturePath)
+	if err != nil {
+		return nil, fmt.Errorf(`read fixture error: %v`, err)
+	}
+	interactions := make([]Interaction, 0)
+	if err := json.Unmarshal(content, &interactions); err != nil {
+		return nil, fmt.Errorf(`decode fixture %s error: %v`, fixturePath, err)
+	}
+	return &ReplayTransportClass{
+		matchMode:    matchMode,
+		interactions: interactions,
+		used:         make([]bool, len(interactions)),
+	}, nil
+}
+
+func (this *ReplayTransportClass) RoundTrip(request *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(request)
+	if err != nil {
+		return nil, err
+	}
+	this.Lock()
+	defer this.Unlock()
+	matched := -1
+	for i, interaction := range this.interactions {
+		if !this.match(interaction, request, requestBody) {
+			continue
+		}
+		matched = i
+		if !this.used[i] {
+			break
+		}
+	}
+	if matched < 0 {
+		return nil, fmt.Errorf(`no fixture matches %s %s`, request.Method, request.URL.RequestURI())
+	}
+	this.used[matched] = true
+	interaction := this.interactions[matched]
+	return newJsonResponse(request, interaction.StatusCode, interaction.ResponseBody), nil
+}
+
+func (this *ReplayTransportClass) match(interaction Interaction, request *http.Request, requestBody []byte) bool {
+	if interaction.Method != request.Method || interaction.Path != request.URL.Path {
+		return false
+	}
+	if this.matchMode == MatchMode_Lenient {
+		return true
+	}
+	return interaction.Query == request.URL.RawQuery && jsonEqual(interaction.RequestBody, requestBody)
+}
+
+// 读取请求体后恢复，让后面的 transport 还可以读取
+func readRequestBody(request *http.Request) ([]byte, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(request.Body)
+	request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// 合法的 json 原样保存，否则保存成 json 字符串
+func toRawJson(content []byte) json.RawMessage {
+	if len(content) == 0 {
+		return nil
+	}
+	if json.Valid(content) {
+		return json.RawMessage(content)
+	}
+	quoted, _ := json.Marshal(string(content))
+	return json.RawMessage(quoted)
+}
+
+func jsonEqual(a []byte, b []byte) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	var valueA, valueB interface{}
+	if json.Unmarshal(a, &valueA) != nil || json.Unmarshal(b, &valueB) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(valueA, valueB)
+}
+
+func newJsonResponse(request *http.Request, statusCode int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf(`%d %s`, statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         `HTTP/1.1`,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{string(api_session.HeaderName_ContentType): []string{string(api_session.ContentTypeValue_JSON)}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
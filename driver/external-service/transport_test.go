@@ -0,0 +1,90 @@
+package external_service_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	go_core "github.com/pefish/go-core"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	external_service "github.com/pefish/go-core/driver/external-service"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+// 录制后严格和宽松回放，以及可编程的假服务
+func TestRecordingTransportClass_Replay(t *testing.T) {
+	type echoParam struct {
+		Name string `json:"name"`
+	}
+	upstreamService := go_core.NewStandaloneService(`upstream`)
+	upstreamService.SetPath(`/api`)
+	upstreamService.SetRoutes([]*api.Api{
+		{
+			Path:   `/echo`,
+			Method: api_session.ApiMethod_Post,
+			TypedController: func(apiSession *api_session.ApiSessionClass, params echoParam) (string, error) {
+				return `hello ` + params.Name, nil
+			},
+		},
+	})
+	upstreamService.GetLoggerDriver().Register(test_client.NewFakeLogger())
+	upstream := httptest.NewServer(upstreamService)
+
+	endpoint := external_service.Endpoint{
+		Name: `echo`,
+		Path: `/echo`,
+	}
+	fixture := filepath.Join(t.TempDir(), `fixtures`, `echo.json`)
+	recorder := external_service.NewRecordingTransport(fixture, nil)
+	driver := external_service.NewExternalServiceDriver()
+	driver.Register(`echo`, external_service.NewBaseExternalService(), external_service.WithBaseUrl(upstream.URL+`/api`), external_service.WithEndpoints(endpoint), external_service.WithTransport(recorder))
+	var reply string
+	if err := driver.Call(context.Background(), `echo`, `echo`, echoParam{Name: `a`}, &reply); err != nil || reply != `hello a` {
+		t.Fatalf(`unexpected reply %s, err %v`, reply, err)
+	}
+	upstream.Close()
+
+	strict, err := external_service.NewReplayTransport(fixture, external_service.MatchMode_Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver.Register(`echo`, external_service.NewBaseExternalService(), external_service.WithBaseUrl(upstream.URL+`/api`), external_service.WithEndpoints(endpoint), external_service.WithTransport(strict))
+	reply = ``
+	if err := driver.Call(context.Background(), `echo`, `echo`, map[string]interface{}{`name`: `a`}, &reply); err != nil || reply != `hello a` {
+		t.Errorf(`strict replay should match the same body, got %s, err %v`, reply, err)
+	}
+	if err := driver.Call(context.Background(), `echo`, `echo`, echoParam{Name: `b`}, &reply); err == nil {
+		t.Error(`strict replay should reject another body`)
+	}
+
+	lenient, err := external_service.NewReplayTransport(fixture, external_service.MatchMode_Lenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver.Register(`echo`, external_service.NewBaseExternalService(), external_service.WithBaseUrl(upstream.URL+`/api`), external_service.WithEndpoints(endpoint), external_service.WithTransport(lenient))
+	if err := driver.Call(context.Background(), `echo`, `echo`, echoParam{Name: `b`}, &reply); err != nil || reply != `hello a` {
+		t.Errorf(`lenient replay should ignore the body, got %s, err %v`, reply, err)
+	}
+
+	fake := external_service.NewFakeExternalService()
+	fake.On(api_session.ApiMethod_Post, `/echo`).ReplyFunc(func(call *external_service.FakeCall) (interface{}, error) {
+		if call.Params[`name`] == `none` {
+			return nil, &go_error.ErrorInfo{ErrorMessage: `no name`, ErrorCode: 3002}
+		}
+		return `fake ` + call.Params[`name`].(string), nil
+	})
+	driver.Register(`echo`, fake, external_service.WithEndpoints(endpoint))
+	if err := driver.Call(context.Background(), `echo`, `echo`, echoParam{Name: `c`}, &reply); err != nil || reply != `fake c` {
+		t.Errorf(`unexpected fake reply %s, err %v`, reply, err)
+	}
+	err = driver.Call(context.Background(), `echo`, `echo`, echoParam{Name: `none`}, nil)
+	if errorInfo, ok := err.(*go_error.ErrorInfo); !ok || errorInfo.ErrorCode != 3002 {
+		t.Errorf(`fake business error should be returned as ErrorInfo, got %v`, err)
+	}
+	if calls := fake.GetCalls(); len(calls) != 2 || calls[0].Path != `/echo` || calls[0].Params[`name`] != `c` {
+		t.Errorf(`unexpected fake calls %+v`, calls)
+	}
+}
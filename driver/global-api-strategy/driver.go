@@ -1,25 +1,193 @@
 package global_api_strategy
 
+import (
+	"fmt"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	"sort"
+	"strings"
+)
 
 type GlobalStrategyData struct {
 	Strategy InterfaceGlobalStrategy
 	Param    interface{}
 	Disable  bool
+	Priority int    // 优先级，越小越先执行，相同优先级按注册顺序执行。默认0
+	Before   string // 排在这个名字的策略之前执行，设置后忽略Priority
+	After    string // 排在这个名字的策略之后执行，设置后忽略Priority
 }
 
 type GlobalApiStrategyDriverClass struct {
-	GlobalStrategies []GlobalStrategyData
+	GlobalStrategies []GlobalStrategyData // 排好序的全局策略，也就是实际的执行顺序
+
+	registered []GlobalStrategyData // 按注册顺序保存
 }
 
 var GlobalApiStrategyDriver = GlobalApiStrategyDriverClass{
 	GlobalStrategies: []GlobalStrategyData{},
 }
 
+// 新建全局策略驱动，每个服务可以持有自己的一份
+func NewGlobalApiStrategyDriver() *GlobalApiStrategyDriverClass {
+	return &GlobalApiStrategyDriverClass{
+		GlobalStrategies: []GlobalStrategyData{},
+	}
+}
+
 func (this *GlobalApiStrategyDriverClass) Startup() {
+	this.sort()
+}
 
+// 检查全局策略配置：锚点是否存在、锚点是否成环、启用的策略参数是否合法。返回所有问题
+func (this *GlobalApiStrategyDriverClass) Check() []error {
+	errs := make([]error, 0)
+	for _, name := range this.sort() {
+		errs = append(errs, this.anchorError(name))
+	}
+	for _, strategyData := range this.GlobalStrategies {
+		if strategyData.Disable {
+			continue
+		}
+		if validator, ok := strategyData.Strategy.(api_strategy.InterfaceParamValidator); ok {
+			if err := validator.Validate(strategyData.Param); err != nil {
+				errs = append(errs, fmt.Errorf(`global strategy %s: %s`, strategyData.Strategy.GetName(), err))
+			}
+		}
+	}
+	return errs
 }
 
+// 注册全局策略。同名策略已经存在的话 panic，需要替换的话使用 Replace
 func (this *GlobalApiStrategyDriverClass) Register(strategyData GlobalStrategyData) bool {
-	this.GlobalStrategies = append(this.GlobalStrategies, strategyData)
+	if this.indexOf(strategyData.Strategy.GetName()) != -1 {
+		panic(fmt.Errorf(`global strategy %s is already registered`, strategyData.Strategy.GetName()))
+	}
+	this.registered = append(this.registered, strategyData)
+	this.sort()
+	return true
+}
+
+// 根据名字获取全局策略
+func (this *GlobalApiStrategyDriverClass) Get(name string) (GlobalStrategyData, bool) {
+	index := this.indexOf(name)
+	if index == -1 {
+		return GlobalStrategyData{}, false
+	}
+	return this.registered[index], true
+}
+
+// 替换指定名字的全局策略，新策略的名字可以不同。不存在的话返回false
+func (this *GlobalApiStrategyDriverClass) Replace(name string, strategyData GlobalStrategyData) bool {
+	index := this.indexOf(name)
+	if index == -1 {
+		return false
+	}
+	newName := strategyData.Strategy.GetName()
+	if newName != name && this.indexOf(newName) != -1 {
+		return false
+	}
+	this.registered[index] = strategyData
+	this.sort()
+	return true
+}
+
+// 注销指定名字的全局策略。不存在的话返回false
+func (this *GlobalApiStrategyDriverClass) Unregister(name string) bool {
+	index := this.indexOf(name)
+	if index == -1 {
+		return false
+	}
+	this.registered = append(this.registered[:index], this.registered[index+1:]...)
+	this.sort()
 	return true
 }
+
+func (this *GlobalApiStrategyDriverClass) indexOf(name string) int {
+	for i, strategyData := range this.registered {
+		if strategyData.Strategy.GetName() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 计算执行顺序。先按优先级排好没有锚点的策略，再把有锚点的策略展开到锚点前后。返回找不到锚点或者锚点成环的策略名
+func (this *GlobalApiStrategyDriverClass) sort() []string {
+	base := make([]GlobalStrategyData, 0, len(this.registered))
+	pending := make([]GlobalStrategyData, 0)
+	for _, strategyData := range this.registered {
+		if strategyData.Before == `` && strategyData.After == `` {
+			base = append(base, strategyData)
+		} else {
+			pending = append(pending, strategyData)
+		}
+	}
+	sort.SliceStable(base, func(i, j int) bool {
+		return base[i].Priority < base[j].Priority
+	})
+
+	placed := make([]bool, len(pending))
+	result := make([]GlobalStrategyData, 0, len(this.registered))
+	for _, strategyData := range base {
+		result = append(result, this.expand(strategyData, pending, placed)...)
+	}
+
+	// 找不到锚点的放到最后
+	unresolved := make([]string, 0)
+	for i, strategyData := range pending {
+		if !placed[i] {
+			result = append(result, strategyData)
+			unresolved = append(unresolved, strategyData.Strategy.GetName())
+		}
+	}
+	this.GlobalStrategies = result
+	return unresolved
+}
+
+// 无法排序的策略的原因：锚点不存在，或者锚点成环（如 a 在 b 前、b 在 a 前）
+func (this *GlobalApiStrategyDriverClass) anchorError(name string) error {
+	chain := []string{name}
+	visited := map[string]bool{name: true}
+	current := name
+	for {
+		strategyData, _ := this.Get(current)
+		anchor := strategyData.Before
+		if anchor == `` {
+			anchor = strategyData.After
+		}
+		if anchor == `` {
+			return fmt.Errorf(`global strategy %s: anchor chain %s ends at a strategy that is not placed`, name, strings.Join(chain, ` -> `))
+		}
+		if _, ok := this.Get(anchor); !ok {
+			return fmt.Errorf(`global strategy %s: anchor %s not found`, name, anchor)
+		}
+		chain = append(chain, anchor)
+		if anchor == name {
+			return fmt.Errorf(`global strategy %s: anchors form a cycle: %s`, name, strings.Join(chain, ` -> `))
+		}
+		if visited[anchor] {
+			return fmt.Errorf(`global strategy %s: anchor %s is in a cycle: %s`, name, chain[1], strings.Join(chain, ` -> `))
+		}
+		visited[anchor] = true
+		current = anchor
+	}
+}
+
+// 把锚定在这个策略上的策略按注册顺序展开到它的前后，锚点可以嵌套
+func (this *GlobalApiStrategyDriverClass) expand(strategyData GlobalStrategyData, pending []GlobalStrategyData, placed []bool) []GlobalStrategyData {
+	name := strategyData.Strategy.GetName()
+	result := make([]GlobalStrategyData, 0)
+	for i, anchored := range pending {
+		if !placed[i] && anchored.Before == name {
+			placed[i] = true
+			result = append(result, this.expand(anchored, pending, placed)...)
+		}
+	}
+	result = append(result, strategyData)
+	for i, anchored := range pending {
+		if !placed[i] && anchored.Before == `` && anchored.After == name {
+			placed[i] = true
+			result = append(result, this.expand(anchored, pending, placed)...)
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package global_api_strategy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	api_session "github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/driver/logger"
+)
+
+type testStrategy struct {
+	name string
+}
+
+func (this *testStrategy) Init(logger logger.InterfaceLogger, param interface{}) {}
+
+func (this *testStrategy) Execute(out *api_session.ApiSessionClass, param interface{}) {}
+
+func (this *testStrategy) GetName() string {
+	return this.name
+}
+
+func (this *testStrategy) GetDescription() string {
+	return this.name
+}
+
+func (this *testStrategy) GetErrorCode() uint64 {
+	return 0
+}
+
+func order(driver *GlobalApiStrategyDriverClass) string {
+	names := make([]string, 0)
+	for _, strategyData := range driver.GlobalStrategies {
+		names = append(names, strategyData.Strategy.GetName())
+	}
+	return strings.Join(names, `,`)
+}
+
+func TestGlobalApiStrategyDriverClass_Register(t *testing.T) {
+	driver := GlobalApiStrategyDriverClass{}
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`a`}})
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`b`}})
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`c`}, After: `d`})
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`d`}, Before: `a`})
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`e`}, Priority: -1})
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`f`}, After: `d`})
+	if result := order(&driver); result != `e,d,c,f,a,b` {
+		t.Errorf(`unexpected order %s`, result)
+	}
+	func() {
+		defer func() {
+			if err := recover(); err == nil || !strings.Contains(fmt.Sprint(err), `a is already registered`) {
+				t.Errorf(`duplicate name should panic, got %v`, err)
+			}
+		}()
+		driver.Register(GlobalStrategyData{Strategy: &testStrategy{`a`}})
+	}()
+
+	driver.Unregister(`d`)
+	if result := order(&driver); result != `e,a,b,c,f` {
+		t.Errorf(`unexpected order %s`, result)
+	}
+	driver.Replace(`b`, GlobalStrategyData{Strategy: &testStrategy{`g`}, Priority: -2})
+	if result := order(&driver); result != `g,e,a,c,f` {
+		t.Errorf(`unexpected order %s`, result)
+	}
+	if _, ok := driver.Get(`b`); ok {
+		t.Error(`b should be replaced`)
+	}
+}
+
+func TestGlobalApiStrategyDriverClass_Check(t *testing.T) {
+	driver := GlobalApiStrategyDriverClass{}
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`a`}})
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`b`}, Before: `c`})
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`c`}, Before: `b`})
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`d`}, After: `b`})
+	driver.Register(GlobalStrategyData{Strategy: &testStrategy{`e`}, After: `x`})
+	errs := driver.Check()
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	expected := []string{
+		`global strategy b: anchors form a cycle: b -> c -> b`,
+		`global strategy c: anchors form a cycle: c -> b -> c`,
+		`global strategy d: anchor b is in a cycle: d -> b -> c -> b`,
+		`global strategy e: anchor x not found`,
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf(`unexpected problems:\n%s`, strings.Join(messages, "\n"))
+	}
+}
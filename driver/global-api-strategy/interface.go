@@ -2,9 +2,10 @@ package global_api_strategy
 
 import (
 	api_strategy "github.com/pefish/go-core/api-strategy"
+	"github.com/pefish/go-core/driver/logger"
 )
 
 type InterfaceGlobalStrategy interface {
-	Init(param interface{})  // 同步的初始化函数
+	Init(logger logger.InterfaceLogger, param interface{}) // 同步的初始化函数，logger 是所属服务的日志器
 	api_strategy.InterfaceStrategy
 }
@@ -6,6 +6,11 @@ type LoggerDriverClass struct {
 
 var LoggerDriver = LoggerDriverClass{}
 
+// 新建日志驱动，每个服务可以持有自己的一份
+func NewLoggerDriver() *LoggerDriverClass {
+	return &LoggerDriverClass{}
+}
+
 func (this *LoggerDriverClass) Startup() {
 
 }
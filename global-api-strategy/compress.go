@@ -0,0 +1,419 @@
+// 响应压缩（gzip、deflate），以及请求体解压
+package global_api_strategy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/driver/logger"
+	"github.com/pefish/go-error"
+)
+
+const (
+	GZIP_ENCODING    = `gzip`
+	DEFLATE_ENCODING = `deflate`
+)
+
+// 默认压缩这些类型的响应
+var DefaultCompressContentTypes = []string{
+	`application/json`,
+	`application/xml`,
+	`application/x-yaml`,
+	`application/javascript`,
+	`text/*`,
+}
+
+type CompressStrategyClass struct {
+	errorCode uint64
+}
+
+var CompressStrategy = CompressStrategyClass{}
+
+// 新建压缩策略。需要在读取请求体的策略（如参数校验）之前执行，注册时可以设置 Before 为参数校验策略的名字
+func NewCompressStrategy() *CompressStrategyClass {
+	return &CompressStrategyClass{}
+}
+
+func (this *CompressStrategyClass) GetName() string {
+	return `compress`
+}
+
+func (this *CompressStrategyClass) GetDescription() string {
+	return `compress response and decompress request body`
+}
+
+func (this *CompressStrategyClass) SetErrorCode(code uint64) {
+	this.errorCode = code
+}
+
+func (this *CompressStrategyClass) GetErrorCode() uint64 {
+	if this.errorCode == 0 {
+		return go_error.INTERNAL_ERROR_CODE
+	}
+	return this.errorCode
+}
+
+func (this *CompressStrategyClass) Init(logger logger.InterfaceLogger, param interface{}) {
+	logger.DebugF(`api-strategy %s Init`, this.GetName())
+	defer logger.DebugF(`api-strategy %s Init defer`, this.GetName())
+}
+
+// 参数可以为nil，使用默认值
+type CompressStrategyParam struct {
+	MinSize             int      // 响应体达到这个大小才压缩，默认 1024
+	ContentTypes        []string // 压缩这些类型的响应，支持 text/* 通配，默认 DefaultCompressContentTypes
+	Level               int      // 压缩级别 1-9，默认 gzip.DefaultCompression
+	DisableDecompress   bool     // 不解压 Content-Encoding 为 gzip、deflate 的请求体
+	MaxDecompressedSize int64    // 请求体解压后的大小上限，默认 32M
+}
+
+func (this *CompressStrategyClass) Validate(param interface{}) error {
+	if param == nil {
+		return nil
+	}
+	newParam, ok := param.(CompressStrategyParam)
+	if !ok {
+		return fmt.Errorf(`param of %s must be CompressStrategyParam, got %T`, this.GetName(), param)
+	}
+	if newParam.Level != 0 && (newParam.Level < gzip.BestSpeed || newParam.Level > gzip.BestCompression) {
+		return fmt.Errorf(`param of %s: Level must be between %d and %d`, this.GetName(), gzip.BestSpeed, gzip.BestCompression)
+	}
+	if newParam.MinSize < 0 || newParam.MaxDecompressedSize < 0 {
+		return fmt.Errorf(`param of %s: MinSize and MaxDecompressedSize can not be negative`, this.GetName())
+	}
+	return nil
+}
+
+func (this *CompressStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+
+	newParam := CompressStrategyParam{}
+	if param != nil {
+		newParam = param.(CompressStrategyParam)
+	}
+	if newParam.MinSize == 0 {
+		newParam.MinSize = 1024
+	}
+	if len(newParam.ContentTypes) == 0 {
+		newParam.ContentTypes = DefaultCompressContentTypes
+	}
+	if newParam.Level == 0 {
+		newParam.Level = gzip.DefaultCompression
+	}
+	if newParam.MaxDecompressedSize == 0 {
+		newParam.MaxDecompressedSize = 32 << 20
+	}
+
+	if !newParam.DisableDecompress {
+		this.decompressRequest(out, newParam.MaxDecompressedSize)
+	}
+
+	if strings.Contains(strings.ToLower(out.GetHeader(string(api_session.HeaderName_Connection))), `upgrade`) { // websocket 等升级协议的请求不处理
+		return
+	}
+	out.ResponseWriter.Header().Add(string(api_session.HeaderName_Vary), string(api_session.HeaderName_AcceptEncoding))
+	encoding := negotiateEncoding(out.GetHeader(string(api_session.HeaderName_AcceptEncoding)))
+	if encoding == `` {
+		return
+	}
+	writer := &compressResponseWriter{
+		ResponseWriter: out.ResponseWriter,
+		encoding:       encoding,
+		param:          newParam,
+		statusCode:     http.StatusOK,
+	}
+	out.ResponseWriter = writer
+	out.AddDefer(func() {
+		if err := writer.Close(); err != nil {
+			out.Logger.ErrorF(`api-strategy %s close writer error: %s`, this.GetName(), err)
+		}
+	})
+}
+
+func (this *CompressStrategyClass) decompressRequest(out *api_session.ApiSessionClass, maxSize int64) {
+	contentEncoding := strings.ToLower(strings.TrimSpace(out.GetHeader(string(api_session.HeaderName_ContentEncoding))))
+	if contentEncoding == `` || contentEncoding == `identity` || out.Request.Body == nil {
+		return
+	}
+	var reader io.ReadCloser
+	switch contentEncoding {
+	case GZIP_ENCODING:
+		gzipReader, err := gzip.NewReader(out.Request.Body)
+		if err != nil {
+			go_error.ThrowError(`decompress request body error`, this.GetErrorCode(), err)
+		}
+		reader = gzipReader
+	case DEFLATE_ENCODING:
+		reader = flate.NewReader(out.Request.Body)
+	default:
+		go_error.Throw(`content-encoding not be supported`, this.GetErrorCode())
+	}
+	out.Request.Body = &decompressReader{
+		reader:    reader,
+		body:      out.Request.Body,
+		remaining: maxSize,
+	}
+	out.Request.Header.Del(string(api_session.HeaderName_ContentEncoding))
+	out.Request.Header.Del(string(api_session.HeaderName_ContentLength))
+	out.Request.ContentLength = -1
+}
+
+var errDecompressedTooLarge = errors.New(`decompressed request body too large`)
+
+type decompressReader struct {
+	reader    io.ReadCloser
+	body      io.ReadCloser
+	remaining int64
+}
+
+func (this *decompressReader) Read(p []byte) (int, error) {
+	if this.remaining <= 0 {
+		return 0, errDecompressedTooLarge
+	}
+	if int64(len(p)) > this.remaining {
+		p = p[:this.remaining+1] // 多读一个字节，用来判断是否超出
+	}
+	n, err := this.reader.Read(p)
+	this.remaining -= int64(n)
+	if this.remaining < 0 {
+		return n, errDecompressedTooLarge
+	}
+	return n, err
+}
+
+func (this *decompressReader) Close() error {
+	this.reader.Close()
+	return this.body.Close()
+}
+
+// 根据 Accept-Encoding 选择压缩方式，q 值相同时优先 gzip。不接受压缩的话返回空
+func negotiateEncoding(acceptEncoding string) string {
+	type candidate struct {
+		encoding string
+		q        float64
+	}
+	candidates := make([]candidate, 0)
+	rejected := map[string]bool{}
+	for _, item := range strings.Split(acceptEncoding, `,`) {
+		parts := strings.Split(item, `;`)
+		encoding := strings.ToLower(strings.TrimSpace(parts[0]))
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, `q=`) {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q <= 0 {
+			rejected[encoding] = true
+			continue
+		}
+		if encoding == `*` {
+			candidates = append(candidates, candidate{GZIP_ENCODING, q}, candidate{DEFLATE_ENCODING, q})
+		} else if encoding == GZIP_ENCODING || encoding == DEFLATE_ENCODING {
+			candidates = append(candidates, candidate{encoding, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].encoding == GZIP_ENCODING && candidates[j].encoding != GZIP_ENCODING
+	})
+	for _, c := range candidates {
+		if !rejected[c.encoding] {
+			return c.encoding
+		}
+	}
+	return ``
+}
+
+var compressWriterPools sync.Map // encoding:level -> *sync.Pool
+
+func getCompressWriter(encoding string, level int, w io.Writer) io.WriteCloser {
+	key := fmt.Sprintf(`%s:%d`, encoding, level)
+	pool, _ := compressWriterPools.LoadOrStore(key, &sync.Pool{})
+	if writer := pool.(*sync.Pool).Get(); writer != nil {
+		switch compressor := writer.(type) {
+		case *gzip.Writer:
+			compressor.Reset(w)
+			return compressor
+		case *flate.Writer:
+			compressor.Reset(w)
+			return compressor
+		}
+	}
+	if encoding == GZIP_ENCODING {
+		writer, _ := gzip.NewWriterLevel(w, level) // 级别已经校验过
+		return writer
+	}
+	writer, _ := flate.NewWriter(w, level)
+	return writer
+}
+
+func putCompressWriter(encoding string, level int, writer io.WriteCloser) {
+	pool, _ := compressWriterPools.LoadOrStore(fmt.Sprintf(`%s:%d`, encoding, level), &sync.Pool{})
+	pool.(*sync.Pool).Put(writer)
+}
+
+/*
+*
+压缩响应的 ResponseWriter。先缓存响应体，达到 MinSize 后才决定是否压缩：
+类型不在列表中、已经设置了 Content-Encoding、没有响应体的状态码都不压缩；
+在达到 MinSize 前调用 Flush（流式响应）的话不再压缩
+*/
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	param    CompressStrategyParam
+
+	statusCode  int
+	buffer      []byte
+	decided     bool
+	compressor  io.WriteCloser
+	wroteHeader bool
+	started     bool // 调用过 WriteHeader 或 Write
+}
+
+func (this *compressResponseWriter) WriteHeader(statusCode int) {
+	this.started = true
+	if this.decided {
+		this.writeHeader()
+		return
+	}
+	this.statusCode = statusCode
+}
+
+func (this *compressResponseWriter) Write(data []byte) (int, error) {
+	this.started = true
+	if !this.decided {
+		this.buffer = append(this.buffer, data...)
+		if len(this.buffer) < this.param.MinSize {
+			return len(data), nil
+		}
+		if err := this.decide(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	this.writeHeader()
+	if this.compressor != nil {
+		return this.compressor.Write(data)
+	}
+	return this.ResponseWriter.Write(data)
+}
+
+// 是否已经开始写入响应（包括还在缓存中的内容）
+func (this *compressResponseWriter) IsWritten() bool {
+	return this.started || this.decided
+}
+
+// 决定是否压缩并写出缓存的内容
+func (this *compressResponseWriter) decide(allowCompress bool) error {
+	this.decided = true
+	if allowCompress && this.shouldCompress() {
+		header := this.ResponseWriter.Header()
+		header.Set(string(api_session.HeaderName_ContentEncoding), this.encoding)
+		header.Del(string(api_session.HeaderName_ContentLength))
+		if etag := header.Get(string(api_session.HeaderName_ETag)); strings.HasPrefix(etag, `"`) { // 压缩后的内容和原始内容字节不同，强 ETag 改为弱 ETag
+			header.Set(string(api_session.HeaderName_ETag), `W/`+etag)
+		}
+		this.compressor = getCompressWriter(this.encoding, this.param.Level, this.ResponseWriter)
+	}
+	this.writeHeader()
+	buffer := this.buffer
+	this.buffer = nil
+	if len(buffer) == 0 {
+		return nil
+	}
+	if this.compressor != nil {
+		_, err := this.compressor.Write(buffer)
+		return err
+	}
+	_, err := this.ResponseWriter.Write(buffer)
+	return err
+}
+
+func (this *compressResponseWriter) shouldCompress() bool {
+	if this.statusCode < http.StatusOK || this.statusCode == http.StatusNoContent || this.statusCode == http.StatusNotModified {
+		return false
+	}
+	header := this.ResponseWriter.Header()
+	if header.Get(string(api_session.HeaderName_ContentEncoding)) != `` {
+		return false
+	}
+	// 支持 Range 的响应（如文件下载）不压缩，否则 Content-Range 按原始内容计算的字节范围对不上压缩后的内容
+	if this.statusCode == http.StatusPartialContent || header.Get(string(api_session.HeaderName_ContentRange)) != `` || header.Get(string(api_session.HeaderName_AcceptRanges)) != `` {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get(string(api_session.HeaderName_ContentType)))
+	if err != nil || mediaType == `text/event-stream` {
+		return false
+	}
+	for _, pattern := range this.param.ContentTypes {
+		if pattern == mediaType || (strings.HasSuffix(pattern, `/*`) && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, `*`))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *compressResponseWriter) writeHeader() {
+	if this.wroteHeader {
+		return
+	}
+	this.wroteHeader = true
+	this.ResponseWriter.WriteHeader(this.statusCode)
+}
+
+func (this *compressResponseWriter) Flush() {
+	if !this.decided {
+		this.decide(false)
+	}
+	if flusher, ok := this.compressor.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (this *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := this.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New(`response writer does not implement http.Hijacker`)
+	}
+	this.decided = true
+	return hijacker.Hijack()
+}
+
+// 写出还在缓存中的内容（小于 MinSize 的不压缩），结束压缩并归还压缩器
+func (this *compressResponseWriter) Close() error {
+	if !this.decided {
+		if len(this.buffer) == 0 && !this.wroteHeader && this.statusCode == http.StatusOK {
+			return nil // 什么都没写
+		}
+		return this.decide(false)
+	}
+	if this.compressor == nil {
+		return nil
+	}
+	err := this.compressor.Close()
+	putCompressWriter(this.encoding, this.param.Level, this.compressor)
+	this.compressor = nil
+	return err
+}
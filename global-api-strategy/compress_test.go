@@ -0,0 +1,171 @@
+package global_api_strategy_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy_driver "github.com/pefish/go-core/driver/global-api-strategy"
+	global_api_strategy "github.com/pefish/go-core/global-api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+// 开启压缩时控制器的错误结果也要完整返回
+func TestCompressStrategyClass_ControllerError(t *testing.T) {
+	longMsg := strings.Repeat(`go-core `, 50)
+	svc, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/fail`,
+			Method: api_session.ApiMethod_Get,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				go_error.Throw(`fail`, 3000)
+				return nil
+			},
+		},
+		{
+			Path:   `/fail-long`,
+			Method: api_session.ApiMethod_Get,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				go_error.Throw(longMsg, 3001)
+				return nil
+			},
+		},
+	})
+	svc.GetGlobalApiStrategyDriver().Register(api_strategy_driver.GlobalStrategyData{
+		Strategy: global_api_strategy.NewCompressStrategy(),
+		Param: global_api_strategy.CompressStrategyParam{
+			MinSize: 100,
+		},
+		Before: global_api_strategy.NewParamValidateStrategy().GetName(),
+	})
+
+	client.Get(`/fail`).WithHeader(`Accept-Encoding`, `gzip`).Do().AssertStatus(200).AssertCode(3000).AssertMsg(`fail`)
+
+	response := client.Get(`/fail-long`).WithHeader(`Accept-Encoding`, `gzip`).Do().AssertStatus(200)
+	if response.GetHeader(`Content-Encoding`) != `gzip` {
+		t.Fatalf(`expect gzip response, got headers %v`, response.Recorder.Header())
+	}
+	reader, err := gzip.NewReader(response.Recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil || !strings.Contains(string(body), `3001`) || !strings.Contains(string(body), longMsg) {
+		t.Errorf(`unexpected body %s, err %v`, body, err)
+	}
+}
+
+type echoParam struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// 按 Accept-Encoding 压缩超过阈值的响应，解压 gzip 请求体
+func TestCompressStrategyClass_Gzip(t *testing.T) {
+	svc, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/echo`,
+			Method: api_session.ApiMethod_Post,
+			Params: echoParam{},
+			TypedController: func(apiSession *api_session.ApiSessionClass, params echoParam) (*echoParam, error) {
+				return &params, nil
+			},
+		},
+	})
+	svc.GetGlobalApiStrategyDriver().Register(api_strategy_driver.GlobalStrategyData{
+		Strategy: global_api_strategy.NewCompressStrategy(),
+		Param: global_api_strategy.CompressStrategyParam{
+			MinSize: 100,
+		},
+		Before: global_api_strategy.NewParamValidateStrategy().GetName(),
+	})
+	longName := strings.Repeat(`go-core `, 50)
+
+	response := client.Post(`/echo`).WithHeader(`Accept-Encoding`, `deflate;q=0.5, gzip`).WithJson(map[string]interface{}{`name`: longName}).Do().AssertStatus(200)
+	if response.GetHeader(`Content-Encoding`) != `gzip` || !strings.Contains(strings.Join(response.Recorder.Header()[`Vary`], `,`), `Accept-Encoding`) {
+		t.Fatalf(`expect gzip response, got headers %v`, response.Recorder.Header())
+	}
+	reader, err := gzip.NewReader(response.Recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil || !strings.Contains(string(body), longName) {
+		t.Errorf(`unexpected body %s, err %v`, body, err)
+	}
+
+	// 小于阈值不压缩
+	response = client.Post(`/echo`).WithHeader(`Accept-Encoding`, `gzip`).WithJson(map[string]interface{}{`name`: `a`}).Do().AssertCode(0)
+	if response.GetHeader(`Content-Encoding`) != `` {
+		t.Errorf(`small response should not be compressed`)
+	}
+
+	// gzip 压缩的请求体
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	writer.Write([]byte(`{"name":"gzipped"}`))
+	writer.Close()
+	result := echoParam{}
+	client.Post(`/echo`).WithHeader(`Content-Type`, `application/json`).WithHeader(`Content-Encoding`, `gzip`).WithBody(compressed.Bytes()).Do().AssertCode(0).ScanData(&result)
+	if result.Name != `gzipped` {
+		t.Errorf(`unexpected name %s`, result.Name)
+	}
+}
+
+// 支持 Range 的文件下载不压缩，压缩后的响应使用弱 ETag
+func TestCompressStrategyClass_RangeAndETag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), `report.txt`)
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat(`0123456789`, 1200)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	svc, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/download`,
+			Method: api_session.ApiMethod_Get,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				if err := apiSession.WriteFile(path, ``); err != nil {
+					go_error.ThrowInternal(err.Error())
+				}
+				return nil
+			},
+		},
+		{
+			Path:   `/tagged`,
+			Method: api_session.ApiMethod_Get,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				apiSession.SetHeader(string(api_session.HeaderName_ETag), `"v1"`)
+				return strings.Repeat(`go-core `, 50)
+			},
+		},
+	})
+	svc.GetGlobalApiStrategyDriver().Register(api_strategy_driver.GlobalStrategyData{
+		Strategy: global_api_strategy.NewCompressStrategy(),
+		Param: global_api_strategy.CompressStrategyParam{
+			MinSize: 100,
+		},
+		Before: global_api_strategy.NewParamValidateStrategy().GetName(),
+	})
+
+	response := client.Get(`/download`).WithHeader(`Accept-Encoding`, `gzip`).WithHeader(`Range`, `bytes=0-4999`).Do().AssertStatus(206)
+	if response.GetHeader(`Content-Encoding`) != `` || response.GetHeader(`Content-Range`) != `bytes 0-4999/12000` || len(response.GetBody()) != 5000 {
+		t.Errorf(`range response should not be compressed, got headers %v, body length %d`, response.Recorder.Header(), len(response.GetBody()))
+	}
+	response = client.Get(`/download`).WithHeader(`Accept-Encoding`, `gzip`).Do().AssertStatus(200)
+	if response.GetHeader(`Content-Encoding`) != `` || len(response.GetBody()) != 12000 {
+		t.Errorf(`download supporting range should not be compressed, got headers %v`, response.Recorder.Header())
+	}
+
+	response = client.Get(`/tagged`).WithHeader(`Accept-Encoding`, `gzip`).Do().AssertStatus(200)
+	if response.GetHeader(`Content-Encoding`) != `gzip` || response.GetHeader(`ETag`) != `W/"v1"` {
+		t.Errorf(`compressed response should have a weak etag, got headers %v`, response.Recorder.Header())
+	}
+	response = client.Get(`/tagged`).Do().AssertStatus(200)
+	if response.GetHeader(`ETag`) != `"v1"` {
+		t.Errorf(`identity response should keep the strong etag, got %s`, response.GetHeader(`ETag`))
+	}
+}
@@ -2,6 +2,7 @@
 package global_api_strategy
 
 import (
+	"fmt"
 	go_application "github.com/pefish/go-application"
 	"github.com/pefish/go-core/api-session"
 	"github.com/pefish/go-core/driver/logger"
@@ -18,6 +19,13 @@ var GlobalRateLimitStrategy = GlobalRateLimitStrategyClass{
 	tokenBucket: make(chan struct{}, 200),
 }
 
+// 新建全局限流策略，令牌桶与其他实例相互独立
+func NewGlobalRateLimitStrategy() *GlobalRateLimitStrategyClass {
+	return &GlobalRateLimitStrategyClass{
+		tokenBucket: make(chan struct{}, 200),
+	}
+}
+
 func (this *GlobalRateLimitStrategyClass) GetName() string {
 	return `GlobalRateLimit`
 }
@@ -38,9 +46,9 @@ func (this *GlobalRateLimitStrategyClass) GetErrorCode() uint64 {
 }
 
 
-func (this *GlobalRateLimitStrategyClass) Init(param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s Init`, this.GetName())
-	defer logger.LoggerDriver.Logger.DebugF(`api-strategy %s Init defer`, this.GetName())
+func (this *GlobalRateLimitStrategyClass) Init(logger logger.InterfaceLogger, param interface{}) {
+	logger.DebugF(`api-strategy %s Init`, this.GetName())
+	defer logger.DebugF(`api-strategy %s Init defer`, this.GetName())
 
 	go func() {
 		params := param.(GlobalRateLimitStrategyParam)
@@ -63,16 +71,27 @@ type GlobalRateLimitStrategyParam struct {
 	FillInterval time.Duration
 }
 
+func (this *GlobalRateLimitStrategyClass) Validate(param interface{}) error {
+	newParam, ok := param.(GlobalRateLimitStrategyParam)
+	if !ok {
+		return fmt.Errorf(`param of %s must be GlobalRateLimitStrategyParam, got %T`, this.GetName(), param)
+	}
+	if newParam.FillInterval <= 0 {
+		return fmt.Errorf(`param of %s: FillInterval must be greater than 0`, this.GetName())
+	}
+	return nil
+}
+
 func (this *GlobalRateLimitStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
 
-	succ := this.takeAvailable(false)
+	succ := this.takeAvailable(out.Logger, false)
 	if !succ {
 		go_error.ThrowInternal(`global rate limit`)
 	}
 }
 
-func (this *GlobalRateLimitStrategyClass) takeAvailable(block bool) bool{
+func (this *GlobalRateLimitStrategyClass) takeAvailable(logger logger.InterfaceLogger, block bool) bool {
 	var takenResult bool
 	if block {
 		select {
@@ -87,6 +106,6 @@ func (this *GlobalRateLimitStrategyClass) takeAvailable(block bool) bool{
 			takenResult = false
 		}
 	}
-	logger.LoggerDriver.Logger.DebugF("current global rate limit token count: %d", len(this.tokenBucket))
+	logger.DebugF("current global rate limit token count: %d", len(this.tokenBucket))
 	return takenResult
 }
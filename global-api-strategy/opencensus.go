@@ -2,6 +2,7 @@ package global_api_strategy
 
 import (
 	"context"
+	"fmt"
 	"contrib.go.opencensus.io/exporter/stackdriver"
 	go_application "github.com/pefish/go-application"
 	"github.com/pefish/go-core/api-session"
@@ -26,6 +27,10 @@ type OpenCensusClass struct {
 
 var OpenCensusStrategy = OpenCensusClass{}
 
+func NewOpenCensusStrategy() *OpenCensusClass {
+	return &OpenCensusClass{}
+}
+
 func (this *OpenCensusClass) GetName() string {
 	return `OpenCensus`
 }
@@ -51,9 +56,9 @@ type OpenCensusStrategyParam struct {
 	EnableStats       bool
 }
 
-func (this *OpenCensusClass) Init(param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s Init`, this.GetName())
-	defer logger.LoggerDriver.Logger.DebugF(`api-strategy %s Init defer`, this.GetName())
+func (this *OpenCensusClass) Init(logger logger.InterfaceLogger, param interface{}) {
+	logger.DebugF(`api-strategy %s Init`, this.GetName())
+	defer logger.DebugF(`api-strategy %s Init defer`, this.GetName())
 	if param == nil {
 		go_error.Throw(`OpenCensusStrategyParam must be set`, this.GetErrorCode())
 	}
@@ -89,11 +94,18 @@ func (this *OpenCensusClass) Init(param interface{}) {
 	}()
 }
 
+func (this *OpenCensusClass) Validate(param interface{}) error {
+	if _, ok := param.(OpenCensusStrategyParam); !ok {
+		return fmt.Errorf(`param of %s must be OpenCensusStrategyParam, got %T`, this.GetName(), param)
+	}
+	return nil
+}
+
 func (this *OpenCensusClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
 	defer func() {
 		if err := recover(); err != nil {
-			logger.LoggerDriver.Logger.Error(err)
+			out.Logger.Error(err)
 		}
 	}()
 	newParam := param.(OpenCensusStrategyParam)
@@ -104,6 +116,7 @@ func (this *OpenCensusClass) Execute(out *api_session.ApiSessionClass, param int
 			traceEnd()
 		})
 		r = r1
+		out.Request = r1 // 控制器通过 apiSession.Request.Context() 拿到 trace，调用外部服务时传递下去
 	}
 	if newParam.EnableStats {
 		var tags addedTags
@@ -1,15 +1,19 @@
 package global_api_strategy
 
 import (
+	"fmt"
 	"github.com/pefish/go-core/api"
 	"github.com/pefish/go-core/api-session"
 	"github.com/pefish/go-core/driver/logger"
+	"github.com/pefish/go-core/upload"
 	"github.com/pefish/go-core/util"
 	"github.com/pefish/go-core/validator"
 	"github.com/pefish/go-desensitize"
 	"github.com/pefish/go-error"
 	"github.com/pefish/go-json"
 	"github.com/pefish/go-string"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 )
@@ -18,16 +22,29 @@ const (
 	ALL_TYPE       = ``
 	MULTIPART_TYPE = `multipart/form-data`
 	JSON_TYPE      = `application/json`
+	FORM_TYPE      = `application/x-www-form-urlencoded`
 	TEXT_TYPE      = `text/plain`
 )
 
 // 默认自带
 type ParamValidateStrategyClass struct {
-	errorCode uint64
+	errorCode     uint64
+	decoder       *api_session.ParamDecoderClass // 控制器 ScanParams 使用的解码器，出错时使用本策略的错误码
+	uploadStorage upload.InterfaceStorage        // 路由没有指定存储后端时，上传的文件保存到这里
 }
 
 var ParamValidateStrategy = ParamValidateStrategyClass{
-	errorCode: go_error.INTERNAL_ERROR_CODE,
+	errorCode:     go_error.INTERNAL_ERROR_CODE,
+	decoder:       api_session.NewParamDecoder(),
+	uploadStorage: upload.NewLocalStorage(filepath.Join(os.TempDir(), `go-core-upload`)),
+}
+
+func NewParamValidateStrategy() *ParamValidateStrategyClass {
+	return &ParamValidateStrategyClass{
+		errorCode:     go_error.INTERNAL_ERROR_CODE,
+		decoder:       api_session.NewParamDecoder(),
+		uploadStorage: upload.NewLocalStorage(filepath.Join(os.TempDir(), `go-core-upload`)),
+	}
 }
 
 func (this *ParamValidateStrategyClass) GetName() string {
@@ -40,6 +57,22 @@ func (this *ParamValidateStrategyClass) GetDescription() string {
 
 func (this *ParamValidateStrategyClass) SetErrorCode(code uint64) {
 	this.errorCode = code
+	this.decoder.SetErrorCode(code)
+}
+
+// 获取参数解码器，可以注册类型转换函数、开启严格模式
+func (this *ParamValidateStrategyClass) GetDecoder() *api_session.ParamDecoderClass {
+	return this.decoder
+}
+
+// 设置上传文件的默认存储后端，默认保存在系统临时目录下。
+// 只保存 Params 中声明的文件字段。请求结束后文件会被删除，需要保留的话控制器调用 upload.FileClass 的 Keep
+func (this *ParamValidateStrategyClass) SetUploadStorage(storage upload.InterfaceStorage) {
+	this.uploadStorage = storage
+}
+
+func (this *ParamValidateStrategyClass) GetUploadStorage() upload.InterfaceStorage {
+	return this.uploadStorage
 }
 
 func (this *ParamValidateStrategyClass) GetErrorCode() uint64 {
@@ -72,8 +105,16 @@ func (this *ParamValidateStrategyClass) recurValidate(out *api_session.ApiSessio
 		typeFieldType := typeField.Type
 		fieldKind := typeFieldType.Kind()
 		fieldValue := value_.Field(i)
-		if fieldKind == reflect.Struct {
+		if fieldKind == reflect.Struct && !upload.IsFileType(typeFieldType) && !this.decoder.IsValueType(typeFieldType) { // 结构体字段展开校验，time.Time、decimal 等按单个值解码的类型作为普通字段
 			this.recurValidate(out, myValidator, map_, globalValidator, typeFieldType, fieldValue)
+		} else if upload.IsFileType(typeFieldType) { // 文件字段只支持文件相关的校验规则
+			tagVal := typeField.Tag.Get(`validate`)
+			fieldName := strings.Split(typeField.Tag.Get(`json`), `,`)[0]
+			if err := myValidator.ValidateFiles(out.Files[fieldName], tagVal); err != nil {
+				go_error.ThrowErrorWithData(err.Error()+`; `+tagVal, this.errorCode, map[string]interface{}{
+					`field`: fieldName,
+				}, err)
+			}
 		} else {
 			tagVal := typeField.Tag.Get(`validate`)
 			newTag := tagVal
@@ -112,9 +153,9 @@ func (this *ParamValidateStrategyClass) recurValidate(out *api_session.ApiSessio
 	}
 }
 
-func (this *ParamValidateStrategyClass) Init(param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s Init`, this.GetName())
-	defer logger.LoggerDriver.Logger.DebugF(`api-strategy %s Init defer`, this.GetName())
+func (this *ParamValidateStrategyClass) Init(logger logger.InterfaceLogger, param interface{}) {
+	logger.DebugF(`api-strategy %s Init`, this.GetName())
+	defer logger.DebugF(`api-strategy %s Init defer`, this.GetName())
 }
 
 type ParamValidateStrategyParam struct {
@@ -122,7 +163,7 @@ type ParamValidateStrategyParam struct {
 }
 
 func (this *ParamValidateStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
 	myValidator := validator.ValidatorClass{}
 	myValidator.Init()
 
@@ -133,37 +174,201 @@ func (this *ParamValidateStrategyClass) Execute(out *api_session.ApiSessionClass
 			tempParam[k] = v
 		}
 	} else if out.GetMethod() == `POST` {
-		requestContentType := out.GetHeader(`content-type`)
+		requestContentType := out.GetHeader(string(api_session.HeaderName_ContentType))
 		if out.Api.GetParamType() != `` && !strings.HasPrefix(requestContentType, out.Api.GetParamType()) {
 			go_error.Throw(`content-type error`, this.errorCode)
 		}
 
 		if strings.HasPrefix(requestContentType, MULTIPART_TYPE) && (out.Api.GetParamType() == MULTIPART_TYPE || out.Api.GetParamType() == ``) {
-			formValues, err := out.GetFormValues()
-			if err != nil {
-				panic(err)
-			}
-			for k, v := range formValues {
-				tempParam[k] = v[0]
-			}
+			this.receiveMultipart(out, tempParam)
+			out.AddDefer(func() { // 请求结束后删除控制器没有保留的文件，参数校验失败的话全部删除
+				for _, files := range out.Files {
+					for _, file := range files {
+						if !file.IsKept() {
+							file.Delete()
+						}
+					}
+				}
+			})
 		} else if strings.HasPrefix(requestContentType, JSON_TYPE) && (out.Api.GetParamType() == JSON_TYPE || out.Api.GetParamType() == ``) {
 			if err := out.ReadJSON(&tempParam); err != nil {
 				go_error.ThrowError(`parse params error`, this.errorCode, err)
 			}
+		} else if strings.HasPrefix(requestContentType, FORM_TYPE) && (out.Api.GetParamType() == FORM_TYPE || out.Api.GetParamType() == ``) {
+			if err := out.Request.ParseForm(); err != nil {
+				go_error.ThrowError(`parse params error`, this.errorCode, err)
+			}
+			setFormValues(out, tempParam, out.Request.PostForm)
+		} else if serializer := this.getRequestSerializer(out, requestContentType); serializer != nil { // xml、yaml、msgpack 等注册过的格式
+			if err := out.ReadBody(serializer, &tempParam); err != nil {
+				go_error.ThrowError(`parse params error`, this.errorCode, err)
+			}
 		} else {
 			go_error.Throw(`content-type not be supported`, this.errorCode)
 		}
 	} else {
 		go_error.Throw(`scan params not be supported`, this.errorCode)
 	}
+	out.ParamDecoder = this.decoder
 	// 深拷贝
 	out.OriginalParams = go_json.Json.MustParseToMap(go_json.Json.MustStringify(tempParam))
 	out.Params = go_json.Json.MustParseToMap(go_json.Json.MustStringify(tempParam))
+	for fieldName, files := range out.Files { // 文件不参与深拷贝，ScanParams 时绑定到文件字段
+		if fieldType, ok := getParamFieldTypes(out.Api.GetParams())[fieldName]; ok && fieldType.Kind() == reflect.Slice {
+			out.Params[fieldName] = files
+		} else {
+			out.Params[fieldName] = files[0]
+		}
+	}
 	paramsStr := go_desensitize.Desensitize.DesensitizeToString(tempParam)
-	logger.LoggerDriver.Logger.InfoF(`Params: %s`, paramsStr)
+	out.Logger.InfoF(`Params: %s`, paramsStr)
 	util.UpdateSessionErrorMsg(out, `params`, paramsStr)
 	glovalValdator := []string{`no-sql-inject`}
 	if out.Api.GetParams() != nil {
-		this.recurValidate(out, myValidator, tempParam, glovalValdator, reflect.TypeOf(out.Api.GetParams()), reflect.ValueOf(out.Api.GetParams()))
+		paramsValue := reflect.Indirect(reflect.ValueOf(out.Api.GetParams())) // Params 也可以是结构体指针
+		this.recurValidate(out, myValidator, tempParam, glovalValdator, paramsValue.Type(), paramsValue)
+	}
+}
+
+// 流式接收 multipart 请求，普通字段放到 tempParam，文件保存到存储后端并记录到 out.Files
+func (this *ParamValidateStrategyClass) receiveMultipart(out *api_session.ApiSessionClass, tempParam map[string]interface{}) {
+	storage := out.Api.GetUploadStorage()
+	if storage == nil {
+		storage = this.uploadStorage
+	}
+	values, files, err := upload.Receive(out.Request, upload.ReceiveOption{
+		MaxSize:      out.Api.GetMaxUploadSize(),
+		AllowedTypes: out.Api.GetAllowedFileTypes(),
+		Storage:      storage,
+		Fields:       getFileFieldOptions(out.Api.GetParams()),
+	})
+	if err != nil {
+		if err == upload.ErrTooLarge {
+			go_error.ThrowError(`request body too large`, this.errorCode, err)
+		}
+		if typeErr, ok := err.(*upload.FileTypeError); ok {
+			go_error.ThrowErrorWithData(typeErr.Error(), this.errorCode, map[string]interface{}{
+				`field`: typeErr.FieldName,
+			}, err)
+		}
+		if limitErr, ok := err.(*upload.FileLimitError); ok {
+			go_error.ThrowErrorWithData(limitErr.Error(), this.errorCode, map[string]interface{}{
+				`field`: limitErr.FieldName,
+			}, err)
+		}
+		go_error.ThrowError(`parse params error`, this.errorCode, err)
+	}
+	setFormValues(out, tempParam, values)
+	out.Files = files
+	for fieldName, fieldFiles := range files {
+		for _, file := range fieldFiles {
+			out.Logger.InfoF(`File: %s, name: %s, type: %s, size: %d, key: %s`, fieldName, file.FileName, file.ContentType, file.Size, file.Key)
+		}
+	}
+}
+
+// 表单字段放到 tempParam，切片字段保留所有值，其他字段取第一个值
+func setFormValues(out *api_session.ApiSessionClass, tempParam map[string]interface{}, values map[string][]string) {
+	fieldTypes := getParamFieldTypes(out.Api.GetParams())
+	for k, v := range values {
+		if fieldType, ok := fieldTypes[k]; ok && fieldType.Kind() == reflect.Slice {
+			tempParam[k] = v
+		} else {
+			tempParam[k] = v[0]
+		}
+	}
+}
+
+// 请求体格式对应的序列化器，需要在路由允许的格式中
+func (this *ParamValidateStrategyClass) getRequestSerializer(out *api_session.ApiSessionClass, requestContentType string) api_session.InterfaceSerializer {
+	serializer := api_session.Serializers.GetByMediaType(requestContentType)
+	if serializer == nil {
+		return nil
+	}
+	for _, allowed := range api_session.Serializers.GetAllowed(out.Api.GetFormats()) {
+		if allowed.GetName() == serializer.GetName() {
+			return serializer
+		}
+	}
+	return nil
+}
+
+// 参数结构体中的文件字段（按 json 名），结构体字段展开
+func getFileFields(params interface{}) map[string]reflect.StructField {
+	result := map[string]reflect.StructField{}
+	if params == nil {
+		return result
+	}
+	collectFileFields(indirectType(reflect.TypeOf(params)), result)
+	return result
+}
+
+func collectFileFields(type_ reflect.Type, result map[string]reflect.StructField) {
+	for i := 0; i < type_.NumField(); i++ {
+		field := type_.Field(i)
+		if upload.IsFileType(field.Type) {
+			result[strings.Split(field.Tag.Get(`json`), `,`)[0]] = field
+		} else if field.Type.Kind() == reflect.Struct {
+			collectFileFields(field.Type, result)
+		}
+	}
+}
+
+// 接收 multipart 请求时声明的文件字段和它们的限制。没有 Params 的路由保存所有文件
+func getFileFieldOptions(params interface{}) map[string]upload.FieldOption {
+	if params == nil {
+		return nil
+	}
+	options := map[string]upload.FieldOption{}
+	for fieldName, field := range getFileFields(params) {
+		rules, _ := validator.ParseFileRules(field.Tag.Get(`validate`)) // 规则在服务启动前已经检查过
+		options[fieldName] = upload.FieldOption{
+			MaxSize:  rules.MaxSize,
+			MaxCount: rules.MaxCount,
+		}
+	}
+	return options
+}
+
+// 检查参数结构体中文件字段的校验规则，服务启动前检查配置时调用
+func CheckFileRules(params interface{}) []error {
+	errs := make([]error, 0)
+	for fieldName, field := range getFileFields(params) {
+		if _, err := validator.ParseFileRules(field.Tag.Get(`validate`)); err != nil {
+			errs = append(errs, fmt.Errorf(`file field %s: %s`, fieldName, err))
+		}
+	}
+	return errs
+}
+
+func indirectType(type_ reflect.Type) reflect.Type {
+	if type_.Kind() == reflect.Ptr {
+		return type_.Elem()
+	}
+	return type_
+}
+
+// 参数结构体中每个字段（按 json 名）的类型，嵌入的结构体展开
+func getParamFieldTypes(params interface{}) map[string]reflect.Type {
+	result := map[string]reflect.Type{}
+	if params == nil {
+		return result
+	}
+	type_ := reflect.TypeOf(params)
+	if type_.Kind() == reflect.Ptr {
+		type_ = type_.Elem()
+	}
+	collectParamFieldTypes(type_, result)
+	return result
+}
+
+func collectParamFieldTypes(type_ reflect.Type, result map[string]reflect.Type) {
+	for i := 0; i < type_.NumField(); i++ {
+		field := type_.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Anonymous {
+			collectParamFieldTypes(field.Type, result)
+			continue
+		}
+		result[strings.Split(field.Tag.Get(`json`), `,`)[0]] = field.Type
 	}
 }
@@ -0,0 +1,41 @@
+package global_api_strategy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+type pageParam struct {
+	Page uint64 `json:"page" validate:"required"`
+}
+
+type orderListParam struct {
+	Paging pageParam `json:"paging"`
+	Since  time.Time `json:"since"`
+	Status string    `json:"status" validate:"required"`
+}
+
+// 具名的结构体字段与嵌入的结构体一样展开校验，time.Time 作为普通字段
+func TestParamValidateStrategyClass_NestedStruct(t *testing.T) {
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/orders`,
+			Method: api_session.ApiMethod_Get,
+			Params: orderListParam{},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return `ok`
+			},
+		},
+	})
+
+	client.Get(`/orders`).WithQuery(map[string]string{`page`: `1`, `status`: `paid`, `since`: `2020-01-02`}).Do().AssertCode(0)
+	response := client.Get(`/orders`).WithQuery(map[string]string{`status`: `paid`}).Do().AssertCode(go_error.INTERNAL_ERROR_CODE)
+	if field := response.GetApiResult().Data.(map[string]interface{})[`field`]; field != `page` {
+		t.Errorf(`expect page to be validated, got field %v`, field)
+	}
+}
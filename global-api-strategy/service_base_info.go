@@ -7,9 +7,19 @@ import (
 	"github.com/pefish/go-core/driver/logger"
 	"github.com/pefish/go-core/util"
 	"github.com/pefish/go-error"
+	"io"
 	"io/ioutil"
+	"strings"
 )
 
+const maxLoggedBodySize = 4096 // 请求体最多记录这么多字节
+
+// 读出一部分后的请求体，关闭时关闭原来的流
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
 type ServiceBaseInfoStrategyClass struct {
 	errorCode uint64
 }
@@ -18,6 +28,10 @@ var ServiceBaseInfoApiStrategy = ServiceBaseInfoStrategyClass{
 
 }
 
+func NewServiceBaseInfoStrategy() *ServiceBaseInfoStrategyClass {
+	return &ServiceBaseInfoStrategyClass{}
+}
+
 func (this *ServiceBaseInfoStrategyClass) GetName() string {
 	return `serviceBaseInfo`
 }
@@ -37,22 +51,34 @@ func (this *ServiceBaseInfoStrategyClass) GetErrorCode() uint64 {
 	return go_error.INTERNAL_ERROR_CODE
 }
 
-func (this *ServiceBaseInfoStrategyClass) Init(param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s Init`, this.GetName())
-	defer logger.LoggerDriver.Logger.DebugF(`api-strategy %s Init defer`, this.GetName())
+func (this *ServiceBaseInfoStrategyClass) Init(logger logger.InterfaceLogger, param interface{}) {
+	logger.DebugF(`api-strategy %s Init`, this.GetName())
+	defer logger.DebugF(`api-strategy %s Init defer`, this.GetName())
 }
 
 func (this *ServiceBaseInfoStrategyClass) Execute(out *api_session.ApiSessionClass, param interface{}) {
-	logger.LoggerDriver.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
+	out.Logger.DebugF(`api-strategy %s trigger`, this.GetName())
 	apiMsg := fmt.Sprintf(`%s %s %s`, out.GetRemoteAddress(), out.GetPath(), out.GetMethod())
-	logger.LoggerDriver.Logger.Info(fmt.Sprintf(`---------------- %s ----------------`, apiMsg))
+	out.Logger.Info(fmt.Sprintf(`---------------- %s ----------------`, apiMsg))
 	util.UpdateSessionErrorMsg(out, `apiMsg`, apiMsg)
-	logger.LoggerDriver.Logger.DebugF(`UrlParams: %#v`, out.GetUrlParams())
-	logger.LoggerDriver.Logger.DebugF(`Headers: %#v`, out.Request.Header)
+	out.Logger.DebugF(`UrlParams: %#v`, out.GetUrlParams())
+	out.Logger.DebugF(`Headers: %#v`, out.Request.Header)
 
-	rawData, _ := ioutil.ReadAll(out.Request.Body)
-	out.Request.Body = ioutil.NopCloser(bytes.NewBuffer(rawData)) // 读出来后又新建一个流填进去，使out.Request.Body可以被再次读
-	logger.LoggerDriver.Logger.DebugF(`Body: %s`, string(rawData))
+	if strings.HasPrefix(out.GetHeader(string(api_session.HeaderName_ContentType)), MULTIPART_TYPE) { // 文件内容不记录，也不读到内存里
+		out.Logger.Debug(`Body: multipart, not logged`)
+	} else if out.Request.Body != nil {
+		// 只读出前面一部分用于记录，再和剩下的流拼起来，使out.Request.Body可以被完整读取
+		rawData, _ := ioutil.ReadAll(io.LimitReader(out.Request.Body, maxLoggedBodySize+1))
+		out.Request.Body = &peekedBody{
+			Reader: io.MultiReader(bytes.NewReader(rawData), out.Request.Body),
+			Closer: out.Request.Body,
+		}
+		if len(rawData) > maxLoggedBodySize {
+			out.Logger.DebugF(`Body: %s...(truncated)`, string(rawData[:maxLoggedBodySize]))
+		} else {
+			out.Logger.DebugF(`Body: %s`, string(rawData))
+		}
+	}
 
 	lang := out.GetHeader(`lang`)
 	if lang == `` {
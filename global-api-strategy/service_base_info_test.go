@@ -0,0 +1,55 @@
+package global_api_strategy_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	test_client "github.com/pefish/go-core/test-client"
+	"github.com/pefish/go-core/upload"
+)
+
+type noteParam struct {
+	Text string              `json:"text"`
+	File []*upload.FileClass `json:"file"`
+}
+
+// 请求体只记录开头一部分，multipart 请求不记录，控制器仍然拿到完整的请求体
+func TestServiceBaseInfoStrategyClass_BodyLog(t *testing.T) {
+	svc, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:          `/note`,
+			Method:        api_session.ApiMethod_Post,
+			Params:        noteParam{},
+			UploadStorage: upload.NewMemoryStorage(),
+			TypedController: func(apiSession *api_session.ApiSessionClass, params noteParam) (int, error) {
+				return len(params.Text), nil
+			},
+		},
+	})
+	fakeLogger := test_client.NewFakeLogger()
+	svc.GetLoggerDriver().Register(fakeLogger)
+
+	longText := strings.Repeat(`a`, 10000)
+	var length int
+	client.Post(`/note`).WithJson(map[string]interface{}{`text`: longText}).Do().AssertCode(0).ScanData(&length)
+	if length != len(longText) {
+		t.Errorf(`controller should read the whole body, got %d`, length)
+	}
+	client.Post(`/note`).WithMultipartValues(map[string]string{`text`: `note`}).WithFile(`file`, `a.txt`, []byte(`secret file content`)).Do().AssertCode(0)
+
+	bodyLogs := 0
+	for _, entry := range fakeLogger.GetEntries(`debug`) {
+		if !strings.HasPrefix(entry, `Body: `) {
+			continue
+		}
+		bodyLogs++
+		if len(entry) > 5000 || strings.Contains(entry, `secret file content`) {
+			t.Errorf(`unexpected body log %.100s`, entry)
+		}
+	}
+	if bodyLogs != 2 {
+		t.Errorf(`expect 2 body logs, got %d`, bodyLogs)
+	}
+}
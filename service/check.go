@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	global_api_strategy "github.com/pefish/go-core/global-api-strategy"
+	"reflect"
+	"strings"
+)
+
+// 检查路由和策略配置，返回发现的所有问题。Run 启动前会调用，有问题的话拒绝启动
+func (this *ServiceClass) Check() []error {
+	errs := this.GetGlobalApiStrategyDriver().Check()
+
+	registered := map[string]bool{}
+	for _, apiObject := range this.apis {
+		apiPath := this.getApiPath(apiObject)
+		routeName := fmt.Sprintf(`%s %s`, apiObject.Method, apiPath)
+		routeErrs := make([]error, 0)
+
+		if err := apiObject.CheckHandler(); err != nil { // 先解析带类型的处理器，推断出的 Params/Return 也要经过下面的检查
+			routeErrs = append(routeErrs, err)
+		}
+		key := string(apiObject.Method) + ` ` + apiPath
+		if registered[key] {
+			routeErrs = append(routeErrs, errors.New(`duplicate route`))
+		}
+		registered[key] = true
+
+		switch apiObject.ParamType {
+		case global_api_strategy.ALL_TYPE, global_api_strategy.JSON_TYPE, global_api_strategy.MULTIPART_TYPE, global_api_strategy.FORM_TYPE, global_api_strategy.TEXT_TYPE:
+		default:
+			routeErrs = append(routeErrs, fmt.Errorf(`unknown ParamType %s`, apiObject.ParamType))
+		}
+		for _, format := range apiObject.Formats {
+			if _, ok := api_session.Serializers.Get(format); !ok {
+				routeErrs = append(routeErrs, fmt.Errorf(`unknown format %s`, format))
+			}
+		}
+		if apiObject.Params != nil && !isStructOrStructPtr(apiObject.Params) {
+			routeErrs = append(routeErrs, fmt.Errorf(`Params must be a struct or a non-nil pointer to struct, got %T`, apiObject.Params))
+		} else {
+			routeErrs = append(routeErrs, global_api_strategy.CheckFileRules(apiObject.Params)...)
+		}
+		if apiObject.Return != nil && !isStructOrStructPtr(apiObject.Return) {
+			routeErrs = append(routeErrs, fmt.Errorf(`Return must be a struct or a non-nil pointer to struct, got %T`, apiObject.Return))
+		}
+
+		for _, name := range apiObject.SkipGlobalStrategies {
+			if _, ok := this.GetGlobalApiStrategyDriver().Get(name); !ok {
+				routeErrs = append(routeErrs, fmt.Errorf(`skipped global strategy %s not found`, name))
+			}
+		}
+		for name, param := range apiObject.GlobalStrategyParams {
+			strategyData, ok := this.GetGlobalApiStrategyDriver().Get(name)
+			if !ok {
+				routeErrs = append(routeErrs, fmt.Errorf(`overridden global strategy %s not found`, name))
+				continue
+			}
+			if err := validateStrategyParam(strategyData.Strategy, param); err != nil {
+				routeErrs = append(routeErrs, fmt.Errorf(`global strategy %s: %s`, name, err))
+			}
+		}
+		for _, strategyData := range apiObject.Strategies {
+			if strategyData.Disable {
+				continue
+			}
+			if err := validateStrategyParam(strategyData.Strategy, strategyData.Param); err != nil {
+				routeErrs = append(routeErrs, fmt.Errorf(`strategy %s: %s`, strategyData.Strategy.GetName(), err))
+			}
+		}
+		routeErrs = append(routeErrs, checkStrategyDependencies(apiObject.GetEffectiveStrategies(this.GetGlobalApiStrategyDriver()))...)
+
+		for _, err := range routeErrs {
+			errs = append(errs, fmt.Errorf(`route %s: %s`, routeName, err))
+		}
+	}
+	return errs
+}
+
+// 依赖的策略必须在前面执行（全局策略在路由策略之前）
+func checkStrategyDependencies(strategies []api_strategy.StrategyData) []error {
+	errs := make([]error, 0)
+	executed := map[string]bool{}
+	for _, strategyData := range strategies {
+		if dependentStrategy, ok := strategyData.Strategy.(api_strategy.InterfaceDependentStrategy); ok {
+			for _, name := range dependentStrategy.GetDependencies() {
+				if !executed[name] {
+					errs = append(errs, fmt.Errorf(`strategy %s must run after %s`, strategyData.Strategy.GetName(), name))
+				}
+			}
+		}
+		executed[strategyData.Strategy.GetName()] = true
+	}
+	return errs
+}
+
+// 检查失败时把所有问题合成一个错误
+func checkErrorsToError(errs []error) error {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, `  - `+err.Error())
+	}
+	return fmt.Errorf("service config check failed, %d problem(s):\n%s", len(errs), strings.Join(messages, "\n"))
+}
+
+// 结构体或非nil的结构体指针
+func isStructOrStructPtr(v interface{}) bool {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return false
+		}
+		value = value.Elem()
+	}
+	return value.Kind() == reflect.Struct
+}
+
+func validateStrategyParam(strategy api_strategy.InterfaceStrategy, param interface{}) error {
+	validator, ok := strategy.(api_strategy.InterfaceParamValidator)
+	if !ok {
+		return nil
+	}
+	return validator.Validate(param)
+}
+
+// 路由的完整路径
+func (this *ServiceClass) getApiPath(apiObject *api.Api) string {
+	if apiObject.IgnoreRootPath {
+		return apiObject.Path
+	}
+	return this.path + apiObject.Path
+}
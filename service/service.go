@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"runtime"
+	"sync"
 )
 
 type ServiceClass struct {
@@ -27,20 +28,68 @@ type ServiceClass struct {
 	apis             []*api.Api // 服务的所有路由
 	healthyCheckFunc func()     // 健康检查函数
 
+	globalApiStrategyDriver *api_strategy.GlobalApiStrategyDriverClass   // 服务自己的全局策略驱动，没有设置的话使用包级别的默认驱动
+	loggerDriver            *logger.LoggerDriverClass                    // 服务自己的日志驱动，没有设置的话使用包级别的默认驱动
+	externalServiceDriver   *external_service.ExternalServiceDriverClass // 服务自己的外接服务驱动，没有设置的话使用包级别的默认驱动
+
+	prepareLock sync.Mutex
+	prepared    bool
+	checkErr    error // 配置检查失败的结果，只检查和报告一次
+
 	Mux *http.ServeMux
 }
 
+func (this *ServiceClass) GetGlobalApiStrategyDriver() *api_strategy.GlobalApiStrategyDriverClass {
+	if this.globalApiStrategyDriver == nil {
+		return &api_strategy.GlobalApiStrategyDriver
+	}
+	return this.globalApiStrategyDriver
+}
+
+func (this *ServiceClass) SetGlobalApiStrategyDriver(driver *api_strategy.GlobalApiStrategyDriverClass) {
+	this.globalApiStrategyDriver = driver
+}
+
+func (this *ServiceClass) GetLoggerDriver() *logger.LoggerDriverClass {
+	if this.loggerDriver == nil {
+		return &logger.LoggerDriver
+	}
+	return this.loggerDriver
+}
+
+func (this *ServiceClass) SetLoggerDriver(driver *logger.LoggerDriverClass) {
+	this.loggerDriver = driver
+}
+
+func (this *ServiceClass) GetExternalServiceDriver() *external_service.ExternalServiceDriverClass {
+	if this.externalServiceDriver == nil {
+		return &external_service.ExternalServiceDriver
+	}
+	return this.externalServiceDriver
+}
+
+func (this *ServiceClass) SetExternalServiceDriver(driver *external_service.ExternalServiceDriverClass) {
+	this.externalServiceDriver = driver
+}
+
+// 设置路由。带类型的处理器在这里解析，签名有问题的话 panic
 func (this *ServiceClass) SetRoutes(routes ...[]*api.Api) {
 	this.apis = []*api.Api{}
 	for _, route := range routes {
-		this.apis = append(this.apis, route...)
+		this.AddRoute(route...)
 	}
 }
 
+// 添加路由。带类型的处理器在这里解析，签名有问题的话 panic
 func (this *ServiceClass) AddRoute(routes ...*api.Api) {
 	if len(this.apis) == 0 {
 		this.apis = []*api.Api{}
 	}
+	for _, route := range routes {
+		if err := route.ResolveTypedController(); err != nil {
+			panic(fmt.Errorf(`route %s %s: %s`, route.Method, this.getApiPath(route), err))
+		}
+	}
 	this.apis = append(this.apis, routes...)
 }
 
@@ -116,30 +165,22 @@ func (this *ServiceClass) Run() {
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	external_service.ExternalServiceDriver.Startup() // 启动外接服务驱动
-	logger.LoggerDriver.Startup()                    // 启动日志驱动
-	api_strategy.GlobalApiStrategyDriver.Startup()   // 启动外接全局前置处理器驱动
-
-	// 执行各个全局策略的初始化函数
-	for _, globalStrategy := range api_strategy.GlobalApiStrategyDriver.GlobalStrategies {
-		if !globalStrategy.Disable {
-			globalStrategy.Strategy.Init(globalStrategy.Param)
-		}
+	this.prepare()
+	if this.checkErr != nil { // 配置有问题的话拒绝启动
+		panic(this.checkErr)
 	}
-
-	this.buildRoutes()
 	host := this.host
 	if host == `` {
 		host = `0.0.0.0`
 	}
 
 	addr := host + `:` + go_reflect.Reflect.MustToString(this.port)
-	logger.LoggerDriver.Logger.InfoF(`server started!!! http://%s`, addr)
+	this.GetLoggerDriver().Logger.InfoF(`server started!!! http://%s`, addr)
 	s := &http.Server{
 		Addr:    addr,
-		Handler: this.Mux,
+		Handler: this,
 	}
-	err := http2.ConfigureServer(s, &http2.Server{}) // 可以使用http2协议
+	err := http2.ConfigureServer(s, &http2.Server{}) // 可以使用http2协议。WebSocket 升级走 http/1.1，不受影响
 	if err != nil {
 		panic(err)
 	}
@@ -149,6 +190,52 @@ func (this *ServiceClass) Run() {
 	}
 }
 
+// 实现 http.Handler，第一次处理请求时才构建路由。测试中可以不监听端口直接调用。
+// 配置检查失败的话所有请求返回500，问题只在第一次请求时记录一次
+func (this *ServiceClass) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	this.prepare()
+	if this.checkErr != nil {
+		http.Error(response, this.checkErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	this.Mux.ServeHTTP(response, request)
+}
+
+// 检查配置、启动驱动、初始化全局策略、构建路由。只执行一次。
+// 配置检查失败的话记录到 checkErr，不再重复检查；其他步骤失败的话下次调用会重新执行
+func (this *ServiceClass) prepare() {
+	this.prepareLock.Lock()
+	defer this.prepareLock.Unlock()
+	if this.prepared {
+		return
+	}
+
+	if this.GetLoggerDriver().Logger == nil { // 服务没有注册日志器的话使用默认日志器
+		this.GetLoggerDriver().Register(logger.LoggerDriver.Logger)
+	}
+	if errs := this.Check(); len(errs) > 0 { // 配置有问题的话一次性报告所有问题，拒绝启动
+		this.checkErr = checkErrorsToError(errs)
+		if this.GetLoggerDriver().Logger != nil {
+			this.GetLoggerDriver().Logger.Error(this.checkErr)
+		}
+		this.prepared = true
+		return
+	}
+	this.GetExternalServiceDriver().Startup()   // 启动外接服务驱动
+	this.GetLoggerDriver().Startup()            // 启动日志驱动
+	this.GetGlobalApiStrategyDriver().Startup() // 启动外接全局前置处理器驱动
+
+	// 执行各个全局策略的初始化函数
+	for _, globalStrategy := range this.GetGlobalApiStrategyDriver().GlobalStrategies {
+		if !globalStrategy.Disable {
+			globalStrategy.Strategy.Init(this.GetLoggerDriver().Logger, globalStrategy.Param)
+		}
+	}
+
+	this.buildRoutes()
+	this.prepared = true
+}
+
 func (this *ServiceClass) buildRoutes() {
 	// healthz
 	var healthApi = &api.Api{
@@ -156,11 +243,12 @@ func (this *ServiceClass) buildRoutes() {
 		Path:                   "/healthz",
 		IgnoreRootPath:         true,
 		IgnoreGlobalStrategies: true,
+		SkipNegotiation:        true,
 		Method:                 api_session.ApiMethod_All,
 		Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
 			defer func() {
 				if err := recover(); err != nil {
-					logger.LoggerDriver.Logger.Error(err)
+					apiSession.Logger.Error(err)
 					apiSession.SetStatusCode(api_session.StatusCode_InternalServerError)
 					apiSession.WriteText(`not ok`)
 				}
@@ -169,8 +257,17 @@ func (this *ServiceClass) buildRoutes() {
 				this.healthyCheckFunc()
 			}
 
+			// 附带外部服务熔断器的状态。外部服务熔断不影响本服务的健康状态，否则会连锁重启
+			text := `ok`
+			externalServiceDriver := this.GetExternalServiceDriver()
+			breakerStates := externalServiceDriver.GetBreakerStates()
+			for _, name := range externalServiceDriver.GetNames() {
+				if state, ok := breakerStates[name]; ok {
+					text += fmt.Sprintf("\nexternal service %s: %s", name, state)
+				}
+			}
 			apiSession.SetStatusCode(api_session.StatusCode_OK)
-			apiSession.WriteText(`ok`)
+			apiSession.WriteText(text)
 			return nil
 		},
 		ParamType: global_api_strategy.ALL_TYPE,
@@ -182,12 +279,13 @@ func (this *ServiceClass) buildRoutes() {
 		Path:                   "/",
 		IgnoreRootPath:         true,
 		IgnoreGlobalStrategies: true,
+		SkipNegotiation:        true,
 		Method:                 api_session.ApiMethod_All,
 		Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
 			rawData, _ := ioutil.ReadAll(apiSession.Request.Body)
-			logger.LoggerDriver.Logger.DebugF(`Body: %s`, string(rawData))
+			apiSession.Logger.DebugF(`Body: %s`, string(rawData))
 			apiSession.SetStatusCode(api_session.StatusCode_NotFound)
-			logger.LoggerDriver.Logger.Debug(`api not found`)
+			apiSession.Logger.Debug(`api not found`)
 			apiSession.WriteText(`Not Found`)
 			return nil
 		},
@@ -200,14 +298,11 @@ func (this *ServiceClass) buildRoutes() {
 	registedApi := map[string]map[string]*api.Api{}
 	for _, apiObject := range this.GetApis() {
 		// 得到apiPath
-		apiPath := this.path + apiObject.Path
-		if apiObject.IgnoreRootPath == true {
-			apiPath = apiObject.Path
-		}
+		apiPath := this.getApiPath(apiObject)
 		method := apiObject.Method
 
 		// 挂载处理器
-		if apiObject.Controller != nil {
+		if apiObject.HasHandler() {
 			if registedApi[apiPath] == nil {
 				registedApi[apiPath] = map[string]*api.Api{
 					string(method): apiObject,
@@ -220,9 +315,10 @@ func (this *ServiceClass) buildRoutes() {
 		}
 	}
 	for apiPath, map_ := range registedApi {
-		this.Mux.HandleFunc(apiPath, api.WrapJson(map_))
+		this.Mux.HandleFunc(apiPath, api.WrapJson(this, map_))
 		for method, api_ := range map_ {
-			logger.LoggerDriver.Logger.Info(fmt.Sprintf(`--- %s %s %s ---`, method, apiPath, api_.Description))
+			this.GetLoggerDriver().Logger.Info(fmt.Sprintf(`--- %s %s %s ---`, method, apiPath, api_.Description))
+			this.GetLoggerDriver().Logger.Info(fmt.Sprintf(`    pipeline: %s`, api_.GetPipelineDesc(this.GetGlobalApiStrategyDriver())))
 		}
 	}
 }
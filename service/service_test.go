@@ -1,9 +1,133 @@
 package service
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	external_service "github.com/pefish/go-core/driver/external-service"
+	global_api_strategy "github.com/pefish/go-core/driver/global-api-strategy"
+	"github.com/pefish/go-core/driver/logger"
+	"github.com/pefish/go-core/upload"
 )
 
 func TestBaseServiceClass_Test(t *testing.T) {
 
 }
+
+func TestServiceClass_Check(t *testing.T) {
+	type params struct {
+		Name string `json:"name"`
+	}
+	type uploadParams struct {
+		File *upload.FileClass `json:"file" validate:"required,file-max-size=1k"`
+	}
+	svc := &ServiceClass{}
+	svc.SetGlobalApiStrategyDriver(global_api_strategy.NewGlobalApiStrategyDriver())
+	controller := func(apiSession *api_session.ApiSessionClass) interface{} {
+		return nil
+	}
+	svc.SetRoutes([]*api.Api{
+		{
+			Path:       `/test`,
+			Method:     api_session.ApiMethod_Post,
+			Controller: controller,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: api_strategy.NewRateLimitStrategy(),
+				},
+			},
+		},
+		{
+			Path:       `/test`,
+			Method:     api_session.ApiMethod_Post,
+			Controller: controller,
+			Params:     `wrong`,
+			ParamType:  `text/html`,
+		},
+		{
+			Path:       `/test`,
+			Method:     api_session.ApiMethod_Get,
+			Controller: controller,
+		},
+		{
+			Path:       `/pointer`,
+			Method:     api_session.ApiMethod_Get,
+			Controller: controller,
+			Params:     &params{},
+			Return:     &params{},
+		},
+		{
+			Path:       `/upload`,
+			Method:     api_session.ApiMethod_Post,
+			Controller: controller,
+			Params:     uploadParams{},
+		},
+		{
+			Path:       `/pay`,
+			Method:     api_session.ApiMethod_Post,
+			Controller: controller,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: api_strategy.NewIdempotencyStrategy(),
+					Param:    api_strategy.IdempotencyParam{},
+				},
+			},
+		},
+		{
+			Path:       `/nil-pointer`,
+			Method:     api_session.ApiMethod_Get,
+			Controller: controller,
+			Params:     (*params)(nil),
+			Return:     []params{},
+		},
+	})
+	errs := svc.Check()
+	expect := []string{
+		`route POST /test: strategy rateLimit: param of rateLimit must be RateLimitParam, got <nil>`,
+		`route POST /test: duplicate route`,
+		`route POST /test: unknown ParamType text/html`,
+		`route POST /test: Params must be a struct or a non-nil pointer to struct, got string`,
+		`route POST /upload: file field file: file-max-size must be a positive integer, got 1k`,
+		`route POST /pay: strategy idempotency must run after paramValidate`,
+		`route GET /nil-pointer: Params must be a struct or a non-nil pointer to struct, got *service.params`,
+		`route GET /nil-pointer: Return must be a struct or a non-nil pointer to struct, got []service.params`,
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf(`expect %d problems, got %d: %v`, len(expect), len(errs), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expect[i] {
+			t.Errorf(`problem %d: expect %q, got %q`, i, expect[i], err.Error())
+		}
+	}
+}
+
+// 配置检查失败时每个请求都返回500，不会在每个请求中 panic
+func TestServiceClass_ServeHTTPCheckFailed(t *testing.T) {
+	svc := &ServiceClass{}
+	svc.SetGlobalApiStrategyDriver(global_api_strategy.NewGlobalApiStrategyDriver())
+	svc.SetLoggerDriver(logger.NewLoggerDriver())
+	svc.SetExternalServiceDriver(external_service.NewExternalServiceDriver())
+	svc.SetRoutes([]*api.Api{
+		{
+			Path:      `/test`,
+			Method:    api_session.ApiMethod_Post,
+			ParamType: `text/html`,
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return nil
+			},
+		},
+	})
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		svc.ServeHTTP(recorder, httptest.NewRequest(`POST`, `/test`, nil))
+		if recorder.Code != http.StatusInternalServerError || !strings.Contains(recorder.Body.String(), `unknown ParamType text/html`) {
+			t.Errorf(`unexpected response %d %s`, recorder.Code, recorder.Body.String())
+		}
+	}
+}
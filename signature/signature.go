@@ -0,0 +1,132 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 签名算法
+type Algorithm string
+
+const (
+	Algorithm_HmacSha256 Algorithm = `HMAC-SHA256` // 双方共享同一个密钥
+	Algorithm_Ed25519    Algorithm = `ED25519`     // 客户端用私钥签名，服务端用公钥验证
+)
+
+/**
+待签名的字符串：方法、路径、排好序的参数、时间戳（秒）、nonce，用换行连接。
+参数按 key 排序后拼成 k1=v1&k2=v2（key 和 value 都做 url 编码）。数字按 json 解析后的 float64 格式化，
+对象和数组用 json 编码，所以超过 2^53 的整数需要用字符串传
+*/
+func BuildCanonical(method string, path string, params map[string]interface{}, timestamp int64, nonce string) string {
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		CanonicalParams(params),
+		strconv.FormatInt(timestamp, 10),
+		nonce,
+	}, "\n")
+}
+
+// 排好序的参数。params 先经过 json 编解码，和服务端收到的参数一致
+func CanonicalParams(params map[string]interface{}) string {
+	if len(params) == 0 {
+		return ``
+	}
+	normalized := map[string]interface{}{}
+	if data, err := json.Marshal(params); err == nil {
+		if err := json.Unmarshal(data, &normalized); err != nil {
+			normalized = params
+		}
+	} else {
+		normalized = params
+	}
+	keys := make([]string, 0, len(normalized))
+	for key := range normalized {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, url.QueryEscape(key)+`=`+url.QueryEscape(valueToString(normalized[key])))
+	}
+	return strings.Join(pairs, `&`)
+}
+
+func valueToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ``
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+// 签名，返回 base64 编码的签名。Ed25519 的 key 是私钥
+func Sign(algorithm Algorithm, key []byte, canonical string) (string, error) {
+	switch algorithm {
+	case Algorithm_HmacSha256:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(canonical))
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+	case Algorithm_Ed25519:
+		if len(key) != ed25519.PrivateKeySize {
+			return ``, fmt.Errorf(`ed25519 private key must be %d bytes`, ed25519.PrivateKeySize)
+		}
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(ed25519.PrivateKey(key), []byte(canonical))), nil
+	default:
+		return ``, fmt.Errorf(`algorithm %s is not supported`, algorithm)
+	}
+}
+
+// 验证签名。Ed25519 的 key 是公钥
+func Verify(algorithm Algorithm, key []byte, canonical string, sign string) bool {
+	signBytes, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return false
+	}
+	switch algorithm {
+	case Algorithm_HmacSha256:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(canonical))
+		return hmac.Equal(signBytes, mac.Sum(nil))
+	case Algorithm_Ed25519:
+		if len(key) != ed25519.PublicKeySize {
+			return false
+		}
+		return ed25519.Verify(ed25519.PublicKey(key), []byte(canonical), signBytes)
+	default:
+		return false
+	}
+}
+
+// 随机 nonce
+func NewNonce() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
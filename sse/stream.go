@@ -0,0 +1,194 @@
+// Server-Sent Events 事件流
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	go_application "github.com/pefish/go-application"
+	api_session "github.com/pefish/go-core/api-session"
+)
+
+var ErrClosed = errors.New(`event stream closed`)
+
+// 一个事件。Data 是 string 或 []byte 的话原样发送，其他类型按 json 序列化
+type EventClass struct {
+	Id    string
+	Event string // 事件名，空表示默认的 message
+	Data  interface{}
+	Retry time.Duration // 告诉客户端断线后多久重连，0 表示不设置
+}
+
+type StreamClass struct {
+	lock        sync.Mutex
+	writer      http.ResponseWriter
+	flusher     http.Flusher
+	lastEventId string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	opened      bool
+}
+
+// 新建事件流。ResponseWriter 需要实现 http.Flusher
+func NewStream(writer http.ResponseWriter, request *http.Request) (*StreamClass, error) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		return nil, errors.New(`response writer does not support flush`)
+	}
+	lastEventId := request.Header.Get(string(api_session.HeaderName_LastEventId))
+	if lastEventId == `` { // 不能设置header的客户端可以通过url参数传
+		lastEventId = request.URL.Query().Get(`lastEventId`)
+	}
+	ctx, cancel := context.WithCancel(request.Context())
+	stream := &StreamClass{
+		writer:      writer,
+		flusher:     flusher,
+		lastEventId: lastEventId,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	go func() { // 应用退出时结束事件流
+		select {
+		case <-go_application.Application.OnFinished():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return stream, nil
+}
+
+// 写入响应头，开始事件流
+func (this *StreamClass) Open() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.opened {
+		return
+	}
+	this.opened = true
+	header := this.writer.Header()
+	header.Set(string(api_session.HeaderName_ContentType), `text/event-stream`)
+	header.Set(string(api_session.HeaderName_CacheControl), `no-cache`)
+	header.Set(string(api_session.HeaderName_Connection), `keep-alive`)
+	header.Set(string(api_session.HeaderName_XAccelBuffering), `no`) // 关闭 nginx 的缓冲
+	this.writer.WriteHeader(http.StatusOK)
+	this.flusher.Flush()
+}
+
+// 客户端重连时带上来的最后一个事件id
+func (this *StreamClass) GetLastEventId() string {
+	return this.lastEventId
+}
+
+// 客户端断开、调用 Close 或者应用退出时关闭
+func (this *StreamClass) Done() <-chan struct{} {
+	return this.ctx.Done()
+}
+
+func (this *StreamClass) Context() context.Context {
+	return this.ctx
+}
+
+func (this *StreamClass) IsClosed() bool {
+	return this.ctx.Err() != nil
+}
+
+// 发送事件，每个事件都会立即flush
+func (this *StreamClass) Send(event EventClass) error {
+	var data string
+	switch value := event.Data.(type) {
+	case string:
+		data = value
+	case []byte:
+		data = string(value)
+	case nil:
+	default:
+		dataBytes, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		data = string(dataBytes)
+	}
+
+	builder := strings.Builder{}
+	if event.Id != `` {
+		builder.WriteString(`id: ` + oneLine(event.Id) + "\n")
+	}
+	if event.Event != `` {
+		builder.WriteString(`event: ` + oneLine(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		builder.WriteString(fmt.Sprintf("retry: %d\n", event.Retry.Milliseconds()))
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") { // 多行数据每行一个 data 字段
+		builder.WriteString(`data: ` + line + "\n")
+	}
+	builder.WriteString("\n")
+	return this.write(builder.String())
+}
+
+// 发送默认事件（message）
+func (this *StreamClass) SendData(data interface{}) error {
+	return this.Send(EventClass{
+		Data: data,
+	})
+}
+
+// 发送注释，客户端会忽略，可以用来保持连接
+func (this *StreamClass) SendComment(comment string) error {
+	return this.write(`: ` + oneLine(comment) + "\n\n")
+}
+
+// 定时发送心跳，事件流关闭时停止。interval 不大于0的话不发送
+func (this *StreamClass) StartHeartbeat(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := this.SendComment(`ping`); err != nil {
+					return
+				}
+			case <-this.Done():
+				return
+			}
+		}
+	}()
+}
+
+// 结束事件流。等正在进行的写入完成后才返回，返回后不会再有写入
+func (this *StreamClass) Close() {
+	this.cancel()
+	this.lock.Lock()
+	this.lock.Unlock()
+}
+
+func (this *StreamClass) write(content string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.IsClosed() {
+		return ErrClosed
+	}
+	if !this.opened {
+		return errors.New(`event stream is not opened`)
+	}
+	if _, err := this.writer.Write([]byte(content)); err != nil {
+		this.cancel()
+		return err
+	}
+	this.flusher.Flush()
+	return nil
+}
+
+func oneLine(str string) string {
+	return strings.NewReplacer("\r", ``, "\n", ``).Replace(str)
+}
@@ -0,0 +1,113 @@
+package sse_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	"github.com/pefish/go-core/sse"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+// 写入时阻塞，直到 release 被关闭
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	started chan struct{}
+	release chan struct{}
+	writes  int32
+}
+
+func (this *blockingWriter) Write(p []byte) (int, error) {
+	if atomic.AddInt32(&this.writes, 1) == 1 {
+		close(this.started)
+		<-this.release
+	}
+	return this.ResponseRecorder.Write(p)
+}
+
+// Close 等正在进行的写入完成后才返回，之后的写入返回 ErrClosed
+func TestStreamClass_CloseWaitsForWrite(t *testing.T) {
+	writer := &blockingWriter{
+		ResponseRecorder: httptest.NewRecorder(),
+		started:          make(chan struct{}),
+		release:          make(chan struct{}),
+	}
+	stream, err := sse.NewStream(writer, httptest.NewRequest(http.MethodGet, `/events`, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Open()
+	go stream.SendData(`first`)
+	<-writer.started
+
+	closed := make(chan struct{})
+	go func() {
+		stream.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal(`Close should wait for the in-flight write`)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(writer.release)
+	<-closed
+	if err := stream.SendData(`second`); err != sse.ErrClosed {
+		t.Errorf(`expect ErrClosed, got %v`, err)
+	}
+	if writes := atomic.LoadInt32(&writer.writes); writes != 1 {
+		t.Errorf(`expect 1 write, got %d`, writes)
+	}
+}
+
+// 路由先执行策略，鉴权失败返回统一结构；控制器返回的错误作为 error 事件发送
+func TestStreamClass_Route(t *testing.T) {
+	_, pubKey := test_client.GetTestKeyPair()
+	jwtStrategy := api_strategy.NewJwtAuthStrategy()
+	jwtStrategy.SetPubKey(pubKey)
+	jwtStrategy.SetHeaderName(`Json-Web-Token`)
+	jwtStrategy.SetErrorCode(2001)
+
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/events`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: jwtStrategy,
+				},
+			},
+			SseController: func(apiSession *api_session.ApiSessionClass, stream *sse.StreamClass) error {
+				stream.Send(sse.EventClass{
+					Id:    `2`,
+					Event: `status`,
+					Data:  map[string]interface{}{`after`: stream.GetLastEventId()},
+				})
+				stream.SendData("line1\nline2")
+				return &go_error.ErrorInfo{
+					ErrorMessage: `order closed`,
+					ErrorCode:    3001,
+				}
+			},
+		},
+	})
+
+	client.Get(`/events`).WithHeader(`Accept`, `text/event-stream`).Do().AssertCode(2001)
+
+	response := client.Get(`/events`).WithJwt(map[string]interface{}{`user_id`: 1}).WithHeader(`Last-Event-ID`, `1`).WithHeader(`Accept`, `text/event-stream`).Do().AssertStatus(200)
+	if response.GetHeader(`Content-Type`) != `text/event-stream` {
+		t.Errorf(`unexpected content type %s`, response.GetHeader(`Content-Type`))
+	}
+	expect := "id: 2\nevent: status\ndata: {\"after\":\"1\"}\n\n" +
+		"data: line1\ndata: line2\n\n" +
+		"event: error\ndata: {\"msg\":\"order closed\",\"internal_msg\":\"\",\"code\":3001,\"data\":null}\n\n"
+	if response.GetBody() != expect {
+		t.Errorf(`unexpected body %q`, response.GetBody())
+	}
+}
@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	go_core "github.com/pefish/go-core"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
 	"github.com/pefish/go-core/global-api-strategy"
+	"github.com/pefish/go-core/service"
+	"github.com/pefish/go-core/upload"
 	"github.com/pefish/go-error"
 	"github.com/pefish/go-file"
 	"github.com/pefish/go-format"
@@ -61,6 +65,14 @@ type Yaml_Path struct {
 	Parameters  []Yaml_Parameter         `json:"parameters" yaml:"parameters"`
 	Responses   map[string]Yaml_Response `json:"responses" yaml:"responses"`
 	Description string                   `json:"description" yaml:"description"`
+	Security    []map[string][]string    `json:"security,omitempty" yaml:"security,omitempty"`
+}
+
+type Yaml_SecurityScheme struct {
+	Type        string `json:"type" yaml:"type"`
+	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
+	In          string `json:"in,omitempty" yaml:"in,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
 }
 
 type Yaml_Property struct {
@@ -87,6 +99,8 @@ type Yaml_Swagger struct {
 	Schemes     []string                        `json:"schemes" yaml:"schemes"`
 	Paths       map[string]map[string]Yaml_Path `json:"paths" yaml:"paths"`
 	Definitions map[string]Yaml_Definition      `json:"definitions" yaml:"definitions"`
+
+	SecurityDefinitions map[string]Yaml_SecurityScheme `json:"securityDefinitions,omitempty" yaml:"securityDefinitions,omitempty"`
 }
 
 func (this *SwaggerClass) recuGetParams(paramsType reflect.Type, paramsVal reflect.Value, properties map[string]Yaml_Property, requiredParams *[]string, parameters *[]Yaml_Parameter) {
@@ -141,7 +155,7 @@ func (this *SwaggerClass) recuPostParams(paramsType reflect.Type, paramsVal refl
 	for i := 0; i < paramsType.NumField(); i++ {
 		field := paramsType.Field(i)
 		fieldVal := paramsVal.Field(i)
-		if field.Type.String() == `os.File` {
+		if field.Type.String() == `os.File` || upload.IsFileType(field.Type) {
 			realParamName := field.Tag.Get(`json`)
 			properties[realParamName] = Yaml_Property{
 				Type:        `file`,
@@ -151,7 +165,7 @@ func (this *SwaggerClass) recuPostParams(paramsType reflect.Type, paramsVal refl
 			if strings.Contains(field.Tag.Get(`validate`), `required`) {
 				*requiredParams = append(*requiredParams, realParamName)
 			}
-		} else if field.Type.Kind() == reflect.Struct {
+		} else if field.Type.Kind() == reflect.Struct && !api_session.ParamDecoder.IsValueType(field.Type) { // 结构体字段展开，time.Time 等按单个值解码的类型除外
 			this.recuPostParams(field.Type, fieldVal, properties, requiredParams)
 		} else {
 			realParamName := field.Tag.Get(`json`)
@@ -187,12 +201,22 @@ func (this *SwaggerClass) getType(typeName string) string {
 	return result
 }
 
+// 生成默认服务的swagger文档
 func (this *SwaggerClass) GeneSwagger(hostAndPort string, filename string, type_ string) {
+	this.GeneSwaggerForService(go_core.Service, hostAndPort, filename, type_)
+}
+
+// 生成指定服务的swagger文档
+func (this *SwaggerClass) GeneSwaggerForService(svc *service.ServiceClass, hostAndPort string, filename string, type_ string) {
 	definitions := map[string]Yaml_Definition{}
+	securityDefinitions := map[string]Yaml_SecurityScheme{}
 
 	paths := map[string]map[string]Yaml_Path{}
 
-	for _, api := range go_core.Service.GetApis() {
+	for _, api := range svc.GetApis() {
+		if err := api.ResolveTypedController(); err != nil {
+			panic(err)
+		}
 		temp := map[string]Yaml_Path{}
 
 		desc := api.Description
@@ -200,9 +224,11 @@ func (this *SwaggerClass) GeneSwagger(hostAndPort string, filename string, type_
 		parameters := []Yaml_Parameter{}
 
 		description := ``
-		if api.Strategies != nil {
-			for _, strategy := range api.Strategies {
-				if strategy.Disable == false && strategy.Strategy.GetName() == `jwtAuth` {
+		security := []map[string][]string{}
+		strategies := api.GetEffectiveStrategies(svc.GetGlobalApiStrategyDriver()) // 与实际执行的策略保持一致
+		if len(strategies) > 0 {
+			for _, strategy := range strategies {
+				if strategy.Strategy.GetName() == `jwtAuth` {
 					// 添加 jwt header
 					parameters = append(parameters, Yaml_Parameter{
 						Name:        `Json-Web-Token`,
@@ -212,21 +238,42 @@ func (this *SwaggerClass) GeneSwagger(hostAndPort string, filename string, type_
 						Type:        this.getType(`string`),
 					})
 				}
-				description += strategy.Strategy.GetName() + ": " + strategy.Strategy.GetDescription() + "\n"
+				if securityStrategy, ok := strategy.Strategy.(api_strategy.InterfaceSecurityStrategy); ok {
+					// 任选其一的安全定义。apiKey 类型不能在 security 中声明权限范围，写在描述中
+					for _, scheme := range securityStrategy.GetSecuritySchemes() {
+						securityDefinitions[scheme.Name] = Yaml_SecurityScheme{
+							Type:        scheme.Type,
+							Name:        scheme.KeyName,
+							In:          scheme.In,
+							Description: scheme.Description,
+						}
+						security = append(security, map[string][]string{
+							scheme.Name: {},
+						})
+					}
+					if scopes := securityStrategy.GetRequiredScopes(strategy.Param); len(scopes) > 0 {
+						description += "scopes: " + strings.Join(scopes, ", ") + "\n"
+					}
+				}
+				description += strategy.Strategy.GetName() + ": " + strategy.Strategy.GetDescription()
+				if describer, ok := strategy.Strategy.(api_strategy.InterfaceDocDescriber); ok {
+					if docDescription := describer.GetDocDescription(strategy.Param); docDescription != `` {
+						description += " (" + docDescription + ")"
+					}
+				}
+				description += "\n"
 			}
 		}
 
 		if api.Params != nil {
-			paramsType := reflect.TypeOf(api.Params)
-			if paramsType.Kind() == reflect.Ptr {
-				paramsType = paramsType.Elem()
-			}
+			paramsVal := reflect.Indirect(reflect.ValueOf(api.Params)) // Params 也可以是结构体指针
+			paramsType := paramsVal.Type()
 			paramsTypeName := paramsType.Name()
 			requiredParams := []string{}
 			// 解析 properties
 			properties := map[string]Yaml_Property{}
 			if api.Method == `POST` {
-				this.recuPostParams(paramsType, reflect.ValueOf(api.Params), properties, &requiredParams)
+				this.recuPostParams(paramsType, paramsVal, properties, &requiredParams)
 				parameter := Yaml_Parameter{
 					In:       `body`,
 					Name:     `body`,
@@ -237,7 +284,7 @@ func (this *SwaggerClass) GeneSwagger(hostAndPort string, filename string, type_
 				}
 				parameters = append(parameters, parameter)
 			} else if api.Method == `GET` {
-				this.recuGetParams(paramsType, reflect.ValueOf(api.Params), properties, &requiredParams, &parameters)
+				this.recuGetParams(paramsType, paramsVal, properties, &requiredParams, &parameters)
 			} else {
 				go_error.Throw(`method error`, 0)
 			}
@@ -250,12 +297,12 @@ func (this *SwaggerClass) GeneSwagger(hostAndPort string, filename string, type_
 
 		responses := map[string]Yaml_Response{}
 		if api.Return != nil {
-			type_ := reflect.TypeOf(api.Return)
-			returnTypeName := type_.Name()
-			kind := type_.Kind()
+			returnVal := reflect.Indirect(reflect.ValueOf(api.Return)) // Return 也可以是结构体指针
+			returnTypeName := returnVal.Type().Name()
+			kind := returnVal.Kind()
 			properties := map[string]Yaml_Property{}
 			if kind == reflect.Struct {
-				this.recuReturn(go_format.Format.StructToMap(api.Return), properties)
+				this.recuReturn(go_format.Format.StructToMap(returnVal.Interface()), properties)
 			} else {
 				go_error.ThrowInternal(`return config type error`)
 			}
@@ -271,43 +318,70 @@ func (this *SwaggerClass) GeneSwagger(hostAndPort string, filename string, type_
 			}
 		}
 
+		produces := []string{}
+		for _, serializer := range api_session.Serializers.GetAllowed(api.Formats) {
+			produces = append(produces, serializer.GetMediaTypes()[0])
+		}
+		if api.SseController != nil { // 事件流路由，Return 描述的是每个事件的 data
+			produces = []string{`text/event-stream`}
+			description += "stream: server-sent events, reconnect with Last-Event-ID\n"
+			response := responses[`200`]
+			response.Description = `事件流`
+			responses[`200`] = response
+		}
+		if api.WebSocketController != nil { // WebSocket 路由，Return 描述的是服务端发送的消息
+			produces = []string{}
+			description += "websocket: upgrade with GET, messages are json text frames\n"
+			response := responses[`101`]
+			response.Description = `切换到 WebSocket`
+			response.Schema = responses[`200`].Schema
+			responses[`101`] = response
+			delete(responses, `200`)
+		}
 		paramTypes := []string{}
 		if api.ParamType == global_api_strategy.ALL_TYPE {
 			paramTypes = append(paramTypes, `application/json`, `multipart/form-data`)
+			for _, mediaType := range produces { // 其他注册过的格式也可以作为请求体
+				if mediaType != `application/json` {
+					paramTypes = append(paramTypes, mediaType)
+				}
+			}
 		} else {
 			paramTypes = append(paramTypes, api.ParamType)
 		}
 
 		temp[strings.ToLower(string(api.Method))] = Yaml_Path{
-			Tags:        []string{go_core.Service.GetName()},
+			Tags:        []string{svc.GetName()},
 			Summary:     desc,
 			Consumes:    paramTypes,
-			Produces:    []string{`application/json`},
+			Produces:    produces,
 			Parameters:  parameters,
 			Responses:   responses,
 			Description: description,
+			Security:    security,
 		}
-		paths[go_core.Service.GetPath()+api.Path] = temp
+		paths[svc.GetPath()+api.Path] = temp
 	}
 
 	swagger := Yaml_Swagger{
 		`2.0`,
 		Yaml_Info{
-			Title:       go_core.Service.GetName(),
-			Description: go_core.Service.GetDescription(),
+			Title:       svc.GetName(),
+			Description: svc.GetDescription(),
 			Version:     `1.0.0`,
 		},
 		hostAndPort,
-		go_core.Service.GetPath(),
+		svc.GetPath(),
 		[]Yaml_Tag{
 			{
-				Name:        go_core.Service.GetName(),
-				Description: go_core.Service.GetDescription(),
+				Name:        svc.GetName(),
+				Description: svc.GetDescription(),
 			},
 		},
 		[]string{`http`},
 		paths,
 		definitions,
+		securityDefinitions,
 	}
 
 	if type_ == `yaml` {
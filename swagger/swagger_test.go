@@ -1,9 +1,137 @@
 package swagger
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	go_core "github.com/pefish/go-core"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	"github.com/pefish/go-core/service"
 )
 
 func TestSwaggerClass_Test(t *testing.T) {
 
 }
+
+func TestSwaggerClass_SecurityDefinitions(t *testing.T) {
+	apiKeyStrategy := api_strategy.NewApiKeyStrategy()
+	apiKeyStrategy.SetProvider(api_strategy.NewMemoryApiKeyProvider())
+	svc := go_core.NewStandaloneService(`test`)
+	svc.SetRoutes([]*api.Api{
+		{
+			Path:   `/orders`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: apiKeyStrategy,
+					Param:    api_strategy.ApiKeyParam{Scopes: []string{`orders:read`}},
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return nil
+			},
+		},
+		{
+			Path:   `/admin`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: api_strategy.NewAuthorizationStrategy(),
+					Param:    api_strategy.AuthorizationParam{Roles: []string{`admin`}},
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return nil
+			},
+		},
+	})
+	result := geneSwaggerJson(t, svc)
+	if result.SecurityDefinitions[`apiKeyHeader`].Name != `X-Api-Key` || result.SecurityDefinitions[`apiKeyQuery`].In != `query` {
+		t.Errorf(`unexpected security definitions %v`, result.SecurityDefinitions)
+	}
+	path := result.Paths[svc.GetPath()+`/orders`][`get`]
+	if len(path.Security) != 2 || !strings.Contains(path.Description, `scopes: orders:read`) {
+		t.Errorf(`unexpected path security %v, description %s`, path.Security, path.Description)
+	}
+	if description := result.Paths[svc.GetPath()+`/admin`][`get`].Description; !strings.Contains(description, `(roles any of admin)`) {
+		t.Errorf(`authorization requirements should be documented, got %s`, description)
+	}
+}
+
+// Params、Return 是结构体指针时与结构体一样生成文档
+func TestSwaggerClass_PointerParams(t *testing.T) {
+	type orderParams struct {
+		Id string `json:"id" validate:"required" desc:"order id"`
+	}
+	type orderReturn struct {
+		Status string `json:"status"`
+	}
+	svc := go_core.NewStandaloneService(`test`)
+	svc.SetRoutes([]*api.Api{
+		{
+			Path:   `/order`,
+			Method: api_session.ApiMethod_Post,
+			Params: &orderParams{},
+			Return: &orderReturn{},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return nil
+			},
+		},
+	})
+	result := geneSwaggerJson(t, svc)
+	if properties := result.Definitions[`orderParams`].Properties; properties[`id`].Description != `order id` {
+		t.Errorf(`unexpected params definition %v`, result.Definitions[`orderParams`])
+	}
+	if _, ok := result.Definitions[`/order_orderReturn`].Properties[`status`]; !ok {
+		t.Errorf(`unexpected return definition %v`, result.Definitions)
+	}
+}
+
+// 具名的结构体字段展开到同一层，time.Time 作为普通字段
+func TestSwaggerClass_NestedParams(t *testing.T) {
+	type pageParam struct {
+		Page uint64 `json:"page" validate:"required"`
+	}
+	type listParams struct {
+		Paging pageParam `json:"paging"`
+		Since  time.Time `json:"since"`
+	}
+	svc := go_core.NewStandaloneService(`test`)
+	svc.SetRoutes([]*api.Api{
+		{
+			Path:   `/list`,
+			Method: api_session.ApiMethod_Post,
+			Params: listParams{},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return nil
+			},
+		},
+	})
+	definition := geneSwaggerJson(t, svc).Definitions[`listParams`]
+	if _, ok := definition.Properties[`page`]; !ok || len(definition.Properties) != 2 || len(definition.Required) != 1 || definition.Required[0] != `page` {
+		t.Errorf(`unexpected definition %v`, definition)
+	}
+	if _, ok := definition.Properties[`since`]; !ok {
+		t.Errorf(`time field should be documented as a single property, got %v`, definition.Properties)
+	}
+}
+
+func geneSwaggerJson(t *testing.T, svc *service.ServiceClass) Yaml_Swagger {
+	filename := filepath.Join(t.TempDir(), `swagger.json`)
+	GetSwaggerInstance().GeneSwaggerForService(svc, `localhost:8000`, filename, `json`)
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := Yaml_Swagger{}
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
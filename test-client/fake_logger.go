@@ -0,0 +1,81 @@
+package test_client
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 记录日志的假日志器，测试中可以替换服务的日志驱动，之后检查输出了哪些日志
+type FakeLoggerClass struct {
+	lock    sync.Mutex
+	Entries []LogEntry
+}
+
+type LogEntry struct {
+	Level   string
+	Message string
+}
+
+func NewFakeLogger() *FakeLoggerClass {
+	return &FakeLoggerClass{
+		Entries: []LogEntry{},
+	}
+}
+
+func (this *FakeLoggerClass) record(level string, message string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Entries = append(this.Entries, LogEntry{
+		Level:   level,
+		Message: message,
+	})
+}
+
+// 获取某个级别的所有日志
+func (this *FakeLoggerClass) GetEntries(level string) []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	result := make([]string, 0)
+	for _, entry := range this.Entries {
+		if entry.Level == level {
+			result = append(result, entry.Message)
+		}
+	}
+	return result
+}
+
+func (this *FakeLoggerClass) Close() {
+
+}
+
+func (this *FakeLoggerClass) Debug(args ...interface{}) {
+	this.record(`debug`, fmt.Sprint(args...))
+}
+
+func (this *FakeLoggerClass) DebugF(format string, args ...interface{}) {
+	this.record(`debug`, fmt.Sprintf(format, args...))
+}
+
+func (this *FakeLoggerClass) Info(args ...interface{}) {
+	this.record(`info`, fmt.Sprint(args...))
+}
+
+func (this *FakeLoggerClass) InfoF(format string, args ...interface{}) {
+	this.record(`info`, fmt.Sprintf(format, args...))
+}
+
+func (this *FakeLoggerClass) Warn(args ...interface{}) {
+	this.record(`warn`, fmt.Sprint(args...))
+}
+
+func (this *FakeLoggerClass) WarnF(format string, args ...interface{}) {
+	this.record(`warn`, fmt.Sprintf(format, args...))
+}
+
+func (this *FakeLoggerClass) Error(args ...interface{}) {
+	this.record(`error`, fmt.Sprint(args...))
+}
+
+func (this *FakeLoggerClass) ErrorF(format string, args ...interface{}) {
+	this.record(`error`, fmt.Sprintf(format, args...))
+}
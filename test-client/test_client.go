@@ -0,0 +1,345 @@
+// 进程内测试客户端。不监听端口，直接把请求交给服务（http.Handler）处理，并解析统一返回结构
+package test_client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	go_core "github.com/pefish/go-core"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/service"
+	"github.com/pefish/go-jwt"
+)
+
+type TestClientClass struct {
+	t             testing.TB
+	handler       http.Handler
+	headers       map[string]string
+	jwtHeaderName string
+	jwtPrivKey    string
+	server        *httptest.Server // WebSocket 使用的本地服务器
+}
+
+// 新建测试客户端。handler 一般是 *service.ServiceClass。
+// 服务没有注册日志器的话会注册假日志器
+func NewTestClient(t testing.TB, handler http.Handler) *TestClientClass {
+	if svc, ok := handler.(*service.ServiceClass); ok && svc.GetLoggerDriver().Logger == nil {
+		svc.GetLoggerDriver().Register(NewFakeLogger())
+	}
+	privKey, _ := GetTestKeyPair()
+	return &TestClientClass{
+		t:             t,
+		handler:       handler,
+		headers:       map[string]string{},
+		jwtHeaderName: `Json-Web-Token`,
+		jwtPrivKey:    privKey,
+	}
+}
+
+// 新建独立的测试服务（见 go_core.NewStandaloneService）并设置路由，返回服务和它的测试客户端。
+// 路由在第一个请求时才生效，返回后仍然可以往服务上注册全局策略、外部服务
+func NewTestService(t testing.TB, routes []*api.Api) (*service.ServiceClass, *TestClientClass) {
+	svc := go_core.NewStandaloneService(`test`)
+	svc.SetRoutes(routes)
+	return svc, NewTestClient(t, svc)
+}
+
+// 设置每个请求都带上的header
+func (this *TestClientClass) SetHeader(key string, value string) *TestClientClass {
+	this.headers[key] = value
+	return this
+}
+
+// 设置jwt的header名和签名私钥，默认使用 Json-Web-Token 和测试密钥
+func (this *TestClientClass) SetJwt(headerName string, privKey string) *TestClientClass {
+	this.jwtHeaderName = headerName
+	this.jwtPrivKey = privKey
+	return this
+}
+
+func (this *TestClientClass) Get(path string) *RequestClass {
+	return this.NewRequest(string(api_session.ApiMethod_Get), path)
+}
+
+func (this *TestClientClass) Post(path string) *RequestClass {
+	return this.NewRequest(string(api_session.ApiMethod_Post), path)
+}
+
+func (this *TestClientClass) NewRequest(method string, path string) *RequestClass {
+	headers := map[string]string{}
+	for k, v := range this.headers {
+		headers[k] = v
+	}
+	return &RequestClass{
+		client:  this,
+		method:  method,
+		path:    path,
+		headers: headers,
+		query:   url.Values{},
+	}
+}
+
+type RequestClass struct {
+	client      *TestClientClass
+	method      string
+	path        string
+	headers     map[string]string
+	query       url.Values
+	body        io.Reader
+	contentType string
+	formValues  map[string]string
+	formFiles   []formFile
+}
+
+type formFile struct {
+	fieldName string
+	fileName  string
+	content   []byte
+}
+
+func (this *RequestClass) WithHeader(key string, value string) *RequestClass {
+	this.headers[key] = value
+	return this
+}
+
+// 带上 cookie
+func (this *RequestClass) WithCookie(name string, value string) *RequestClass {
+	cookie := (&http.Cookie{Name: name, Value: value}).String()
+	if existing := this.headers[`Cookie`]; existing != `` {
+		cookie = existing + `; ` + cookie
+	}
+	this.headers[`Cookie`] = cookie
+	return this
+}
+
+// 设置url参数
+func (this *RequestClass) WithQuery(params map[string]string) *RequestClass {
+	for k, v := range params {
+		this.query.Set(k, v)
+	}
+	return this
+}
+
+// 以 application/json 发送请求体
+func (this *RequestClass) WithJson(body interface{}) *RequestClass {
+	data, err := json.Marshal(body)
+	if err != nil {
+		this.client.t.Fatalf(`marshal json body error: %s`, err)
+	}
+	this.body = bytes.NewReader(data)
+	this.contentType = `application/json`
+	return this
+}
+
+// 发送原始请求体，Content-Type 通过 WithHeader 设置
+func (this *RequestClass) WithBody(body []byte) *RequestClass {
+	this.body = bytes.NewReader(body)
+	return this
+}
+
+// 以 application/x-www-form-urlencoded 发送请求体
+func (this *RequestClass) WithForm(values map[string]string) *RequestClass {
+	form := url.Values{}
+	for k, v := range values {
+		form.Set(k, v)
+	}
+	this.body = strings.NewReader(form.Encode())
+	this.contentType = `application/x-www-form-urlencoded`
+	return this
+}
+
+// 以 multipart/form-data 发送的普通字段
+func (this *RequestClass) WithMultipartValues(values map[string]string) *RequestClass {
+	if this.formValues == nil {
+		this.formValues = map[string]string{}
+	}
+	for k, v := range values {
+		this.formValues[k] = v
+	}
+	return this
+}
+
+// 以 multipart/form-data 发送的文件
+func (this *RequestClass) WithFile(fieldName string, fileName string, content []byte) *RequestClass {
+	this.formFiles = append(this.formFiles, formFile{
+		fieldName: fieldName,
+		fileName:  fileName,
+		content:   content,
+	})
+	return this
+}
+
+// 带上用测试密钥签名的jwt
+func (this *RequestClass) WithJwt(payload map[string]interface{}) *RequestClass {
+	token, err := go_jwt.Jwt.GetJwt(this.client.jwtPrivKey, time.Hour, payload)
+	if err != nil {
+		this.client.t.Fatalf(`sign jwt error: %s`, err)
+	}
+	this.headers[this.client.jwtHeaderName] = token
+	return this
+}
+
+// 构建 http.Request，测试中也可以直接使用
+func (this *RequestClass) Build() *http.Request {
+	body, contentType := this.body, this.contentType
+	if this.formValues != nil || len(this.formFiles) > 0 {
+		buffer := &bytes.Buffer{}
+		writer := multipart.NewWriter(buffer)
+		for k, v := range this.formValues {
+			writer.WriteField(k, v)
+		}
+		for _, file := range this.formFiles {
+			part, err := writer.CreateFormFile(file.fieldName, file.fileName)
+			if err != nil {
+				this.client.t.Fatalf(`create form file error: %s`, err)
+			}
+			part.Write(file.content)
+		}
+		writer.Close()
+		body, contentType = buffer, writer.FormDataContentType()
+	}
+	target := this.path
+	if len(this.query) > 0 {
+		target += `?` + this.query.Encode()
+	}
+	request := httptest.NewRequest(this.method, target, body)
+	if contentType != `` {
+		request.Header.Set(string(api_session.HeaderName_ContentType), contentType)
+	}
+	for k, v := range this.headers {
+		request.Header.Set(k, v)
+	}
+	return request
+}
+
+// 发送请求
+func (this *RequestClass) Do() *ResponseClass {
+	recorder := httptest.NewRecorder()
+	this.client.handler.ServeHTTP(recorder, this.Build())
+	return &ResponseClass{
+		t:        this.client.t,
+		Recorder: recorder,
+	}
+}
+
+type ResponseClass struct {
+	t         testing.TB
+	apiResult *api.ApiResult
+
+	Recorder *httptest.ResponseRecorder
+}
+
+func (this *ResponseClass) GetStatusCode() int {
+	return this.Recorder.Code
+}
+
+func (this *ResponseClass) GetBody() string {
+	return this.Recorder.Body.String()
+}
+
+func (this *ResponseClass) GetHeader(key string) string {
+	return this.Recorder.Header().Get(key)
+}
+
+// 响应设置的 cookie，没有的话返回nil
+func (this *ResponseClass) GetCookie(name string) *http.Cookie {
+	for _, cookie := range this.Recorder.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// 解析统一返回结构
+func (this *ResponseClass) GetApiResult() *api.ApiResult {
+	if this.apiResult == nil {
+		this.apiResult = &api.ApiResult{}
+		if err := json.Unmarshal(this.Recorder.Body.Bytes(), this.apiResult); err != nil {
+			this.t.Fatalf(`decode api result error: %s; body: %s`, err, this.GetBody())
+		}
+	}
+	return this.apiResult
+}
+
+// 把返回结构中的 data 解析到 dest
+func (this *ResponseClass) ScanData(dest interface{}) *ResponseClass {
+	type rawResult struct {
+		Data json.RawMessage `json:"data"`
+	}
+	result := rawResult{}
+	if err := json.Unmarshal(this.Recorder.Body.Bytes(), &result); err != nil {
+		this.t.Fatalf(`decode api result error: %s; body: %s`, err, this.GetBody())
+	}
+	if err := json.Unmarshal(result.Data, dest); err != nil {
+		this.t.Fatalf(`decode data error: %s; data: %s`, err, string(result.Data))
+	}
+	return this
+}
+
+func (this *ResponseClass) AssertStatus(statusCode int) *ResponseClass {
+	this.t.Helper()
+	if this.Recorder.Code != statusCode {
+		this.t.Errorf(`expect status %d, got %d; body: %s`, statusCode, this.Recorder.Code, this.GetBody())
+	}
+	return this
+}
+
+func (this *ResponseClass) AssertCode(code uint64) *ResponseClass {
+	this.t.Helper()
+	if result := this.GetApiResult(); result.Code != code {
+		this.t.Errorf(`expect code %d, got %d; msg: %s; internal_msg: %s`, code, result.Code, result.Msg, result.InternalMsg)
+	}
+	return this
+}
+
+func (this *ResponseClass) AssertMsg(msg string) *ResponseClass {
+	this.t.Helper()
+	if result := this.GetApiResult(); result.Msg != msg {
+		this.t.Errorf(`expect msg %s, got %s`, msg, result.Msg)
+	}
+	return this
+}
+
+var (
+	testKeyOnce sync.Once
+	testPrivKey string
+	testPubKey  string
+)
+
+// 获取测试用的RSA密钥对（PEM格式），进程内只生成一次。公钥用于配置 jwtAuth 策略
+func GetTestKeyPair() (privKey string, pubKey string) {
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		testPrivKey = string(pem.EncodeToMemory(&pem.Block{
+			Type:  `RSA PRIVATE KEY`,
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}))
+		pubBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			panic(err)
+		}
+		testPubKey = string(pem.EncodeToMemory(&pem.Block{
+			Type:  `PUBLIC KEY`,
+			Bytes: pubBytes,
+		}))
+	})
+	return testPrivKey, testPubKey
+}
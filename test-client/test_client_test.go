@@ -0,0 +1,92 @@
+package test_client_test
+
+import (
+	"testing"
+
+	go_core "github.com/pefish/go-core"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+	go_error "github.com/pefish/go-error"
+)
+
+type testParam struct {
+	Name string `json:"name" validate:"required"`
+}
+
+func TestTestClientClass_Do(t *testing.T) {
+	_, pubKey := test_client.GetTestKeyPair()
+	jwtStrategy := api_strategy.NewJwtAuthStrategy()
+	jwtStrategy.SetPubKey(pubKey)
+	jwtStrategy.SetHeaderName(`Json-Web-Token`)
+	jwtStrategy.SetErrorCode(2001)
+
+	svc := go_core.NewStandaloneService(`test`)
+	svc.SetPath(`/api`)
+	fakeLogger := test_client.NewFakeLogger()
+	svc.GetLoggerDriver().Register(fakeLogger)
+	svc.SetRoutes([]*api.Api{
+		{
+			Path:   `/hello`,
+			Method: api_session.ApiMethod_Post,
+			Params: testParam{},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				var params testParam
+				apiSession.ScanParams(&params)
+				return map[string]interface{}{
+					`hello`: params.Name,
+				}
+			},
+		},
+		{
+			Path:   `/typed`,
+			Method: api_session.ApiMethod_Post,
+			TypedController: func(apiSession *api_session.ApiSessionClass, params *testParam) (*testParam, error) {
+				return params, nil
+			},
+		},
+		{
+			Path:   `/me`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: jwtStrategy,
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				if apiSession.UserId != 12 {
+					go_error.Throw(`wrong user`, 3000)
+				}
+				return apiSession.UserId
+			},
+		},
+	})
+
+	client := test_client.NewTestClient(t, svc)
+	result := struct {
+		Hello string `json:"hello"`
+	}{}
+	client.Post(`/api/hello`).WithJson(map[string]interface{}{`name`: `go-core`}).Do().AssertStatus(200).AssertCode(0).ScanData(&result)
+	if result.Hello != `go-core` {
+		t.Errorf(`unexpected data %s`, result.Hello)
+	}
+	client.Post(`/api/hello`).WithMultipartValues(map[string]string{`name`: `form`}).Do().AssertCode(0)
+	client.Post(`/api/hello`).WithForm(map[string]string{`name`: `urlencoded`}).Do().AssertCode(0).ScanData(&result)
+	if result.Hello != `urlencoded` {
+		t.Errorf(`unexpected data %s`, result.Hello)
+	}
+	client.Post(`/api/hello`).WithJson(map[string]interface{}{}).Do().AssertCode(go_error.INTERNAL_ERROR_CODE)
+
+	typedResult := testParam{}
+	client.Post(`/api/typed`).WithJson(map[string]interface{}{`name`: `typed`}).Do().AssertCode(0).ScanData(&typedResult)
+	if typedResult.Name != `typed` {
+		t.Errorf(`unexpected data %s`, typedResult.Name)
+	}
+
+	client.Get(`/api/me`).WithJwt(map[string]interface{}{`user_id`: 12}).Do().AssertCode(0)
+	client.Get(`/api/me`).Do().AssertCode(2001).AssertMsg(`Unauthorized`)
+	if len(fakeLogger.GetEntries(`error`)) == 0 {
+		t.Error(`errors should be logged`)
+	}
+}
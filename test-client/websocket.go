@@ -0,0 +1,86 @@
+package test_client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/pefish/go-core/api"
+	"github.com/pefish/go-core/websocket"
+)
+
+// WebSocket 需要真实的连接，第一次使用时启动一个本地测试服务器，测试结束时关闭
+func (this *TestClientClass) getServer() *httptest.Server {
+	if this.server == nil {
+		this.server = httptest.NewServer(this.handler)
+		this.t.Cleanup(this.server.Close)
+	}
+	return this.server
+}
+
+type WebSocketConnClass struct {
+	*websocket.ConnClass
+	t testing.TB
+}
+
+// 以当前请求的路径、url参数和header（包括jwt）建立 WebSocket 连接。
+// 升级失败时返回服务端的响应，比如策略拒绝时可以从中读取错误结果
+func (this *RequestClass) DialWebSocket() (*WebSocketConnClass, *http.Response, error) {
+	target := `ws` + strings.TrimPrefix(this.client.getServer().URL, `http`) + this.path
+	if len(this.query) > 0 {
+		target += `?` + this.query.Encode()
+	}
+	header := http.Header{}
+	for k, v := range this.headers {
+		header.Set(k, v)
+	}
+	conn, response, err := gorilla.DefaultDialer.Dial(target, header)
+	if err != nil {
+		return nil, response, err
+	}
+	return &WebSocketConnClass{
+		ConnClass: websocket.NewConn(conn, websocket.OptionClass{}),
+		t:         this.client.t,
+	}, response, nil
+}
+
+// 建立 WebSocket 连接，失败的话测试失败
+func (this *RequestClass) MustDialWebSocket() *WebSocketConnClass {
+	this.client.t.Helper()
+	conn, _, err := this.DialWebSocket()
+	if err != nil {
+		this.client.t.Fatalf(`dial websocket error: %s`, err)
+	}
+	this.client.t.Cleanup(func() {
+		conn.Close(websocket.CloseNormalClosure, ``)
+	})
+	return conn
+}
+
+// 以 json 发送消息
+func (this *WebSocketConnClass) Send(data interface{}) *WebSocketConnClass {
+	this.t.Helper()
+	if err := this.WriteJson(data); err != nil {
+		this.t.Fatalf(`send websocket message error: %s`, err)
+	}
+	return this
+}
+
+// 接收一条 json 消息
+func (this *WebSocketConnClass) Receive(dest interface{}) *WebSocketConnClass {
+	this.t.Helper()
+	if err := this.ReadJson(dest); err != nil {
+		this.t.Fatalf(`receive websocket message error: %s`, err)
+	}
+	return this
+}
+
+// 接收一条统一返回结构的消息，服务端出错时会发送这样的消息
+func (this *WebSocketConnClass) ReceiveApiResult() *api.ApiResult {
+	this.t.Helper()
+	result := &api.ApiResult{}
+	this.Receive(result)
+	return result
+}
@@ -0,0 +1,110 @@
+package token_service
+
+import (
+	"sync"
+	"time"
+)
+
+// 刷新 token 的记录，只保存 token 的 hash。同一次登录轮换出来的刷新 token 属于同一个 family
+type RefreshTokenRecord struct {
+	Hash     string                 `json:"hash"`
+	FamilyId string                 `json:"family_id"`
+	UserId   uint64                 `json:"user_id"`
+	Payload  map[string]interface{} `json:"payload"` // 刷新时签发的 jwt 使用同样的 payload
+	ExpireAt time.Time              `json:"expire_at"`
+	Used     bool                   `json:"used"`
+}
+
+// 刷新 token 的存储。多实例部署时需要实现成共享存储，MarkUsed 必须是原子操作
+type InterfaceRefreshTokenStore interface {
+	Save(record *RefreshTokenRecord) error
+	// 不存在的话返回 nil, nil
+	Get(hash string) (*RefreshTokenRecord, error)
+	// 标记为已使用。不存在或者已经使用过的话返回 false
+	MarkUsed(hash string) (bool, error)
+	// 删除整个 family，这次登录轮换出来的刷新 token 都不能再用
+	RevokeFamily(familyId string) error
+}
+
+// 内存存储，只适用于单实例部署
+type MemoryRefreshTokenStoreClass struct {
+	sync.Mutex
+	records   map[string]*RefreshTokenRecord // hash -> 记录
+	families  map[string][]string            // family id -> hash
+	saveCount int
+}
+
+// 每保存多少次清理一次过期的记录
+const memoryRefreshTokenCleanInterval = 100
+
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStoreClass {
+	return &MemoryRefreshTokenStoreClass{
+		records:  map[string]*RefreshTokenRecord{},
+		families: map[string][]string{},
+	}
+}
+
+func (this *MemoryRefreshTokenStoreClass) Save(record *RefreshTokenRecord) error {
+	this.Lock()
+	defer this.Unlock()
+	this.saveCount++
+	if this.saveCount%memoryRefreshTokenCleanInterval == 0 {
+		this.clean()
+	}
+	saved := *record
+	this.records[record.Hash] = &saved
+	this.families[record.FamilyId] = append(this.families[record.FamilyId], record.Hash)
+	return nil
+}
+
+func (this *MemoryRefreshTokenStoreClass) Get(hash string) (*RefreshTokenRecord, error) {
+	this.Lock()
+	defer this.Unlock()
+	record, ok := this.records[hash]
+	if !ok {
+		return nil, nil
+	}
+	result := *record
+	return &result, nil
+}
+
+func (this *MemoryRefreshTokenStoreClass) MarkUsed(hash string) (bool, error) {
+	this.Lock()
+	defer this.Unlock()
+	record, ok := this.records[hash]
+	if !ok || record.Used {
+		return false, nil
+	}
+	record.Used = true
+	return true, nil
+}
+
+func (this *MemoryRefreshTokenStoreClass) RevokeFamily(familyId string) error {
+	this.Lock()
+	defer this.Unlock()
+	for _, hash := range this.families[familyId] {
+		delete(this.records, hash)
+	}
+	delete(this.families, familyId)
+	return nil
+}
+
+// 删除过期的记录，调用前需要加锁
+func (this *MemoryRefreshTokenStoreClass) clean() {
+	now := time.Now()
+	for familyId, hashes := range this.families {
+		alive := hashes[:0]
+		for _, hash := range hashes {
+			if record, ok := this.records[hash]; !ok || now.After(record.ExpireAt) {
+				delete(this.records, hash)
+				continue
+			}
+			alive = append(alive, hash)
+		}
+		if len(alive) == 0 {
+			delete(this.families, familyId)
+		} else {
+			this.families[familyId] = alive
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package token_service
+
+import (
+	"github.com/pefish/go-core/api"
+	"github.com/pefish/go-core/api-session"
+	"github.com/pefish/go-core/api-strategy"
+	"github.com/pefish/go-error"
+)
+
+type RefreshParam struct {
+	RefreshToken string `json:"refresh_token" validate:"required" desc:"刷新 token"`
+}
+
+// 刷新 token 的路由，POST { refresh_token } 返回新的 TokenPair
+func (this *TokenServiceClass) GetRefreshRoute(path string) *api.Api {
+	return &api.Api{
+		Description: `refresh access token`,
+		Path:        path,
+		Method:      api_session.ApiMethod_Post,
+		TypedController: func(apiSession *api_session.ApiSessionClass, params RefreshParam) (*TokenPair, error) {
+			tokenPair, err := this.Refresh(params.RefreshToken)
+			if err != nil {
+				return nil, this.toErrorInfo(err)
+			}
+			return tokenPair, nil
+		},
+	}
+}
+
+// 退出登录的路由，需要 jwt 鉴权，吊销当前的访问 token 和这次登录的刷新 token
+func (this *TokenServiceClass) GetLogoutRoute(path string) *api.Api {
+	return &api.Api{
+		Description: `logout`,
+		Path:        path,
+		Method:      api_session.ApiMethod_Post,
+		Strategies: []api_strategy.StrategyData{
+			{
+				Strategy: this.jwtStrategy,
+			},
+		},
+		Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+			if err := this.RevokeClaims(apiSession.JwtBody); err != nil {
+				go_error.ThrowInternalError(`revoke token error`, err)
+			}
+			return true
+		},
+	}
+}
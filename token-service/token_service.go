@@ -0,0 +1,237 @@
+package token_service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	jwt2 "github.com/dgrijalva/jwt-go"
+	"github.com/pefish/go-core/api-strategy"
+	"github.com/pefish/go-error"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New(`invalid refresh token`)
+	ErrRefreshTokenReused  = errors.New(`refresh token reused`) // 用过的刷新 token 再次使用，可能已经泄露，整个 family 被吊销
+)
+
+/**
+jwt 签发服务，和 jwt 鉴权策略使用同一对密钥。签发短期的访问 token（jwt）和长期的刷新 token（随机字符串，只保存 hash），
+刷新时轮换刷新 token，用过的刷新 token 再次使用的话吊销这次登录的所有刷新 token。
+访问 token 中带有 jti，吊销后鉴权策略会拒绝
+*/
+type TokenServiceClass struct {
+	jwtStrategy       *api_strategy.JwtAuthStrategyClass
+	privKey           string
+	accessTtl         time.Duration
+	refreshTtl        time.Duration
+	errorCode         uint64
+	revocationList    api_strategy.InterfaceRevocationList
+	refreshTokenStore InterfaceRefreshTokenStore
+}
+
+// 访问 token 和刷新 token
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // 访问 token 多少秒后过期
+}
+
+// 新建签发服务。privKey 是 jwt 鉴权策略公钥对应的私钥（RS256）。会给鉴权策略设置吊销列表
+func NewTokenService(jwtStrategy *api_strategy.JwtAuthStrategyClass, privKey string) *TokenServiceClass {
+	service := &TokenServiceClass{
+		jwtStrategy:       jwtStrategy,
+		privKey:           privKey,
+		accessTtl:         15 * time.Minute,
+		refreshTtl:        30 * 24 * time.Hour,
+		errorCode:         go_error.INTERNAL_ERROR_CODE,
+		refreshTokenStore: NewMemoryRefreshTokenStore(),
+	}
+	service.SetRevocationList(api_strategy.NewMemoryRevocationList())
+	return service
+}
+
+// 访问 token 有效期，默认15分钟
+func (this *TokenServiceClass) SetAccessTtl(ttl time.Duration) {
+	this.accessTtl = ttl
+}
+
+// 刷新 token 有效期，默认30天
+func (this *TokenServiceClass) SetRefreshTtl(ttl time.Duration) {
+	this.refreshTtl = ttl
+}
+
+// 刷新路由出错时的错误码
+func (this *TokenServiceClass) SetErrorCode(code uint64) {
+	this.errorCode = code
+}
+
+func (this *TokenServiceClass) GetErrorCode() uint64 {
+	return this.errorCode
+}
+
+// 设置吊销列表，同时设置给 jwt 鉴权策略
+func (this *TokenServiceClass) SetRevocationList(revocationList api_strategy.InterfaceRevocationList) {
+	this.revocationList = revocationList
+	this.jwtStrategy.SetRevocationList(revocationList)
+}
+
+func (this *TokenServiceClass) SetRefreshTokenStore(store InterfaceRefreshTokenStore) {
+	this.refreshTokenStore = store
+}
+
+// 访问 token 所在的请求头，和 jwt 鉴权策略一致
+func (this *TokenServiceClass) GetHeaderName() string {
+	return this.jwtStrategy.GetHeaderName()
+}
+
+// 登录成功后签发 token。payload 中会加上 user_id
+func (this *TokenServiceClass) Sign(userId uint64, payload map[string]interface{}) (*TokenPair, error) {
+	claims := map[string]interface{}{}
+	for key, value := range payload {
+		claims[key] = value
+	}
+	claims[`user_id`] = userId
+	return this.issue(userId, claims, newRandomId())
+}
+
+// 用刷新 token 换新的 token，旧的刷新 token 作废
+func (this *TokenServiceClass) Refresh(refreshToken string) (*TokenPair, error) {
+	hash := hashToken(refreshToken)
+	record, err := this.refreshTokenStore.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || time.Now().After(record.ExpireAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if record.Used {
+		return nil, this.revokeReusedFamily(record.FamilyId)
+	}
+	marked, err := this.refreshTokenStore.MarkUsed(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !marked { // 并发刷新，另一个请求已经用过了
+		return nil, this.revokeReusedFamily(record.FamilyId)
+	}
+	return this.issue(record.UserId, record.Payload, record.FamilyId)
+}
+
+// 吊销访问 token 和它所属登录的所有刷新 token（退出登录）
+func (this *TokenServiceClass) Revoke(accessToken string) error {
+	parser := jwt2.Parser{
+		SkipClaimsValidation: true, // 过期的 token 也可以吊销
+	}
+	token, err := parser.Parse(accessToken, func(token *jwt2.Token) (interface{}, error) {
+		return jwt2.ParseRSAPublicKeyFromPEM([]byte(this.jwtStrategy.GetPubKey()))
+	})
+	if err != nil {
+		return err
+	}
+	return this.RevokeClaims(token.Claims.(jwt2.MapClaims))
+}
+
+// 按 jwt 鉴权策略解析出的 claims 吊销，例如 this.RevokeClaims(apiSession.JwtBody)
+func (this *TokenServiceClass) RevokeClaims(claims map[string]interface{}) error {
+	tokenId, _ := claims[`jti`].(string)
+	if tokenId == `` {
+		return errors.New(`jwt has no jti`)
+	}
+	expireAt := time.Now().Add(this.accessTtl)
+	if exp, ok := claims[`exp`].(float64); ok {
+		expireAt = time.Unix(int64(exp), 0)
+	}
+	if err := this.revocationList.Revoke(tokenId, expireAt); err != nil {
+		return err
+	}
+	if familyId, ok := claims[`fid`].(string); ok && familyId != `` {
+		return this.refreshTokenStore.RevokeFamily(familyId)
+	}
+	return nil
+}
+
+// 吊销刷新 token 所属登录的所有刷新 token
+func (this *TokenServiceClass) RevokeRefreshToken(refreshToken string) error {
+	record, err := this.refreshTokenStore.Get(hashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return ErrInvalidRefreshToken
+	}
+	return this.refreshTokenStore.RevokeFamily(record.FamilyId)
+}
+
+// 签发一对 token，刷新 token 属于 familyId
+func (this *TokenServiceClass) issue(userId uint64, payload map[string]interface{}, familyId string) (*TokenPair, error) {
+	signKey, err := jwt2.ParseRSAPrivateKeyFromPEM([]byte(this.privKey))
+	if err != nil {
+		return nil, fmt.Errorf(`parse private key error: %v`, err)
+	}
+	now := time.Now()
+	token := jwt2.New(jwt2.SigningMethodRS256)
+	token.Claims = jwt2.MapClaims{
+		`exp`:     now.Add(this.accessTtl).Unix(),
+		`iat`:     now.Unix(),
+		`jti`:     newRandomId(),
+		`fid`:     familyId,
+		`payload`: payload,
+	}
+	accessToken, err := token.SignedString(signKey)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken := newRandomId() + newRandomId()
+	err = this.refreshTokenStore.Save(&RefreshTokenRecord{
+		Hash:     hashToken(refreshToken),
+		FamilyId: familyId,
+		UserId:   userId,
+		Payload:  payload,
+		ExpireAt: now.Add(this.refreshTtl),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(this.accessTtl / time.Second),
+	}, nil
+}
+
+func (this *TokenServiceClass) revokeReusedFamily(familyId string) error {
+	if err := this.refreshTokenStore.RevokeFamily(familyId); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// 转换成路由返回的错误
+func (this *TokenServiceClass) toErrorInfo(err error) error {
+	if err == ErrInvalidRefreshToken || err == ErrRefreshTokenReused {
+		return &go_error.ErrorInfo{
+			ErrorMessage: err.Error(),
+			ErrorCode:    this.errorCode,
+			Err:          err,
+		}
+	}
+	return err
+}
+
+func newRandomId() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,64 @@
+package token_service_test
+
+import (
+	"testing"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+	token_service "github.com/pefish/go-core/token-service"
+)
+
+// 刷新时更换刷新 token，旧的刷新 token 再次使用时吊销整个 family；退出登录后 access token 和刷新 token 都失效
+func TestTokenServiceClass_Refresh(t *testing.T) {
+	privKey, pubKey := test_client.GetTestKeyPair()
+	jwtStrategy := api_strategy.NewJwtAuthStrategy()
+	jwtStrategy.SetPubKey(pubKey)
+	jwtStrategy.SetHeaderName(`Json-Web-Token`)
+	jwtStrategy.SetErrorCode(2001)
+	tokenService := token_service.NewTokenService(jwtStrategy, privKey)
+	tokenService.SetErrorCode(2006)
+
+	_, client := test_client.NewTestService(t, []*api.Api{
+		tokenService.GetRefreshRoute(`/token/refresh`),
+		tokenService.GetLogoutRoute(`/logout`),
+		{
+			Path:   `/me`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: jwtStrategy,
+				},
+			},
+			Controller: func(apiSession *api_session.ApiSessionClass) interface{} {
+				return apiSession.UserId
+			},
+		},
+	})
+
+	tokenPair, err := tokenService.Sign(7, map[string]interface{}{`name`: `test`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Get(`/me`).WithHeader(`Json-Web-Token`, tokenPair.AccessToken).Do().AssertCode(0)
+
+	refreshed := token_service.TokenPair{}
+	client.Post(`/token/refresh`).WithJson(map[string]interface{}{`refresh_token`: tokenPair.RefreshToken}).Do().AssertCode(0).ScanData(&refreshed)
+	if refreshed.RefreshToken == `` || refreshed.RefreshToken == tokenPair.RefreshToken {
+		t.Fatalf(`refresh token should rotate`)
+	}
+	client.Get(`/me`).WithHeader(`Json-Web-Token`, refreshed.AccessToken).Do().AssertCode(0)
+
+	// 旧的刷新 token 再次使用，整个 family 被吊销
+	client.Post(`/token/refresh`).WithJson(map[string]interface{}{`refresh_token`: tokenPair.RefreshToken}).Do().AssertCode(2006).AssertMsg(`refresh token reused`)
+	client.Post(`/token/refresh`).WithJson(map[string]interface{}{`refresh_token`: refreshed.RefreshToken}).Do().AssertCode(2006).AssertMsg(`invalid refresh token`)
+
+	tokenPair, err = tokenService.Sign(8, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Post(`/logout`).WithHeader(`Json-Web-Token`, tokenPair.AccessToken).WithJson(map[string]interface{}{}).Do().AssertCode(0)
+	client.Get(`/me`).WithHeader(`Json-Web-Token`, tokenPair.AccessToken).Do().AssertCode(2001)
+	client.Post(`/token/refresh`).WithJson(map[string]interface{}{`refresh_token`: tokenPair.RefreshToken}).Do().AssertCode(2006)
+}
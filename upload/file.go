@@ -0,0 +1,66 @@
+package upload
+
+import (
+	"io"
+	"reflect"
+)
+
+// 上传的文件。内容已经保存到存储后端，通过 Open 读取
+type FileClass struct {
+	FieldName           string // 表单字段名
+	FileName            string // 客户端给出的文件名
+	DeclaredContentType string // 客户端声明的类型
+	ContentType         string // 根据文件内容嗅探出的类型，不参考扩展名，嗅探不出来的话是 application/octet-stream
+	Size                int64
+	Key                 string // 在存储后端中的key
+
+	storage InterfaceStorage
+	kept    *bool // 解码参数时文件会被复制，副本共享保留状态
+}
+
+func (this *FileClass) GetSize() int64 {
+	return this.Size
+}
+
+func (this *FileClass) GetContentType() string {
+	return this.ContentType
+}
+
+func (this *FileClass) GetStorage() InterfaceStorage {
+	return this.storage
+}
+
+// 读取文件内容，用完需要Close
+func (this *FileClass) Open() (io.ReadCloser, error) {
+	return this.storage.Open(this.Key)
+}
+
+// 从存储后端删除
+func (this *FileClass) Delete() error {
+	return this.storage.Delete(this.Key)
+}
+
+// 保留文件。参数校验策略在请求结束后删除没有保留的文件
+func (this *FileClass) Keep() {
+	if this.kept == nil {
+		this.kept = new(bool)
+	}
+	*this.kept = true
+}
+
+func (this *FileClass) IsKept() bool {
+	return this.kept != nil && *this.kept
+}
+
+var fileType = reflect.TypeOf(FileClass{})
+
+// 是否是上传文件字段，支持 FileClass、*FileClass、[]*FileClass。参数校验和文档都按这个判断
+func IsFileType(type_ reflect.Type) bool {
+	if type_.Kind() == reflect.Slice {
+		type_ = type_.Elem()
+	}
+	if type_.Kind() == reflect.Ptr {
+		type_ = type_.Elem()
+	}
+	return type_ == fileType
+}
@@ -0,0 +1,223 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// 默认请求体大小上限
+const DefaultMaxSize int64 = 32 << 20
+
+var ErrTooLarge = errors.New(`request body too large`)
+
+// 文件类型不在允许列表中
+type FileTypeError struct {
+	FieldName   string
+	ContentType string
+	Allowed     []string
+}
+
+func (this *FileTypeError) Error() string {
+	return fmt.Sprintf(`file type %s of field %s is not allowed, allowed: [%s]`, this.ContentType, this.FieldName, strings.Join(this.Allowed, `, `))
+}
+
+// 单个文件超过字段的大小上限，或者文件个数超过字段的上限
+type FileLimitError struct {
+	FieldName string
+	MaxSize   int64
+	MaxCount  int64
+}
+
+func (this *FileLimitError) Error() string {
+	if this.MaxCount > 0 {
+		return fmt.Sprintf(`file count of field %s exceeds %d`, this.FieldName, this.MaxCount)
+	}
+	return fmt.Sprintf(`file size of field %s exceeds %d`, this.FieldName, this.MaxSize)
+}
+
+// 声明的文件字段的限制，写入存储时就检查
+type FieldOption struct {
+	MaxSize  int64 // 单个文件的大小上限，0 表示不限制
+	MaxCount int64 // 最多上传的文件数，0 表示不限制
+}
+
+type ReceiveOption struct {
+	MaxSize      int64                  // 整个请求体的大小上限，0 表示 DefaultMaxSize
+	AllowedTypes []string               // 允许的文件类型（嗅探结果），支持 image/* 这样的通配，空表示不限制
+	Storage      InterfaceStorage       // 文件保存到这里
+	Fields       map[string]FieldOption // 声明的文件字段。不为nil的话只保存这些字段的文件，其他字段的文件直接丢弃
+}
+
+// 流式读取 multipart 请求，普通字段放到 values，文件边读边写入存储后端，不会整个读入内存。
+// 出错的话已经保存的文件会被删除
+func Receive(request *http.Request, option ReceiveOption) (values map[string][]string, files map[string][]*FileClass, err error) {
+	maxSize := option.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	limiter := &limitReader{
+		reader: request.Body,
+		limit:  maxSize,
+	}
+	request.Body = ioutil.NopCloser(limiter)
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values = map[string][]string{}
+	files = map[string][]*FileClass{}
+	received := files // 出错返回时 files 已经被置为nil，用它删除已经保存的文件
+	defer func() {
+		if limiter.exceeded { // multipart 会包装读取错误，这里统一成 ErrTooLarge
+			err = ErrTooLarge
+		}
+		if err != nil {
+			for _, fieldFiles := range received {
+				for _, file := range fieldFiles {
+					file.Delete()
+				}
+			}
+			values, files = nil, nil
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return values, files, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		fieldName := part.FormName()
+		if fieldName == `` {
+			continue
+		}
+		if part.FileName() == `` {
+			value, err := ioutil.ReadAll(part)
+			if err != nil {
+				return nil, nil, err
+			}
+			values[fieldName] = append(values[fieldName], string(value))
+			continue
+		}
+		fieldOption, declared := option.Fields[fieldName]
+		if option.Fields != nil && !declared { // 没有声明的文件字段不保存
+			continue
+		}
+		if fieldOption.MaxCount > 0 && int64(len(files[fieldName])) >= fieldOption.MaxCount {
+			return nil, nil, &FileLimitError{
+				FieldName: fieldName,
+				MaxCount:  fieldOption.MaxCount,
+			}
+		}
+		file, err := receiveFile(part, option, fieldOption.MaxSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		files[fieldName] = append(files[fieldName], file)
+	}
+}
+
+func receiveFile(part *multipart.Part, option ReceiveOption, maxSize int64) (*FileClass, error) {
+	file := &FileClass{
+		FieldName:           part.FormName(),
+		FileName:            filepath.Base(part.FileName()),
+		DeclaredContentType: part.Header.Get(`Content-Type`),
+		storage:             option.Storage,
+		kept:                new(bool),
+	}
+
+	// 先读出开头用于嗅探类型，不允许的类型不写入存储
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	file.ContentType = http.DetectContentType(head) // 只按内容嗅探，扩展名由客户端决定，不可信
+	if len(option.AllowedTypes) > 0 && !MatchContentType(file.ContentType, option.AllowedTypes) {
+		return nil, &FileTypeError{
+			FieldName:   file.FieldName,
+			ContentType: file.ContentType,
+			Allowed:     option.AllowedTypes,
+		}
+	}
+
+	file.Key = newKey(file.FileName)
+	var reader io.Reader = io.MultiReader(bytes.NewReader(head), part)
+	var fileLimiter *limitReader
+	if maxSize > 0 { // 超过字段的大小上限时停止写入
+		fileLimiter = &limitReader{
+			reader: reader,
+			limit:  maxSize,
+		}
+		reader = fileLimiter
+	}
+	size, err := option.Storage.Save(file.Key, reader)
+	if fileLimiter != nil && fileLimiter.exceeded {
+		file.Delete()
+		return nil, &FileLimitError{
+			FieldName: file.FieldName,
+			MaxSize:   maxSize,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	file.Size = size
+	return file, nil
+}
+
+// 类型是否在列表中，忽略参数（如 charset），支持 image/* 这样的通配
+func MatchContentType(contentType string, allowed []string) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, `;`)[0])
+	for _, pattern := range allowed {
+		if pattern == `*/*` || pattern == mediaType {
+			return true
+		}
+		if strings.HasSuffix(pattern, `/*`) && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, `*`)) {
+			return true
+		}
+	}
+	return false
+}
+
+func newKey(fileName string) string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	ext := filepath.Ext(fileName)
+	if len(ext) > 16 || strings.ContainsAny(ext, `/\`) {
+		ext = ``
+	}
+	return hex.EncodeToString(buf) + ext
+}
+
+// 超过上限后读取报错，并记录下来
+type limitReader struct {
+	reader   io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (this *limitReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	this.read += int64(n)
+	if this.read > this.limit {
+		this.exceeded = true
+		return n, ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,94 @@
+package upload_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	global_api_strategy "github.com/pefish/go-core/global-api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+	"github.com/pefish/go-core/upload"
+	go_error "github.com/pefish/go-error"
+)
+
+type uploadParam struct {
+	Title  string              `json:"title" validate:"required"`
+	Tags   []string            `json:"tags"`
+	Avatar *upload.FileClass   `json:"avatar" validate:"required,file-max-size=1024,file-type=image/png image/jpeg"`
+	Extras []*upload.FileClass `json:"extras" validate:"file-max-count=2"`
+}
+
+// 只保存声明的文件字段和控制器 Keep 的文件，校验失败或者超过上限时删除已保存的文件
+func TestReceive_Route(t *testing.T) {
+	storage := upload.NewMemoryStorage()
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:             `/upload`,
+			Method:           api_session.ApiMethod_Post,
+			ParamType:        global_api_strategy.MULTIPART_TYPE,
+			MaxUploadSize:    4096,
+			AllowedFileTypes: []string{`image/*`, `text/plain`},
+			UploadStorage:    storage,
+			TypedController: func(apiSession *api_session.ApiSessionClass, params uploadParam) (map[string]interface{}, error) {
+				reader, err := params.Avatar.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer reader.Close()
+				content, err := ioutil.ReadAll(reader)
+				if err != nil {
+					return nil, err
+				}
+				params.Avatar.Keep()
+				return map[string]interface{}{
+					`title`:  params.Title,
+					`tags`:   len(params.Tags),
+					`type`:   params.Avatar.ContentType,
+					`size`:   len(content),
+					`extras`: len(params.Extras),
+				}, nil
+			},
+		},
+	})
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+
+	result := map[string]interface{}{}
+	client.Post(`/upload`).
+		WithMultipartValues(map[string]string{`title`: `avatar`}).
+		WithFile(`avatar`, `a.png`, png).
+		WithFile(`extras`, `a.txt`, []byte(`hello`)).
+		Do().AssertCode(0).ScanData(&result)
+	if result[`title`] != `avatar` || result[`type`] != `image/png` || result[`size`] != float64(len(png)) || result[`extras`] != float64(1) {
+		t.Errorf(`unexpected result %v`, result)
+	}
+	// 只保留控制器 Keep 的文件
+	if storage.Count() != 1 {
+		t.Errorf(`expect 1 stored file, got %d`, storage.Count())
+	}
+	// 没有声明的文件字段不保存
+	client.Post(`/upload`).WithMultipartValues(map[string]string{`title`: `a`}).WithFile(`avatar`, `a.png`, png).WithFile(`other`, `b.png`, png).Do().AssertCode(0)
+	if storage.Count() != 2 {
+		t.Errorf(`expect 2 stored files, got %d`, storage.Count())
+	}
+
+	// 类型不在路由允许列表中，不会写入存储
+	client.Post(`/upload`).WithMultipartValues(map[string]string{`title`: `a`}).WithFile(`avatar`, `a.pdf`, []byte(`%PDF-1.4`)).Do().AssertCode(go_error.INTERNAL_ERROR_CODE)
+	// 嗅探不出类型的内容不按扩展名放行
+	client.Post(`/upload`).WithMultipartValues(map[string]string{`title`: `a`}).WithFile(`avatar`, `x.png`, []byte{0x00, 0x01, 0x02, 0xfe}).Do().AssertCode(go_error.INTERNAL_ERROR_CODE).AssertMsg(`file type application/octet-stream of field avatar is not allowed, allowed: [image/*, text/plain]`)
+	// 类型不满足字段的校验规则，已保存的文件被删除
+	client.Post(`/upload`).WithMultipartValues(map[string]string{`title`: `a`}).WithFile(`avatar`, `a.txt`, []byte(`hello`)).Do().AssertCode(go_error.INTERNAL_ERROR_CODE)
+	// 超过字段的大小上限，写入时就停止
+	client.Post(`/upload`).WithMultipartValues(map[string]string{`title`: `a`}).WithFile(`avatar`, `a.png`, append(png, make([]byte, 2000)...)).Do().AssertCode(go_error.INTERNAL_ERROR_CODE).AssertMsg(`file size of field avatar exceeds 1024`)
+	// 超过字段的文件个数上限
+	client.Post(`/upload`).WithMultipartValues(map[string]string{`title`: `a`}).WithFile(`avatar`, `a.png`, png).
+		WithFile(`extras`, `1.txt`, []byte(`1`)).WithFile(`extras`, `2.txt`, []byte(`2`)).WithFile(`extras`, `3.txt`, []byte(`3`)).
+		Do().AssertCode(go_error.INTERNAL_ERROR_CODE).AssertMsg(`file count of field extras exceeds 2`)
+	// 超过请求体大小上限
+	client.Post(`/upload`).WithMultipartValues(map[string]string{`title`: `a`}).WithFile(`other`, `b.png`, append(png, make([]byte, 5000)...)).Do().AssertCode(go_error.INTERNAL_ERROR_CODE).AssertMsg(`request body too large`)
+	// 缺少文件
+	client.Post(`/upload`).WithMultipartValues(map[string]string{`title`: `a`}).Do().AssertCode(go_error.INTERNAL_ERROR_CODE)
+	if storage.Count() != 2 {
+		t.Errorf(`failed uploads should not be kept, got %d stored files`, storage.Count())
+	}
+}
@@ -0,0 +1,139 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 上传文件的存储后端
+type InterfaceStorage interface {
+	// 把reader中的内容保存到key，返回写入的字节数
+	Save(key string, reader io.Reader) (int64, error)
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// 本地磁盘存储，文件保存在 dir 下
+type LocalStorageClass struct {
+	dir string
+}
+
+func NewLocalStorage(dir string) *LocalStorageClass {
+	return &LocalStorageClass{
+		dir: dir,
+	}
+}
+
+func (this *LocalStorageClass) GetDir() string {
+	return this.dir
+}
+
+func (this *LocalStorageClass) Save(key string, reader io.Reader) (int64, error) {
+	path, err := this.getPath(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return size, nil
+}
+
+func (this *LocalStorageClass) Open(key string) (io.ReadCloser, error) {
+	path, err := this.getPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (this *LocalStorageClass) Delete(key string) error {
+	path, err := this.getPath(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// key 不能跳出存储目录
+func (this *LocalStorageClass) getPath(key string) (string, error) {
+	path := filepath.Join(this.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(this.dir)+string(filepath.Separator)) {
+		return ``, errors.New(`invalid storage key ` + key)
+	}
+	return path, nil
+}
+
+// 内存存储，主要用于测试
+type MemoryStorageClass struct {
+	lock  sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorageClass {
+	return &MemoryStorageClass{
+		files: map[string][]byte{},
+	}
+}
+
+func (this *MemoryStorageClass) Save(key string, reader io.Reader) (int64, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.files[key] = content
+	return int64(len(content)), nil
+}
+
+func (this *MemoryStorageClass) Open(key string) (io.ReadCloser, error) {
+	content, ok := this.Get(key)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (this *MemoryStorageClass) Delete(key string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.files[key]; !ok {
+		return os.ErrNotExist
+	}
+	delete(this.files, key)
+	return nil
+}
+
+// 获取保存的内容
+func (this *MemoryStorageClass) Get(key string) ([]byte, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	content, ok := this.files[key]
+	return content, ok
+}
+
+// 保存的文件数
+func (this *MemoryStorageClass) Count() int {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return len(this.files)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-playground/validator"
+	"github.com/pefish/go-core/upload"
 	"github.com/pefish/go-decimal"
 	"github.com/pefish/go-error"
 	"github.com/pefish/go-reflect"
@@ -135,3 +136,69 @@ func (this *ValidatorClass) CheckInjectWithErr(str string) error {
 	}
 	return nil
 }
+
+// 文件字段的校验规则，见 ValidateFiles
+type FileRules struct {
+	Required bool
+	MaxSize  int64    // 0 表示不限制
+	Types    []string // 空表示不限制
+	MaxCount int64    // 0 表示不限制
+}
+
+// 解析文件字段的校验规则，数值不合法的话返回错误。服务启动前检查配置时会调用
+func ParseFileRules(tag string) (FileRules, error) {
+	rules := FileRules{}
+	for _, rule := range strings.Split(tag, `,`) {
+		name, param := rule, ``
+		if index := strings.Index(rule, `=`); index != -1 {
+			name, param = rule[:index], rule[index+1:]
+		}
+		switch name {
+		case `required`:
+			rules.Required = true
+		case `file-max-size`, `file-max-count`:
+			value, err := go_reflect.Reflect.ToInt64(param)
+			if err != nil || value <= 0 {
+				return rules, fmt.Errorf(`%s must be a positive integer, got %s`, name, param)
+			}
+			if name == `file-max-size` {
+				rules.MaxSize = value
+			} else {
+				rules.MaxCount = value
+			}
+		case `file-type`:
+			rules.Types = strings.Fields(param)
+		}
+	}
+	return rules, nil
+}
+
+/**
+校验上传的文件。tag 与 validate 标签同格式，支持：
+	required               至少上传一个文件
+	file-max-size=字节数    每个文件的大小上限
+	file-type=类型1 类型2   允许的类型（按内容嗅探），支持 image/* 通配
+	file-max-count=个数     同一字段最多上传的文件数
+其他校验规则对文件字段不生效
+*/
+func (this *ValidatorClass) ValidateFiles(files []*upload.FileClass, tag string) error {
+	rules, err := ParseFileRules(tag)
+	if err != nil {
+		return err
+	}
+	if rules.Required && len(files) == 0 {
+		return errors.New(`file is required`)
+	}
+	for _, file := range files {
+		if rules.MaxSize > 0 && file.GetSize() > rules.MaxSize {
+			return fmt.Errorf(`file size %d exceeds %d`, file.GetSize(), rules.MaxSize)
+		}
+		if len(rules.Types) > 0 && !upload.MatchContentType(file.GetContentType(), rules.Types) {
+			return fmt.Errorf(`file type %s is not allowed, allowed: [%s]`, file.GetContentType(), strings.Join(rules.Types, `, `))
+		}
+	}
+	if rules.MaxCount > 0 && int64(len(files)) > rules.MaxCount {
+		return fmt.Errorf(`file count %d exceeds %d`, len(files), rules.MaxCount)
+	}
+	return nil
+}
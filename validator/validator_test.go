@@ -1,9 +1,31 @@
 package validator
 
 import (
+	"reflect"
 	"testing"
 )
 
 func TestValidatorClass_Test(t *testing.T) {
 
 }
+
+func TestParseFileRules(t *testing.T) {
+	rules, err := ParseFileRules(`required,file-max-size=1024,file-type=image/png image/*,file-max-count=2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := FileRules{
+		Required: true,
+		MaxSize:  1024,
+		Types:    []string{`image/png`, `image/*`},
+		MaxCount: 2,
+	}
+	if !reflect.DeepEqual(rules, expect) {
+		t.Errorf(`expect %v, got %v`, expect, rules)
+	}
+	for _, tag := range []string{`file-max-size=1k`, `file-max-count=`, `file-max-size=-1`} {
+		if _, err := ParseFileRules(tag); err == nil {
+			t.Errorf(`tag %s should be rejected`, tag)
+		}
+	}
+}
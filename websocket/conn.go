@@ -0,0 +1,198 @@
+// WebSocket 连接
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	go_application "github.com/pefish/go-application"
+)
+
+const (
+	TextMessage   = gorilla.TextMessage
+	BinaryMessage = gorilla.BinaryMessage
+
+	CloseNormalClosure     = gorilla.CloseNormalClosure
+	CloseGoingAway         = gorilla.CloseGoingAway
+	CloseInternalServerErr = gorilla.CloseInternalServerErr
+)
+
+// 连接配置，零值使用默认值
+type OptionClass struct {
+	ReadLimit       int64                      // 单条消息大小上限，默认 64K
+	PingInterval    time.Duration              // 发送 ping 的间隔，默认 30 秒，负数表示不发送
+	PongTimeout     time.Duration              // 多久没有收到任何消息（包括 pong）就断开，默认 PingInterval 的两倍
+	WriteTimeout    time.Duration              // 写超时，默认 10 秒
+	ReadBufferSize  int                        // 读缓冲大小，默认 4K
+	WriteBufferSize int                        // 写缓冲大小，默认 4K
+	CheckOrigin     func(r *http.Request) bool // 检查 Origin，默认只允许同源
+}
+
+func (this OptionClass) withDefault() OptionClass {
+	if this.ReadLimit == 0 {
+		this.ReadLimit = 64 << 10
+	}
+	if this.PingInterval == 0 {
+		this.PingInterval = 30 * time.Second
+	}
+	if this.PongTimeout == 0 && this.PingInterval > 0 {
+		this.PongTimeout = 2 * this.PingInterval
+	}
+	if this.WriteTimeout == 0 {
+		this.WriteTimeout = 10 * time.Second
+	}
+	return this
+}
+
+type ConnClass struct {
+	conn      *gorilla.Conn
+	option    OptionClass
+	writeLock sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// 把请求升级成 WebSocket 连接。失败的话已经向客户端返回了错误响应
+func Upgrade(writer http.ResponseWriter, request *http.Request, option OptionClass) (*ConnClass, error) {
+	option = option.withDefault()
+	upgrader := gorilla.Upgrader{
+		ReadBufferSize:  option.ReadBufferSize,
+		WriteBufferSize: option.WriteBufferSize,
+		CheckOrigin:     option.CheckOrigin,
+	}
+	conn, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, option), nil
+}
+
+// 包装已经建立的连接，开始保活。服务端和测试客户端都使用
+func NewConn(conn *gorilla.Conn, option OptionClass) *ConnClass {
+	option = option.withDefault()
+	ctx, cancel := context.WithCancel(context.Background())
+	this := &ConnClass{
+		conn:   conn,
+		option: option,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	conn.SetReadLimit(option.ReadLimit)
+	this.extendReadDeadline()
+	conn.SetPongHandler(func(string) error {
+		this.extendReadDeadline()
+		return nil
+	})
+	go this.keepalive()
+	return this
+}
+
+func (this *ConnClass) keepalive() {
+	var ticks <-chan time.Time
+	if this.option.PingInterval > 0 {
+		ticker := time.NewTicker(this.option.PingInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-ticks:
+			this.writeLock.Lock()
+			err := this.conn.WriteControl(gorilla.PingMessage, nil, time.Now().Add(this.option.WriteTimeout))
+			this.writeLock.Unlock()
+			if err != nil {
+				this.cancel()
+				this.conn.Close()
+				return
+			}
+		case <-go_application.Application.OnFinished(): // 应用退出时通知客户端后关闭
+			this.Close(CloseGoingAway, `server shutdown`)
+			return
+		case <-this.ctx.Done():
+			return
+		}
+	}
+}
+
+func (this *ConnClass) extendReadDeadline() {
+	if this.option.PongTimeout > 0 {
+		this.conn.SetReadDeadline(time.Now().Add(this.option.PongTimeout))
+	}
+}
+
+// 连接关闭时关闭
+func (this *ConnClass) Done() <-chan struct{} {
+	return this.ctx.Done()
+}
+
+func (this *ConnClass) Context() context.Context {
+	return this.ctx
+}
+
+// 读取一条消息
+func (this *ConnClass) ReadMessage() (messageType int, data []byte, err error) {
+	messageType, data, err = this.conn.ReadMessage()
+	if err != nil {
+		this.cancel()
+		return 0, nil, err
+	}
+	this.extendReadDeadline()
+	return messageType, data, nil
+}
+
+// 读取一条消息并按 json 解析到 dest
+func (this *ConnClass) ReadJson(dest interface{}) error {
+	_, data, err := this.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// 发送一条消息，可以在多个goroutine中调用
+func (this *ConnClass) WriteMessage(messageType int, data []byte) error {
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+	this.conn.SetWriteDeadline(time.Now().Add(this.option.WriteTimeout))
+	if err := this.conn.WriteMessage(messageType, data); err != nil {
+		this.cancel()
+		return err
+	}
+	return nil
+}
+
+// 按 json 序列化后以文本消息发送
+func (this *ConnClass) WriteJson(data interface{}) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return this.WriteMessage(TextMessage, dataBytes)
+}
+
+// 发送关闭帧后关闭连接，只执行一次
+func (this *ConnClass) Close(code int, reason string) error {
+	var err error
+	this.closeOnce.Do(func() {
+		this.writeLock.Lock()
+		this.conn.WriteControl(gorilla.CloseMessage, gorilla.FormatCloseMessage(code, reason), time.Now().Add(this.option.WriteTimeout))
+		this.writeLock.Unlock()
+		this.cancel()
+		err = this.conn.Close()
+	})
+	return err
+}
+
+func (this *ConnClass) RemoteAddr() string {
+	return this.conn.RemoteAddr().String()
+}
+
+// 是否是正常关闭（客户端主动关闭或离开）
+func IsNormalClose(err error) bool {
+	return gorilla.IsCloseError(err, CloseNormalClosure, CloseGoingAway)
+}
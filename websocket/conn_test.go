@@ -0,0 +1,79 @@
+package websocket_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/pefish/go-core/api"
+	api_session "github.com/pefish/go-core/api-session"
+	api_strategy "github.com/pefish/go-core/api-strategy"
+	test_client "github.com/pefish/go-core/test-client"
+	"github.com/pefish/go-core/websocket"
+	go_error "github.com/pefish/go-error"
+)
+
+// 升级前执行策略，鉴权失败返回统一结构；控制器返回的错误作为结果发送后以 1011 关闭
+func TestConnClass_Route(t *testing.T) {
+	_, pubKey := test_client.GetTestKeyPair()
+	jwtStrategy := api_strategy.NewJwtAuthStrategy()
+	jwtStrategy.SetPubKey(pubKey)
+	jwtStrategy.SetHeaderName(`Json-Web-Token`)
+	jwtStrategy.SetErrorCode(2001)
+
+	type message struct {
+		Text string `json:"text"`
+	}
+	_, client := test_client.NewTestService(t, []*api.Api{
+		{
+			Path:   `/ws`,
+			Method: api_session.ApiMethod_Get,
+			Strategies: []api_strategy.StrategyData{
+				{
+					Strategy: jwtStrategy,
+				},
+			},
+			WebSocketController: func(apiSession *api_session.ApiSessionClass, conn *websocket.ConnClass) error {
+				for {
+					msg := message{}
+					if err := conn.ReadJson(&msg); err != nil {
+						return err
+					}
+					if msg.Text == `bye` {
+						return &go_error.ErrorInfo{
+							ErrorMessage: `bye`,
+							ErrorCode:    3002,
+						}
+					}
+					if err := conn.WriteJson(message{Text: `echo ` + msg.Text}); err != nil {
+						return err
+					}
+				}
+			},
+		},
+	})
+
+	_, response, err := client.Get(`/ws`).DialWebSocket()
+	if err == nil || response == nil {
+		t.Fatalf(`expect dial to be rejected`)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	if !strings.Contains(string(body), `"code":2001`) {
+		t.Errorf(`unexpected body %s`, body)
+	}
+
+	conn := client.Get(`/ws`).WithJwt(map[string]interface{}{`user_id`: 1}).MustDialWebSocket()
+	reply := message{}
+	conn.Send(message{Text: `hi`}).Receive(&reply)
+	if reply.Text != `echo hi` {
+		t.Errorf(`unexpected reply %s`, reply.Text)
+	}
+	if result := conn.Send(message{Text: `bye`}).ReceiveApiResult(); result.Code != 3002 {
+		t.Errorf(`unexpected result %#v`, result)
+	}
+	_, _, err = conn.ReadMessage()
+	if closeErr, ok := err.(*gorilla.CloseError); !ok || closeErr.Code != websocket.CloseInternalServerErr {
+		t.Errorf(`expect close error 1011, got %v`, err)
+	}
+}